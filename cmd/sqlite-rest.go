@@ -9,7 +9,9 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/paradoxe35/sqlite-rest/pkg/config"
 	"github.com/paradoxe35/sqlite-rest/pkg/controllers"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
 	"github.com/paradoxe35/sqlite-rest/pkg/middleware"
 )
 
@@ -25,6 +27,8 @@ var help = flag.Bool("help", false, "Show help")
 var version = flag.Bool("version", false, "Show version")
 var port = flag.String("p", DEFAULT_PORT, "Port to listen on")
 var dbPath = flag.String("f", DEFAULT_DB_PATH, "Path to sqlite database file")
+var configPath = flag.String("config", os.Getenv("SQLITE_REST_CONFIG"), "Path to a JSON config file for pool and pragma settings")
+var settings = config.RegisterFlags(flag.CommandLine)
 
 func main() {
 	flag.Parse()
@@ -63,29 +67,67 @@ func main() {
 	}
 	log.Printf("Using database in %s\n", *dbPath)
 
+	// Load pool and pragma settings from the config file, environment and flags
+	poolConfig, err := settings.Load(*configPath)
+	if err != nil {
+		log.Fatal("Error loading configuration. " + err.Error())
+	}
+
+	// Open the shared connection pools used by every handler
+	pool, err := db.NewPool(*dbPath, poolConfig)
+	if err != nil {
+		log.Fatal("Error opening database. " + err.Error())
+	}
+	defer pool.Close()
+
 	// Create a custom router that can handle both API and data routes
 	router := middleware.NewCustomRouter()
 
 	// Metadata endpoints
-	router.GET("/__/tables", controllers.GetTables(*dbPath))
-	router.GET("/__/tables/:table", controllers.GetTableSchema(*dbPath))
-	router.GET("/__/tables/:table/foreign-keys", controllers.GetForeignKeys(*dbPath))
-	router.GET("/__/db", controllers.GetDatabaseInfo(*dbPath))
+	router.GET("/__/tables", controllers.GetTables(pool))
+	router.GET("/__/tables/:table", controllers.GetTableSchema(pool))
+	router.GET("/__/tables/:table/foreign-keys", controllers.GetForeignKeys(pool))
+	router.GET("/__/db", controllers.GetDatabaseInfo(pool))
 
 	// Utility endpoints
-	router.GET("/__/health", controllers.HealthCheck(*dbPath))
+	router.GET("/__/health", controllers.HealthCheck(pool))
 	router.GET("/__/version", controllers.GetApiVersion())
 
 	// SQL execution endpoint
-	router.OPTIONS("/__/exec", controllers.Exec(*dbPath))
+	router.OPTIONS("/__/exec", controllers.Exec(pool))
+
+	// Batch endpoint
+	router.POST("/__/batch", controllers.Batch(pool))
+
+	// Interactive transaction endpoints
+	router.POST("/__/tx", controllers.BeginTransaction(pool))
+	router.GET("/__/tx/:id", controllers.GetTransaction(pool))
+	router.POST("/__/tx/:id/commit", controllers.CommitTransaction(pool))
+	router.POST("/__/tx/:id/rollback", controllers.RollbackTransaction(pool))
+	router.POST("/__/tx/:id/savepoints", controllers.CreateSavepoint(pool))
+	router.POST("/__/tx/:id/savepoints/:name/release", controllers.ReleaseSavepoint(pool))
+	router.POST("/__/tx/:id/savepoints/:name/rollback", controllers.RollbackToSavepoint(pool))
+
+	// Full-text search endpoints
+	router.POST("/__/tables/:table/search-index", controllers.CreateSearchIndex(pool))
+	router.GET("/__/tables/:table/search-index", controllers.GetSearchIndex(pool))
+	router.DELETE("/__/tables/:table/search-index", controllers.DropSearchIndex(pool))
+	router.POST("/__/tables/:table/search-index/rebuild", controllers.RebuildSearchIndex(pool))
+	router.POST("/__/tables/:table/search-index/optimize", controllers.OptimizeSearchIndex(pool))
+	router.GET("/__/search/:table", controllers.Search(pool))
+
+	// Change feed endpoint
+	router.GET("/__/changes", controllers.Changes(pool))
 
 	// Core CRUD endpoints
-	router.GET("/:table", controllers.GetAll(*dbPath))
-	router.GET("/:table/:id", controllers.Get(*dbPath))
-	router.POST("/:table", controllers.Create(*dbPath))
-	router.PATCH("/:table/:id", controllers.Update(*dbPath))
-	// router.PUT("/:table/:id", controllers.Update(*dbPath))
-	router.DELETE("/:table/:id", controllers.Delete(*dbPath))
+	router.GET("/:table", controllers.GetAll(pool))
+	router.GET("/:table/:id", controllers.Get(pool))
+	router.POST("/:table", controllers.Create(pool))
+	router.PATCH("/:table", controllers.UpdateAll(pool))
+	router.PATCH("/:table/:id", controllers.Update(pool))
+	router.PUT("/:table/:id", controllers.Replace(pool))
+	router.DELETE("/:table", controllers.DeleteAll(pool))
+	router.DELETE("/:table/:id", controllers.Delete(pool))
 
 	// Check if authentication is enabled
 	username := os.Getenv("SQLITE_REST_USERNAME")
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// EnvPrefix is prepended to a setting name to build its environment variable
+const EnvPrefix = "SQLITE_REST_"
+
+// setting is a configuration value that can be read from the config file
+// (as "name"), the environment (as SQLITE_REST_NAME) or a flag (as -name
+// with dashes instead of underscores)
+type setting struct {
+	name  string
+	usage string
+	set   func(c *db.PoolConfig, value string) error
+}
+
+var settings = []setting{
+	{"max_open_conns", "Maximum number of open reader connections", func(c *db.PoolConfig, v string) error {
+		return parseInt(v, &c.MaxOpenConns)
+	}},
+	{"max_idle_conns", "Maximum number of idle reader connections", func(c *db.PoolConfig, v string) error {
+		return parseInt(v, &c.MaxIdleConns)
+	}},
+	{"conn_max_lifetime", "Maximum lifetime of a pooled connection (e.g. 30m), 0 keeps connections open", func(c *db.PoolConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		c.ConnMaxLifetime = d
+		return nil
+	}},
+	{"max_transactions", "Maximum number of interactive transactions open at once, 0 disables them", func(c *db.PoolConfig, v string) error {
+		return parseInt(v, &c.MaxTransactions)
+	}},
+	{"tx_idle_timeout", "Idle time after which an interactive transaction is rolled back (e.g. 30s)", func(c *db.PoolConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("must be positive")
+		}
+		c.TxIdleTimeout = d
+		return nil
+	}},
+	{"change_buffer", "Number of committed changes kept for clients of /__/changes resuming a stream, 0 disables the change feed", func(c *db.PoolConfig, v string) error {
+		if err := parseInt(v, &c.ChangeBuffer); err != nil {
+			return err
+		}
+		if c.ChangeBuffer < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+		return nil
+	}},
+	{"journal_mode", "SQLite journal mode: delete, truncate, persist, memory, wal or off", func(c *db.PoolConfig, v string) error {
+		c.Pragmas.JournalMode = v
+		return nil
+	}},
+	{"busy_timeout", "Milliseconds a connection waits for a lock before failing", func(c *db.PoolConfig, v string) error {
+		return parseInt(v, &c.Pragmas.BusyTimeout)
+	}},
+	{"foreign_keys", "Enforce foreign key constraints", func(c *db.PoolConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Pragmas.ForeignKeys = b
+		return nil
+	}},
+	{"synchronous", "SQLite synchronous mode: off, normal, full or extra", func(c *db.PoolConfig, v string) error {
+		c.Pragmas.Synchronous = v
+		return nil
+	}},
+	{"cache_size", "Page cache size, negative values are in KiB", func(c *db.PoolConfig, v string) error {
+		return parseInt(v, &c.Pragmas.CacheSize)
+	}},
+	{"mmap_size", "Maximum number of bytes of the database to memory map, 0 disables it", func(c *db.PoolConfig, v string) error {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.Pragmas.MmapSize = i
+		return nil
+	}},
+	{"temp_store", "Where temporary tables are stored: default, file or memory", func(c *db.PoolConfig, v string) error {
+		c.Pragmas.TempStore = v
+		return nil
+	}},
+}
+
+func parseInt(value string, target *int) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*target = i
+	return nil
+}
+
+// Loader collects settings from the config file, the environment and the
+// command line flags, in increasing order of precedence
+type Loader struct {
+	flags map[string]string
+}
+
+// RegisterFlags declares a flag for every setting on fs
+func RegisterFlags(fs *flag.FlagSet) *Loader {
+	loader := &Loader{flags: make(map[string]string)}
+
+	for _, s := range settings {
+		name := s.name
+		fs.Func(strings.ReplaceAll(name, "_", "-"), s.usage, func(value string) error {
+			loader.flags[name] = value
+			return nil
+		})
+	}
+
+	return loader
+}
+
+// Load returns the pool configuration built from the defaults, the JSON
+// config file at path (if not empty), the environment and the flags
+func (l *Loader) Load(path string) (db.PoolConfig, error) {
+	config := db.DefaultPoolConfig()
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return config, err
+		}
+		if err := apply(&config, values, "config file "+path); err != nil {
+			return config, err
+		}
+	}
+
+	env := make(map[string]string)
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(s.name)); ok {
+			env[s.name] = value
+		}
+	}
+	if err := apply(&config, env, "environment"); err != nil {
+		return config, err
+	}
+
+	if err := apply(&config, l.flags, "flags"); err != nil {
+		return config, err
+	}
+
+	return config, config.Pragmas.Validate()
+}
+
+// readFile reads a flat JSON object of setting names to values
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep numbers as written so large integers are not turned into floats
+	raw := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err.Error())
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		values[name] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// apply sets every known setting found in values
+func apply(config *db.PoolConfig, values map[string]string, source string) error {
+	known := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		known[s.name] = s
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s, ok := known[name]
+		if !ok {
+			return fmt.Errorf("unknown setting %q in %s", name, source)
+		}
+		if err := s.set(config, values[name]); err != nil {
+			return fmt.Errorf("invalid %s in %s: %s", name, source, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"journal_mode": "delete", "busy_timeout": 1000, "mmap_size": 268435456, "synchronous": "full", "conn_max_lifetime": "10m"}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("SQLITE_REST_BUSY_TIMEOUT", "2000")
+	t.Setenv("SQLITE_REST_SYNCHRONOUS", "extra")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-synchronous", "off", "-foreign-keys=false"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	config, err := loader.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if config.Pragmas.JournalMode != "delete" {
+		t.Errorf("Expected journal_mode from file, got %s", config.Pragmas.JournalMode)
+	}
+	if config.Pragmas.MmapSize != 268435456 {
+		t.Errorf("Expected mmap_size from file, got %d", config.Pragmas.MmapSize)
+	}
+	if config.ConnMaxLifetime != 10*time.Minute {
+		t.Errorf("Expected conn_max_lifetime from file, got %s", config.ConnMaxLifetime)
+	}
+	if config.Pragmas.BusyTimeout != 2000 {
+		t.Errorf("Expected busy_timeout from environment, got %d", config.Pragmas.BusyTimeout)
+	}
+	if config.Pragmas.Synchronous != "off" {
+		t.Errorf("Expected synchronous from flags, got %s", config.Pragmas.Synchronous)
+	}
+	if config.Pragmas.ForeignKeys {
+		t.Errorf("Expected foreign_keys to be disabled by flags")
+	}
+	if config.Pragmas.TempStore != "default" {
+		t.Errorf("Expected default temp_store, got %s", config.Pragmas.TempStore)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := map[string]string{
+		"SQLITE_REST_JOURNAL_MODE":  "sideways",
+		"SQLITE_REST_BUSY_TIMEOUT":  "soon",
+		"SQLITE_REST_CHANGE_BUFFER": "-1",
+	}
+
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+
+			loader := RegisterFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+			if _, err := loader.Load(""); err == nil {
+				t.Errorf("Expected %s=%s to be rejected", name, value)
+			}
+		})
+	}
+}
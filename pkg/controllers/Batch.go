@@ -0,0 +1,558 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// BatchBody is the request body of a batch: the operations to run in order
+// and how failures are handled
+type BatchBody struct {
+	Operations []BatchOperation `json:"operations"`
+	OnError    string           `json:"on_error"`
+}
+
+// BatchOperation is either a request on a table route, given by its method,
+// path, headers and body, or a raw SQL query and its params
+type BatchOperation struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	Query   string            `json:"query"`
+	Params  interface{}       `json:"params"`
+}
+
+// statusError is an operation failure answered with a specific status
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// batchReference matches references to the results of earlier operations,
+// e.g. $0.id or $1.data.email
+var batchReference = regexp.MustCompile(`\$(\d+)((?:\.[A-Za-z0-9_]+)+)`)
+
+// resolveReference looks up a reference such as $0.id in the results of the
+// earlier operations
+func resolveReference(ref string, results []map[string]interface{}) (interface{}, error) {
+	match := batchReference.FindStringSubmatch(ref)
+	index, err := strconv.Atoi(match[1])
+	if err != nil || index >= len(results) {
+		return nil, &requestError{message: fmt.Sprintf("Reference %s must name an earlier operation", ref)}
+	}
+	if _, failed := results[index]["error"]; failed {
+		return nil, &requestError{message: fmt.Sprintf("Reference %s names operation %d which failed", ref, index)}
+	}
+
+	var value interface{} = results[index]
+	for _, field := range strings.Split(strings.TrimPrefix(match[2], "."), ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[field]
+		case []map[string]interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, &requestError{message: fmt.Sprintf("Reference %s not found", ref)}
+			}
+			value = v[i]
+		default:
+			return nil, &requestError{message: fmt.Sprintf("Reference %s not found", ref)}
+		}
+	}
+	if value == nil {
+		return nil, &requestError{message: fmt.Sprintf("Reference %s not found", ref)}
+	}
+	return value, nil
+}
+
+// resolvePathReferences replaces the references in an operation path with
+// their escaped values
+func resolvePathReferences(path string, results []map[string]interface{}) (string, error) {
+	var resolveErr error
+	resolve := func(escape func(string) string) func(string) string {
+		return func(ref string) string {
+			value, err := resolveReference(ref, results)
+			if err != nil {
+				if resolveErr == nil {
+					resolveErr = err
+				}
+				return ref
+			}
+			return escape(fmt.Sprint(value))
+		}
+	}
+
+	route, rawQuery, hasQuery := strings.Cut(path, "?")
+	route = batchReference.ReplaceAllStringFunc(route, resolve(url.PathEscape))
+	if hasQuery {
+		route += "?" + batchReference.ReplaceAllStringFunc(rawQuery, resolve(url.QueryEscape))
+	}
+	return route, resolveErr
+}
+
+// resolveBodyReferences replaces the string values of a body that are a
+// reference, such as "$0.id", with the referenced value
+func resolveBodyReferences(value interface{}, results []map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if loc := batchReference.FindStringIndex(v); loc != nil && loc[0] == 0 && loc[1] == len(v) {
+			return resolveReference(v, results)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			resolved, err := resolveBodyReferences(item, results)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	case []interface{}:
+		for i, item := range v {
+			resolved, err := resolveBodyReferences(item, results)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+	return value, nil
+}
+
+// operationStatus returns the status code and message of a failed operation
+func operationStatus(err error) (int, string) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status, statusErr.message
+	}
+	return writeErrorStatus(err), writeErrorMessage(err)
+}
+
+// runOperation runs one operation of a batch in its transaction on db. Raw
+// queries are checked against the exec policy.
+func runOperation(db database, tx transaction, policy *execPolicy, op BatchOperation, results []map[string]interface{}) (map[string]interface{}, error) {
+	if op.Query != "" {
+		if op.Method != "" || op.Path != "" {
+			return nil, &requestError{message: "An operation has either a query or a method and path"}
+		}
+		params, err := resolveBodyReferences(op.Params, results)
+		if err != nil {
+			return nil, err
+		}
+		return runStatement(db, tx, policy, op.Query, params)
+	}
+
+	path, err := resolvePathReferences(op.Path, results)
+	if err != nil {
+		return nil, err
+	}
+	method := strings.ToUpper(op.Method)
+	r, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		return nil, &requestError{message: fmt.Sprintf("Invalid operation path: %s", op.Path)}
+	}
+	for name, value := range op.Headers {
+		r.Header.Set(name, value)
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if segments[0] == "" || len(segments) > 2 {
+		return nil, &requestError{message: fmt.Sprintf("Invalid operation path: %s, expected /:table or /:table/:id", op.Path)}
+	}
+	table, err := resolveTable(tx, segments[0])
+	if err != nil {
+		return nil, err
+	}
+	var key *recordKey
+	if len(segments) == 2 {
+		key, err = table.ParseKey(keyParam(r, segments[1]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var data map[string]interface{}
+	if len(op.Body) > 0 && string(op.Body) != "null" {
+		var body interface{}
+		if err := decodeJSON(bytes.NewReader(op.Body), &body); err != nil {
+			return nil, &requestError{message: fmt.Sprintf("Invalid operation body: %s", err.Error())}
+		}
+		body, err = resolveBodyReferences(body, results)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if data, ok = body.(map[string]interface{}); !ok {
+			return nil, &requestError{message: "Operation body must be a JSON object"}
+		}
+	}
+
+	repr, err := table.Representation(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case method == http.MethodGet && key != nil:
+		return batchGet(tx, table, key, r)
+	case method == http.MethodGet:
+		return batchGetAll(tx, table, r)
+	case method == http.MethodPost && key == nil:
+		return batchCreate(tx, table, r, data, repr)
+	case method == http.MethodPatch:
+		return batchUpdate(tx, table, key, r, data, repr)
+	case method == http.MethodDelete:
+		return batchDelete(tx, table, key, r, repr)
+	}
+	return nil, &statusError{status: http.StatusMethodNotAllowed, message: fmt.Sprintf("Unsupported operation %s %s", method, op.Path)}
+}
+
+// runStatement runs a raw SQL query under the same policy as /__/exec
+func runStatement(db database, tx transaction, policy *execPolicy, query string, params interface{}) (map[string]interface{}, error) {
+	if isTransactionControl(query) {
+		return nil, &requestError{message: "Transaction statements are not allowed in a batch"}
+	}
+
+	args, err := parseExecParams(params)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	err = runAuthorized(db, policy, func() (err error) {
+		result, err = runExecStatement(db.conn(), tx, execStatement{query: query, args: args})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result["status"] = http.StatusOK
+	return result, nil
+}
+
+// batchGet reads a record by key
+func batchGet(tx transaction, table *tableSchema, key *recordKey, r *http.Request) (map[string]interface{}, error) {
+	columns, err := table.SelectList(r.URL.Query().Get("columns"))
+	if err != nil {
+		return nil, err
+	}
+	keyWhere, keyArgs := key.Where()
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s", columns, table.Quoted(), keyWhere), keyArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data, _, err := scanAll(rows, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, &statusError{status: http.StatusNotFound, message: fmt.Sprintf("Record with key %s not found", key)}
+	}
+	return map[string]interface{}{"status": http.StatusOK, "data": data[0]}, nil
+}
+
+// batchGetAll reads the records matching filters. Only the columns, filter,
+// order and limit parameters of GET /:table are supported, raw filters are
+// not since the batch transaction runs on the writer.
+func batchGetAll(tx transaction, table *tableSchema, r *http.Request) (map[string]interface{}, error) {
+	query := r.URL.Query()
+	for _, name := range []string{"count", "cursor", "filters_raw", "format", "group_by", "having"} {
+		if _, ok := query[name]; ok {
+			return nil, &requestError{message: fmt.Sprintf("The %s parameter is not supported in batches", name)}
+		}
+	}
+
+	list := query.Get("select")
+	if list == "" {
+		list = query.Get("cols")
+	}
+	columns, err := table.SelectList(list)
+	if err != nil {
+		return nil, err
+	}
+	where, args, err := buildWhere(query, table)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where
+	}
+	orderBy, err := table.OrderBy(query.Get("order_by"), query.Get("order_dir"))
+	if err != nil {
+		return nil, err
+	}
+
+	var limitClause string
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.ParseInt(limitParam, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, &requestError{message: fmt.Sprintf("Invalid limit parameter: %s", limitParam)}
+		}
+		limitClause = "LIMIT ?"
+		args = append(args, limit)
+		if offsetParam := query.Get("offset"); offsetParam != "" {
+			offset, err := strconv.ParseInt(offsetParam, 10, 64)
+			if err != nil || offset < 0 {
+				return nil, &requestError{message: fmt.Sprintf("Invalid offset parameter: %s", offsetParam)}
+			}
+			limitClause += " OFFSET ?"
+			args = append(args, offset)
+		}
+	} else if query.Get("offset") != "" {
+		return nil, &requestError{message: "Cannot use offset parameter without limit parameter"}
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s FROM %s %s %s %s", columns, table.Quoted(), where, orderBy, limitClause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data, _, err := scanAll(rows, 0)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = []map[string]interface{}{}
+	}
+	return map[string]interface{}{"status": http.StatusOK, "data": data, "total_rows": len(data)}, nil
+}
+
+// batchCreate inserts a record
+func batchCreate(tx transaction, table *tableSchema, r *http.Request, data map[string]interface{}, repr *representation) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, &requestError{message: "Missing data in request body"}
+	}
+	conflict, err := table.Conflict(r)
+	if err != nil {
+		return nil, err
+	}
+	opts := insertOptions{conflict: conflict, repr: repr}
+
+	columns, values, err := bindInsert(table, data)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare(insertSQL(table, columns, opts.conflict, opts.repr))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	inserted, err := execInsert(stmt, table, data, values, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"status": http.StatusOK, "id": inserted.key.Value()}
+	if inserted.written {
+		result["status"] = http.StatusCreated
+		if repr != nil {
+			result["data"] = inserted.row
+		}
+	}
+	return result, nil
+}
+
+// batchUpdate updates a record by key, or the records matching filters
+func batchUpdate(tx transaction, table *tableSchema, key *recordKey, r *http.Request, data map[string]interface{}, repr *representation) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, &requestError{message: "Missing data in request body"}
+	}
+	columns, values, err := bindInsert(table, data)
+	if err != nil {
+		return nil, err
+	}
+	statement := fmt.Sprintf("UPDATE %s SET %s", table.Quoted(), strings.Join(table.setClauses(columns), ", "))
+
+	if key == nil {
+		scope, err := parseFilteredScope(r.URL.Query(), table)
+		if err != nil {
+			return nil, err
+		}
+		affected, rows, err := scope.run(tx, table, statement, values, repr)
+		if err != nil {
+			return nil, err
+		}
+		return filteredResult(affected, rows, repr), nil
+	}
+
+	if err := checkBatchIfMatch(tx, table, r, key); err != nil {
+		return nil, err
+	}
+	keyWhere, keyArgs := key.Where()
+	affected, rows, err := table.execReturning(tx, statement+" WHERE "+keyWhere, append(values, keyArgs...), repr)
+	if err != nil {
+		return nil, err
+	}
+	return keyResult(key, affected, rows, repr)
+}
+
+// batchDelete deletes a record by key, or the records matching filters
+func batchDelete(tx transaction, table *tableSchema, key *recordKey, r *http.Request, repr *representation) (map[string]interface{}, error) {
+	statement := fmt.Sprintf("DELETE FROM %s", table.Quoted())
+
+	if key == nil {
+		scope, err := parseFilteredScope(r.URL.Query(), table)
+		if err != nil {
+			return nil, err
+		}
+		affected, rows, err := scope.run(tx, table, statement, nil, repr)
+		if err != nil {
+			return nil, err
+		}
+		return filteredResult(affected, rows, repr), nil
+	}
+
+	if err := checkBatchIfMatch(tx, table, r, key); err != nil {
+		return nil, err
+	}
+	keyWhere, keyArgs := key.Where()
+	affected, rows, err := table.execReturning(tx, statement+" WHERE "+keyWhere, keyArgs, repr)
+	if err != nil {
+		return nil, err
+	}
+	return keyResult(key, affected, rows, repr)
+}
+
+// checkBatchIfMatch evaluates the If-Match header of an operation
+func checkBatchIfMatch(tx transaction, table *tableSchema, r *http.Request, key *recordKey) error {
+	matched, err := table.checkIfMatch(tx, r, key)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return &statusError{status: http.StatusPreconditionFailed, message: fmt.Sprintf("Record with key %s does not match If-Match", key)}
+	}
+	return nil
+}
+
+// keyResult is the result of a write on a record by key
+func keyResult(key *recordKey, affected int64, rows []map[string]interface{}, repr *representation) (map[string]interface{}, error) {
+	if affected == 0 {
+		return nil, &statusError{status: http.StatusNotFound, message: fmt.Sprintf("Record with key %s not found", key)}
+	}
+	result := map[string]interface{}{"status": http.StatusOK, "id": key.Value()}
+	if repr != nil {
+		result["data"] = rows[0]
+	}
+	return result, nil
+}
+
+// filteredResult is the result of a write by filter
+func filteredResult(affected int64, rows []map[string]interface{}, repr *representation) map[string]interface{} {
+	result := map[string]interface{}{"status": http.StatusOK, "affected": affected}
+	if repr != nil {
+		result["data"] = rows
+	}
+	return result
+}
+
+func Batch(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Parse body data
+		body := BatchBody{}
+		err := decodeJSON(r.Body, &body)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if len(body.Operations) == 0 {
+			sendJSONError(w, "Missing operations in request body", http.StatusBadRequest)
+			return
+		}
+
+		onError := strings.ToLower(body.OnError)
+		switch onError {
+		case "":
+			onError = onErrorAbort
+		case onErrorAbort, onErrorContinue:
+		default:
+			sendResolveError(w, &identifierError{kind: "on_error", name: onError, valid: []string{onErrorAbort, onErrorContinue}})
+			return
+		}
+
+		// Raw queries may only perform the actions allowed by the exec policy
+		policy, err := loadExecPolicy()
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error starting batch: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Run the operations in order. In continue mode each operation has
+		// its own savepoint so a failed one leaves no partial changes.
+		results := make([]map[string]interface{}, 0, len(body.Operations))
+		for i, op := range body.Operations {
+			if onError == onErrorContinue {
+				if _, err := tx.Exec("SAVEPOINT batch_operation"); err != nil {
+					sendJSONError(w, fmt.Sprintf("Error running batch: %s", err.Error()), http.StatusInternalServerError)
+					return
+				}
+			}
+
+			result, err := runOperation(db, tx, policy, op, results)
+			if err != nil {
+				status, message := operationStatus(err)
+				if onError == onErrorAbort {
+					sendJSONError(w, fmt.Sprintf("Operation %d: %s", i, message), status)
+					return
+				}
+				if _, err := tx.Exec("ROLLBACK TO batch_operation"); err != nil {
+					sendJSONError(w, fmt.Sprintf("Error running batch: %s", err.Error()), http.StatusInternalServerError)
+					return
+				}
+				result = map[string]interface{}{"status": status, "error": message}
+			}
+
+			if onError == onErrorContinue {
+				if _, err := tx.Exec("RELEASE batch_operation"); err != nil {
+					sendJSONError(w, fmt.Sprintf("Error running batch: %s", err.Error()), http.StatusInternalServerError)
+					return
+				}
+			}
+			results = append(results, result)
+		}
+
+		if err := tx.Commit(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error committing batch: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		// Return success response
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"results": results,
+		})
+	}
+}
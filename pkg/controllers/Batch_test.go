@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestBatch(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE books (id INTEGER PRIMARY KEY, author_id INTEGER REFERENCES authors(id), title TEXT NOT NULL);
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.POST("/__/batch", Batch(pool))
+
+	send := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", "/__/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	count := func(table string) int {
+		var n int
+		pool.Reader.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+		return n
+	}
+
+	// Later operations reference the keys created by earlier ones
+	rr, response := send(`{"operations": [
+		{"method": "POST", "path": "/authors", "body": {"name": "Ursula"}},
+		{"method": "POST", "path": "/books", "body": {"author_id": "$0.id", "title": "The Dispossessed"}},
+		{"method": "PATCH", "path": "/books/$1.id", "headers": {"Prefer": "return=representation"}, "body": {"title": "The Left Hand of Darkness"}},
+		{"method": "GET", "path": "/books?author_id=eq.$0.id"},
+		{"query": "SELECT title FROM books"}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	results := response["results"].([]interface{})
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %v", results)
+	}
+	if status := results[0].(map[string]interface{})["status"]; status != float64(http.StatusCreated) {
+		t.Errorf("Expected create status 201, got %v", status)
+	}
+	updated := results[2].(map[string]interface{})["data"].(map[string]interface{})
+	if updated["title"] != "The Left Hand of Darkness" || updated["author_id"] != float64(1) {
+		t.Errorf("Expected the updated book, got %v", updated)
+	}
+	books := results[3].(map[string]interface{})["data"].([]interface{})
+	if len(books) != 1 {
+		t.Errorf("Expected 1 book of the author, got %v", books)
+	}
+	rows := results[4].(map[string]interface{})["rows"].([]interface{})
+	if len(rows) != 1 || rows[0].(map[string]interface{})["title"] != "The Left Hand of Darkness" {
+		t.Errorf("Expected the query to see the batch writes, got %v", rows)
+	}
+
+	// The first failure rolls the whole batch back
+	rr, response = send(`{"operations": [
+		{"method": "POST", "path": "/authors", "body": {"name": "Octavia"}},
+		{"method": "DELETE", "path": "/books/99"}
+	]}`)
+	if rr.Code != http.StatusNotFound || !strings.HasPrefix(response["message"].(string), "Operation 1:") {
+		t.Errorf("Expected status 404 for operation 1, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := count("authors"); n != 1 {
+		t.Errorf("Expected the batch to be rolled back, got %d authors", n)
+	}
+
+	// In continue mode failed operations are reported and the rest committed
+	rr, response = send(`{"on_error": "continue", "operations": [
+		{"method": "POST", "path": "/authors", "body": {"name": "Octavia"}},
+		{"method": "POST", "path": "/books", "body": {"title": null}},
+		{"method": "POST", "path": "/books", "body": {"author_id": "$1.id", "title": "Kindred"}},
+		{"method": "POST", "path": "/books", "body": {"author_id": "$0.id", "title": "Kindred"}}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	results = response["results"].([]interface{})
+	for _, i := range []int{1, 2} {
+		if status := results[i].(map[string]interface{})["status"]; status != float64(http.StatusBadRequest) {
+			t.Errorf("Expected operation %d to fail with 400, got %v", i, results[i])
+		}
+	}
+	if count("authors") != 2 || count("books") != 2 {
+		t.Errorf("Expected the successful operations to be committed, got %d authors and %d books", count("authors"), count("books"))
+	}
+
+	// Raw statements follow the exec policy
+	rr, _ = send(`{"operations": [{"query": "DROP TABLE books"}]}`)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a dangerous query, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Raw filters cannot get around the policy
+	for _, operation := range []string{
+		`{"method": "GET", "path": "/books?filters_raw=1)%3B%20DROP%20TABLE%20books%3B%20SELECT%20(1"}`,
+		`{"method": "DELETE", "path": "/books?filters_raw=1"}`,
+		`{"method": "PATCH", "path": "/books?filters_raw=1", "body": {"title": "Gone"}}`,
+	} {
+		rr, _ = send(`{"operations": [` + operation + `]}`)
+		if rr.Code != http.StatusBadRequest || count("books") != 2 {
+			t.Errorf("Expected status 400 for raw filters, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// Forward references are rejected
+	rr, _ = send(`{"operations": [{"method": "GET", "path": "/authors/$0.id"}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a forward reference, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Invalid on_error values are rejected
+	rr, _ = send(`{"on_error": "retry", "operations": [{"query": "SELECT 1"}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid on_error, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// changesHeartbeat is how often an idle change stream sends a comment, so
+// that proxies keep the connection open
+var changesHeartbeat = 15 * time.Second
+
+// writeChangeEvent writes a Server-Sent Event. Events without a name are
+// dispatched to the onmessage handler of an EventSource.
+func writeChangeEvent(w http.ResponseWriter, id uint64, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if event != "" {
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, encoded)
+	} else {
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, encoded)
+	}
+	return err
+}
+
+// Changes streams the inserts, updates and deletes committed to the
+// database as Server-Sent Events, optionally for some tables only. Clients
+// resume after the last event they received with the Last-Event-ID header
+// or the last_event_id parameter.
+func Changes(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		feed := pool.Changes
+		if !db.ChangesSupported {
+			sendJSONError(w, "The change feed is not available, the server was built without the preupdate hook (the sqlite_preupdate_hook build tag)", http.StatusNotImplemented)
+			return
+		}
+		if feed == nil {
+			sendJSONError(w, "The change feed is disabled, set change_buffer to enable it", http.StatusNotImplemented)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			sendJSONError(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+
+		// Validate the tables against the schema, views have no changes
+		var tables map[string]bool
+		if param := query.Get("tables"); param != "" {
+			tables = make(map[string]bool)
+			for _, name := range strings.Split(param, ",") {
+				table, err := resolveTable(pool.Reader, strings.TrimSpace(name))
+				if err != nil {
+					sendResolveError(w, err)
+					return
+				}
+				if table.View {
+					sendJSONError(w, fmt.Sprintf("Cannot follow the changes of view %s", table.Name), http.StatusBadRequest)
+					return
+				}
+				tables[table.Name] = true
+			}
+		}
+
+		includeRow := false
+		switch include := query.Get("include"); include {
+		case "":
+		case "row":
+			includeRow = true
+		default:
+			sendResolveError(w, &identifierError{kind: "include", name: include, valid: []string{"row"}})
+			return
+		}
+
+		// Start after the last event received, or with the next change
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = query.Get("last_event_id")
+		}
+		last := feed.LastID()
+		if lastEventID != "" {
+			id, err := strconv.ParseUint(lastEventID, 10, 64)
+			if err != nil {
+				sendJSONError(w, fmt.Sprintf("Invalid last event ID: %s", lastEventID), http.StatusBadRequest)
+				return
+			}
+			last = id
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Keep reverse proxies such as nginx from buffering the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(changesHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			changes, complete, wait := feed.Since(last)
+
+			// Changes after the last event are no longer buffered, clients
+			// reload their data and continue with the buffered changes
+			if !complete {
+				resumed := last
+				last = 0
+				if len(changes) > 0 {
+					last = changes[0].ID - 1
+				}
+				if err := writeChangeEvent(w, last, "reset", map[string]interface{}{"last_event_id": resumed}); err != nil {
+					return
+				}
+			}
+
+			for _, change := range changes {
+				last = change.ID
+				if tables != nil && !tables[change.Table] {
+					continue
+				}
+				if !includeRow {
+					change.Row = nil
+				}
+				if err := writeChangeEvent(w, change.ID, "", change); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-wait:
+			}
+		}
+	}
+}
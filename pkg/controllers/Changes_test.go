@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/middleware"
+)
+
+// sseEvent is an event read from a Server-Sent Events stream
+type sseEvent struct {
+	id, event string
+	data      map[string]interface{}
+}
+
+// readEvents sends the events of a stream to a channel until it ends
+func readEvents(resp *http.Response) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		event := sseEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.data)
+			case line == "" && event.id != "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func TestChanges(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT);
+		CREATE TABLE items (id INTEGER PRIMARY KEY, sku TEXT);
+		CREATE VIEW open_orders AS SELECT * FROM orders WHERE status = 'open'`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := middleware.NewCustomRouter()
+	router.GET("/__/changes", Changes(pool))
+	router.POST("/:table", Create(pool))
+	router.PATCH("/:table/:id", Update(pool))
+	router.DELETE("/:table/:id", Delete(pool))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	send := func(method, path, body string) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			t.Fatalf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+		}
+	}
+
+	subscribe := func(query, lastEventID string) (*http.Response, <-chan sseEvent) {
+		req, _ := http.NewRequest("GET", server.URL+"/__/changes"+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp, readEvents(resp)
+	}
+
+	next := func(events <-chan sseEvent) sseEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for an event")
+		}
+		return sseEvent{}
+	}
+
+	resp, events := subscribe("?tables=orders&include=row", "")
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		t.Skip("SQLite was built without the preupdate hook, run the tests with -tags sqlite_preupdate_hook")
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	send("POST", "/items", `{"sku": "A-1"}`)
+	send("POST", "/orders", `{"status": "open"}`)
+	send("PATCH", "/orders/1", `{"status": "paid"}`)
+	send("DELETE", "/orders/1", "")
+
+	// Changes to other tables are left out
+	event := next(events)
+	if event.id != "2" || event.data["table"] != "orders" || event.data["op"] != "insert" || event.data["key"].(map[string]interface{})["id"] != float64(1) {
+		t.Errorf("Expected the insert of order 1, got %+v", event)
+	}
+	if row, ok := event.data["row"].(map[string]interface{}); !ok || row["status"] != "open" {
+		t.Errorf("Expected the inserted row, got %+v", event.data["row"])
+	}
+	if event := next(events); event.data["op"] != "update" || event.data["row"].(map[string]interface{})["status"] != "paid" {
+		t.Errorf("Expected the update of order 1, got %+v", event)
+	}
+	if event := next(events); event.id != "4" || event.data["op"] != "delete" || event.data["row"] != nil || event.data["key"].(map[string]interface{})["id"] != float64(1) {
+		t.Errorf("Expected the delete of order 1, got %+v", event)
+	}
+
+	// Clients resume after the last event they received, rows are only sent
+	// when asked for
+	resumed, events := subscribe("", "2")
+	defer resumed.Body.Close()
+	if event := next(events); event.id != "3" || event.data["op"] != "update" || event.data["row"] != nil {
+		t.Errorf("Expected to resume with the update, got %+v", event)
+	}
+	if event := next(events); event.id != "4" {
+		t.Errorf("Expected the delete next, got %+v", event)
+	}
+
+	// IDs that were never issued, e.g. before a restart, reset the client
+	reset, events := subscribe("?tables=items", "99")
+	defer reset.Body.Close()
+	if event := next(events); event.event != "reset" || event.id != "0" || event.data["last_event_id"] != float64(99) {
+		t.Errorf("Expected a reset event, got %+v", event)
+	}
+	if event := next(events); event.id != "1" || event.data["table"] != "items" {
+		t.Errorf("Expected the buffered item change after the reset, got %+v", event)
+	}
+
+	for _, query := range []string{"?tables=dogs", "?tables=open_orders", "?include=everything", "?last_event_id=soon"} {
+		resp, err := http.Get(server.URL + "/__/changes" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
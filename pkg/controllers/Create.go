@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,15 +11,14 @@ import (
 	"github.com/paradoxe35/sqlite-rest/pkg/db"
 )
 
-func Create(dbPath string) httprouter.Handle {
+func Create(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
 			return
 		}
-		defer db.Close()
+		defer release()
 
 		// Parse table name from params
 		tableSelect := params.ByName("table")
@@ -27,48 +27,58 @@ func Create(dbPath string) httprouter.Handle {
 			return
 		}
 
-		// Parse body data
-		data := make(map[string]interface{})
-		err = json.NewDecoder(r.Body).Decode(&data)
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Read how conflicting rows are resolved and what is returned
+		var opts insertOptions
+		opts.conflict, err = table.Conflict(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		opts.repr, err = table.Representation(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Parse body data, a single object, an array or NDJSON
+		next, bulk, err := openRowSource(r)
 		if err != nil {
 			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
 			return
 		}
 
-		if len(data) == 0 {
-			sendJSONError(w, "Missing data in request body", http.StatusBadRequest)
+		if bulk {
+			createMany(w, r, db, table, next, opts)
 			return
 		}
 
-		// Extract keys and values from data
-		columnNames := make([]string, 0, len(data))
-		var columnValuesString string
-		for k, v := range data {
-			columnNames = append(columnNames, k)
+		data, err := next()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
 
-			if v == nil {
-				columnValuesString += "NULL,"
-				continue
-			}
+		if len(data) == 0 {
+			sendJSONError(w, "Missing data in request body", http.StatusBadRequest)
+			return
+		}
 
-			switch v.(type) {
-			case string:
-				columnValuesString += fmt.Sprintf("\"%s\",", v)
-			case int:
-				columnValuesString += fmt.Sprintf("%d,", v)
-			case float64:
-				columnValuesString += fmt.Sprintf("%f,", v)
-			case bool:
-				columnValuesString += fmt.Sprintf("%t,", v)
-			default:
-				columnValuesString += fmt.Sprintf("%v,", v)
-			}
+		// Extract and validate columns and bound values from data
+		columnNames, columnValues, err := bindInsert(table, data)
+		if err != nil {
+			sendResolveError(w, err)
+			return
 		}
-		// Remove last comma
-		columnValuesString = columnValuesString[:len(columnValuesString)-1]
 
 		// Execute query
-		res, err := db.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableSelect, strings.Join(columnNames, ", "), columnValuesString))
+		inserted, err := insertOne(db, table, data, columnNames, columnValues, opts)
 		if err != nil {
 			// Check if this is a syntax error (client error) or a server error
 			errMsg := err.Error()
@@ -76,24 +86,85 @@ func Create(dbPath string) httprouter.Handle {
 				sendJSONError(w, fmt.Sprintf("Table not found: %s", tableSelect), http.StatusBadRequest)
 			} else if strings.Contains(errMsg, "constraint failed") || strings.Contains(errMsg, "UNIQUE constraint") {
 				sendJSONError(w, fmt.Sprintf("Constraint violation: %s", errMsg), http.StatusBadRequest)
+			} else if isConflictTargetError(err) {
+				sendJSONError(w, fmt.Sprintf("Invalid on_conflict: %s", errMsg), http.StatusBadRequest)
 			} else {
 				sendJSONError(w, fmt.Sprintf("Error creating record: %s", errMsg), http.StatusInternalServerError)
 			}
 			return
 		}
 
-		// Get ID
-		id, err := res.LastInsertId()
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Error retrieving inserted ID: %s", err.Error()), http.StatusInternalServerError)
+		// Return success response, created unless a conflicting row was
+		// ignored
+		response := map[string]interface{}{
+			"status": "success",
+			"id":     inserted.key.Value(),
+		}
+		if opts.repr != nil && inserted.written {
+			response["data"] = inserted.row
+		}
+		status := http.StatusOK
+		if inserted.written {
+			status = http.StatusCreated
+			if inserted.key.Complete() {
+				w.Header().Set("Location", table.location(inserted.key))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// createMany inserts the rows of a bulk payload in one transaction
+func createMany(w http.ResponseWriter, r *http.Request, db database, table *tableSchema, next rowSource, opts insertOptions) {
+	onError := strings.ToLower(r.URL.Query().Get("on_error"))
+	switch onError {
+	case "":
+		onError = onErrorAbort
+	case onErrorAbort, onErrorContinue:
+	default:
+		sendResolveError(w, &identifierError{kind: "on_error", name: onError, valid: []string{onErrorAbort, onErrorContinue}})
+		return
+	}
+
+	result, err := bulkInsert(db, table, next, onError, opts)
+	if err != nil {
+		var failure *rowError
+		if errors.As(err, &failure) {
+			sendJSONError(w, failure.Error(), failure.status)
+			return
+		}
+		if _, ok := err.(*requestError); ok {
+			sendResolveError(w, err)
 			return
 		}
+		sendJSONError(w, fmt.Sprintf("Error creating records: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
 
-		// Return success response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "success",
-			"id":     id,
-		})
+	response := map[string]interface{}{
+		"status":   "success",
+		"ids":      result.Keys,
+		"inserted": result.Written,
+	}
+	if opts.repr != nil {
+		response["data"] = result.Rows
+	}
+	if onError == onErrorContinue {
+		failures := result.Failures
+		if failures == nil {
+			failures = []rowError{}
+		}
+		response["errors"] = failures
+	}
+
+	// Created unless every row was ignored or failed
+	status := http.StatusOK
+	if result.Written > 0 {
+		status = http.StatusCreated
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
 }
@@ -4,22 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/paradoxe35/sqlite-rest/pkg/db"
 )
 
-func Delete(dbPath string) httprouter.Handle {
+func Delete(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
 			return
 		}
-		defer db.Close()
+		defer release()
 
 		// Parse table name from params
 		tableSelect := params.ByName("table")
@@ -28,20 +26,53 @@ func Delete(dbPath string) httprouter.Handle {
 			return
 		}
 
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
 		// Parse id from params
 		idParam := params.ByName("id")
 		if idParam == "" {
 			sendJSONError(w, "Missing ID parameter", http.StatusBadRequest)
 			return
 		}
-		id, err := strconv.ParseInt(idParam, 10, 64)
+		key, err := table.ParseKey(keyParam(r, idParam))
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		keyWhere, keyArgs := key.Where()
+
+		// Read what is returned of the deleted record
+		repr, err := table.Representation(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error deleting record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Check the record is still the one the client read
+		matched, err := table.checkIfMatch(tx, r, key)
 		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Invalid ID format: %s", err.Error()), http.StatusBadRequest)
+			sendJSONError(w, fmt.Sprintf("Error deleting record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if !matched {
+			sendJSONError(w, fmt.Sprintf("Record with key %s does not match If-Match", key), http.StatusPreconditionFailed)
 			return
 		}
 
 		// Execute query
-		result, err := db.Exec("DELETE FROM " + tableSelect + " WHERE id = " + idParam)
+		rowsAffected, rows, err := table.execReturning(tx, fmt.Sprintf("DELETE FROM %s WHERE %s", table.Quoted(), keyWhere), keyArgs, repr)
 		if err != nil {
 			// Check if this is a syntax error (client error) or a server error
 			errMsg := err.Error()
@@ -54,18 +85,26 @@ func Delete(dbPath string) httprouter.Handle {
 		}
 
 		// Check if any rows were affected
-		rowsAffected, _ := result.RowsAffected()
 		if rowsAffected == 0 {
-			sendJSONError(w, fmt.Sprintf("Record with ID %d not found", id), http.StatusNotFound)
+			sendJSONError(w, fmt.Sprintf("Record with key %s not found", key), http.StatusNotFound)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error deleting record: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
 
 		// Return success response
+		response := map[string]interface{}{
+			"status": "success",
+			"id":     key.Value(),
+		}
+		if repr != nil {
+			response["data"] = rows[0]
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "success",
-			"id":     id,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
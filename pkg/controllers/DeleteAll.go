@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+func DeleteAll(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Parse table name from params
+		tableSelect := params.ByName("table")
+		if tableSelect == "" {
+			sendJSONError(w, "Missing table parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Build the filtered scope of the delete
+		scope, err := parseFilteredScope(r.URL.Query(), table)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Read what is returned of the deleted records
+		repr, err := table.Representation(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Execute query
+		affected, rows, err := scope.exec(db, table, fmt.Sprintf("DELETE FROM %s", table.Quoted()), nil, repr)
+		if err != nil {
+			// Check if this is a client error or a server error
+			errMsg := err.Error()
+			if _, ok := err.(*requestError); ok {
+				sendResolveError(w, err)
+			} else if strings.Contains(errMsg, "constraint failed") {
+				sendJSONError(w, fmt.Sprintf("Constraint violation: %s", errMsg), http.StatusBadRequest)
+			} else {
+				sendJSONError(w, fmt.Sprintf("Error deleting records: %s", errMsg), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Return success response
+		response := map[string]interface{}{
+			"status":   "success",
+			"affected": affected,
+		}
+		if repr != nil {
+			response["data"] = rows
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
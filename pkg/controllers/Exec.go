@@ -3,122 +3,72 @@ package controllers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"sort"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/paradoxe35/sqlite-rest/pkg/db"
 )
 
+// ExecBody is the request body of /__/exec: a query, which may be a script
+// of several statements, or a list of statements. Params are positional
+// when an array and named (:name) when an object.
 type ExecBody struct {
-	Query string `json:"query"`
+	Query      string          `json:"query,omitempty"`
+	Params     interface{}     `json:"params,omitempty"`
+	Statements []ExecStatement `json:"statements,omitempty"`
 }
 
-// Default list of dangerous SQL operations that should be blocked
-var defaultDangerousOperations = []string{
-	"DROP TABLE",
-	"DROP DATABASE",
-	"DELETE FROM",
-	"TRUNCATE TABLE",
-	"ALTER TABLE",
-	// "PRAGMA" is removed to allow PRAGMA queries that return data
-	"ATTACH DATABASE",
-	"DETACH DATABASE",
+// ExecStatement is a statement of an exec request and its parameters
+type ExecStatement struct {
+	Query  string      `json:"query"`
+	Params interface{} `json:"params,omitempty"`
 }
 
-// getDangerousOperations returns the list of dangerous operations
-// It checks if the user has defined custom dangerous operations via environment variables
-func getDangerousOperations() []string {
-	// Check if the environment variable exists
-	customOperations, exists := os.LookupEnv("SQLITE_REST_DANGEROUS_OPS")
-
-	// If the environment variable exists (even if empty), use it
-	if exists {
-		// If it's empty, return an empty list (all operations allowed)
-		if customOperations == "" {
-			return []string{}
-		}
-
-		// Parse comma-separated list
-		operations := strings.Split(customOperations, ",")
-		// Trim whitespace
-		for i, op := range operations {
-			operations[i] = strings.ToUpper(strings.TrimSpace(op))
-		}
-		return operations
+// determineQueryType names a statement by its first keyword, e.g. SELECT,
+// WITH or INSERT, after any leading comments. The SHOW TABLES and LIST
+// TABLES commands are SHOW_TABLES.
+func determineQueryType(query string) string {
+	statements := splitScript(query)
+	if len(statements) == 0 {
+		return "OTHER"
 	}
+	upperQuery := strings.ToUpper(statements[0])
 
-	// Return default list if no custom operations defined
-	return defaultDangerousOperations
-}
-
-// isQuerySafe checks if the query contains any dangerous operations
-func isQuerySafe(query string) bool {
-	upperQuery := strings.ToUpper(query)
-
-	// Get the list of dangerous operations (either default or custom)
-	dangerousOperations := getDangerousOperations()
-
-	// If the list is empty, all operations are allowed
-	if len(dangerousOperations) == 0 {
-		return true
+	if strings.HasPrefix(upperQuery, "SHOW TABLES") ||
+		strings.HasPrefix(upperQuery, "LIST TABLES") {
+		return "SHOW_TABLES"
 	}
 
-	// Check for dangerous operations
-	for _, op := range dangerousOperations {
-		if op != "" && strings.Contains(upperQuery, op) {
-			return false
-		}
+	end := 0
+	for end < len(upperQuery) && isWordByte(upperQuery[end]) {
+		end++
 	}
-
-	return true
-}
-
-// determineQueryType identifies the type of SQL query
-func determineQueryType(query string) string {
-	trimmedQuery := strings.TrimSpace(query)
-	upperQuery := strings.ToUpper(trimmedQuery)
-
-	if strings.HasPrefix(upperQuery, "SELECT") {
-		return "SELECT"
-	} else if strings.HasPrefix(upperQuery, "SHOW TABLES") ||
-		strings.HasPrefix(upperQuery, "LIST TABLES") {
-		return "SHOW_TABLES"
-	} else if strings.HasPrefix(upperQuery, "PRAGMA") {
-		return "PRAGMA"
-	} else if strings.HasPrefix(upperQuery, "EXPLAIN") {
-		return "EXPLAIN"
-	} else if strings.HasPrefix(upperQuery, "ANALYZE") {
-		return "ANALYZE"
-	} else if strings.HasPrefix(upperQuery, "INSERT") {
-		return "INSERT"
-	} else if strings.HasPrefix(upperQuery, "UPDATE") {
-		return "UPDATE"
-	} else if strings.HasPrefix(upperQuery, "CREATE") {
-		return "CREATE"
-	}
-
-	return "OTHER"
+	if end == 0 {
+		return "OTHER"
+	}
+	return upperQuery[:end]
 }
 
-// isDataReturningQuery checks if the query is expected to return data
-func isDataReturningQuery(queryType string) bool {
-	// These query types typically return data
-	dataReturningTypes := map[string]bool{
-		"SELECT":      true,
-		"PRAGMA":      true,
-		"EXPLAIN":     true,
-		"ANALYZE":     true,
-		"SHOW_TABLES": true,
+// isTransactionControl checks if the query begins or ends a transaction or
+// savepoint, which would break the transaction it runs in
+func isTransactionControl(query string) bool {
+	fields := strings.Fields(strings.ToUpper(query))
+	if len(fields) == 0 {
+		return false
 	}
-
-	return dataReturningTypes[queryType]
+	switch strings.TrimSuffix(fields[0], ";") {
+	case "BEGIN", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE":
+		return true
+	}
+	return false
 }
 
 // listTables returns a list of all tables in the database
-func listTables(db *sql.DB) ([]string, error) {
+func listTables(db querier) ([]string, error) {
 	// In SQLite, we can query the sqlite_master table to get a list of all tables
 	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' ORDER BY name")
 	if err != nil {
@@ -145,236 +95,377 @@ func listTables(db *sql.DB) ([]string, error) {
 	return tables, nil
 }
 
-// executeSelect handles SELECT queries and returns the results
-func executeSelect(db *sql.DB, query string) ([]map[string]interface{}, error) {
+// executeSelect runs a statement returning rows and returns them
+func executeSelect(db querier, query string, args ...interface{}) ([]map[string]interface{}, error) {
 	// Execute query
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// Get column names
-	columnNames, err := rows.Columns()
+	result, _, err := scanAll(rows, 0)
+	return result, err
+}
+
+// executeNonSelect handles non-SELECT queries and returns affected rows and
+// the rowid of the last inserted row
+func executeNonSelect(db execer, query string, args ...interface{}) (int64, int64, error) {
+	// Execute query
+	result, err := db.Exec(query, args...)
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	// Get column types
-	columnTypes, err := rows.ColumnTypes()
+	// Get affected rows
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return nil, err
+		return 0, 0, err
+	}
+
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
 	}
 
-	// Prepare result
-	var result []map[string]interface{}
+	return rowsAffected, lastInsertID, nil
+}
 
-	// Process rows
-	for rows.Next() {
-		// Create slice of pointers to scan into
-		columnPtrs := make([]interface{}, len(columnNames))
-
-		// Infer type from column type
-		for i := range columnNames {
-			switch strings.ToUpper(columnTypes[i].DatabaseTypeName()) {
-			case "PRIMARY_KEY", "INTEGER", "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8", "DECIMAL":
-				columnPtrs[i] = new(sql.NullInt64)
-			case "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT", "NUMERIC":
-				columnPtrs[i] = new(sql.NullFloat64)
-			case "BLOB":
-				columnPtrs[i] = new([]byte)
-			case "TEXT", "CHARACTER", "VARCHAR", "VARYING CHARACTER", "NCHAR", "NATIVE CHARACTER", "NVARCHAR", "CLOB", "DATE", "DATETIME":
-				columnPtrs[i] = new(sql.NullString)
-			case "BOOLEAN", "BOOL":
-				columnPtrs[i] = new(sql.NullBool)
-			default:
-				columnPtrs[i] = new(sql.NullString)
+// execStatement is a statement to execute with its bound parameters
+type execStatement struct {
+	query string
+	args  []interface{}
+}
+
+// parseExecParams binds the params of a statement: an array binds positional
+// parameters and an object named ones, written :name, @name or $name in the
+// query
+func parseExecParams(params interface{}) ([]interface{}, error) {
+	switch values := params.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		args := make([]interface{}, len(values))
+		for i, v := range values {
+			value, err := bindValue(v)
+			if err != nil {
+				return nil, &requestError{message: fmt.Sprintf("Invalid parameter %d: %s", i+1, err.Error())}
+			}
+			args[i] = value
+		}
+		return args, nil
+	case map[string]interface{}:
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		args := make([]interface{}, len(names))
+		for i, name := range names {
+			value, err := bindValue(values[name])
+			if err != nil {
+				return nil, &requestError{message: fmt.Sprintf("Invalid parameter %s: %s", name, err.Error())}
+			}
+			args[i] = sql.Named(strings.TrimLeft(name, ":@$"), value)
+		}
+		return args, nil
+	}
+	return nil, &requestError{message: "Invalid params: must be an array or an object"}
+}
+
+// parseExecBody returns the statements of an exec request, and whether they
+// run as a script in one transaction
+func parseExecBody(body ExecBody) ([]execStatement, bool, error) {
+	if len(body.Statements) > 0 {
+		if body.Query != "" || body.Params != nil {
+			return nil, false, &requestError{message: "Use either query and params or statements"}
+		}
+		statements := make([]execStatement, len(body.Statements))
+		for i, statement := range body.Statements {
+			if strings.TrimSpace(statement.Query) == "" {
+				return nil, false, &requestError{message: fmt.Sprintf("Missing query in statement %d", i)}
+			}
+			args, err := parseExecParams(statement.Params)
+			if err != nil {
+				return nil, false, err
 			}
+			statements[i] = execStatement{query: statement.Query, args: args}
 		}
+		return statements, true, nil
+	}
+
+	queries := splitScript(body.Query)
+	if len(queries) == 0 {
+		return nil, false, &requestError{message: "Missing query parameter"}
+	}
+	if len(queries) > 1 && body.Params != nil {
+		return nil, false, &requestError{message: "Params apply to a single statement, use statements to bind parameters in a script"}
+	}
+	args, err := parseExecParams(body.Params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	statements := make([]execStatement, len(queries))
+	for i, query := range queries {
+		statements[i] = execStatement{query: query}
+	}
+	statements[0].args = args
+	return statements, len(statements) > 1, nil
+}
 
-		// Scan row into column pointers
-		err = rows.Scan(columnPtrs...)
+// runExecStatement executes a statement and returns its result: the rows of
+// statements returning rows, or the affected rows and last inserted rowid of
+// the others. The statement is classified by preparing it on conn, the
+// connection db runs on.
+func runExecStatement(conn *sql.Conn, runner execer, statement execStatement) (map[string]interface{}, error) {
+	queryType := determineQueryType(statement.query)
+
+	if queryType == "SHOW_TABLES" {
+		// Handle SHOW TABLES command
+		tables, err := listTables(runner)
 		if err != nil {
 			return nil, err
 		}
 
-		// Compose data map
-		rowData := make(map[string]interface{})
-		for i, columnKey := range columnNames {
-			switch strings.ToUpper(columnTypes[i].DatabaseTypeName()) {
-			case "PRIMARY_KEY", "INTEGER", "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8", "DECIMAL":
-				if columnPtrs[i].(*sql.NullInt64).Valid {
-					rowData[columnKey] = columnPtrs[i].(*sql.NullInt64).Int64
-				} else {
-					rowData[columnKey] = nil
-				}
-			case "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT", "NUMERIC":
-				if columnPtrs[i].(*sql.NullFloat64).Valid {
-					rowData[columnKey] = columnPtrs[i].(*sql.NullFloat64).Float64
-				} else {
-					rowData[columnKey] = nil
-				}
-			case "BLOB":
-				rowData[columnKey] = string(*columnPtrs[i].(*[]byte))
-			case "TEXT", "CHARACTER", "VARCHAR", "VARYING CHARACTER", "NCHAR", "NATIVE CHARACTER", "NVARCHAR", "CLOB", "DATE", "DATETIME":
-				if columnPtrs[i].(*sql.NullString).Valid {
-					rowData[columnKey] = columnPtrs[i].(*sql.NullString).String
-				} else {
-					rowData[columnKey] = nil
-				}
-			case "BOOLEAN", "BOOL":
-				if columnPtrs[i].(*sql.NullBool).Valid {
-					rowData[columnKey] = columnPtrs[i].(*sql.NullBool).Bool
-				} else {
-					rowData[columnKey] = nil
-				}
-			default:
-				if columnPtrs[i].(*sql.NullString).Valid {
-					rowData[columnKey] = columnPtrs[i].(*sql.NullString).String
-				} else {
-					rowData[columnKey] = nil
-				}
-			}
+		// Convert to rows format for consistency
+		var rows []map[string]interface{}
+		for _, table := range tables {
+			rows = append(rows, map[string]interface{}{
+				"table_name": table,
+			})
 		}
 
-		result = append(result, rowData)
+		return map[string]interface{}{
+			"type":   "show_tables",
+			"tables": tables,
+			"rows":   rows,
+			"count":  len(tables),
+		}, nil
 	}
 
-	// Check for errors from iterating over rows
-	if err = rows.Err(); err != nil {
+	info, err := db.Classify(conn, statement.query)
+	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	if info.Columns > 0 {
+		// Handle statements returning rows (SELECT, WITH, VALUES, RETURNING, PRAGMA, etc.)
+		rows, err := executeSelect(runner, statement.query, statement.args...)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":     strings.ToLower(queryType),
+			"readonly": info.Readonly,
+			"rows":     rows,
+			"count":    len(rows),
+		}, nil
+	}
+
+	// Handle statements returning no rows (INSERT, UPDATE, DELETE, CREATE, etc.)
+	rowsAffected, lastInsertID, err := executeNonSelect(runner, statement.query, statement.args...)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"type":           strings.ToLower(queryType),
+		"readonly":       info.Readonly,
+		"rows_affected":  rowsAffected,
+		"last_insert_id": lastInsertID,
+	}, nil
 }
 
-// executeNonSelect handles non-SELECT queries and returns affected rows
-func executeNonSelect(db *sql.DB, query string) (int64, error) {
-	// Execute query
-	result, err := db.Exec(query)
+// streamExecStatement writes the rows of a statement as they are read.
+// Statements returning no rows are not streamed, their result is sent as a
+// whole.
+func streamExecStatement(w http.ResponseWriter, format string, conn *sql.Conn, runner querier, statement execStatement) (bool, error) {
+	if determineQueryType(statement.query) == "SHOW_TABLES" {
+		return false, nil
+	}
+	info, err := db.Classify(conn, statement.query)
+	if err != nil || info.Columns == 0 {
+		return false, err
+	}
+
+	rows, err := runner.Query(statement.query, statement.args...)
 	if err != nil {
-		return 0, err
+		return false, err
 	}
+	defer rows.Close()
 
-	// Get affected rows
-	rowsAffected, err := result.RowsAffected()
+	scanner, err := newRowScanner(rows, 0)
 	if err != nil {
-		return 0, err
+		return false, err
+	}
+	if format != formatJSON {
+		return true, streamRows(w, format, "query", scanner)
 	}
 
-	return rowsAffected, nil
+	stream := newJSONStream(w, "rows")
+	for rows.Next() {
+		row, _, err := scanner.Scan()
+		if err == nil {
+			err = stream.Write(row)
+		}
+		if err != nil {
+			return true, stream.Abort(err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return true, stream.Abort(err)
+	}
+	stream.Close(map[string]interface{}{
+		"status":   "success",
+		"type":     strings.ToLower(determineQueryType(statement.query)),
+		"readonly": info.Readonly,
+		"count":    stream.Rows(),
+	}, nil)
+	return true, nil
 }
 
-func Exec(dbPath string) httprouter.Handle {
+// execErrorStatus returns the status and message of a failed statement
+func execErrorStatus(err error, query string) (int, string) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status, statusErr.message
+	}
+
+	queryType := determineQueryType(query)
+	if queryType == "SHOW_TABLES" {
+		// For listing tables, most errors would be server-side issues
+		// since this is a simple query on sqlite_master
+		return http.StatusInternalServerError, fmt.Sprintf("Error listing tables: %s", err.Error())
+	}
+
+	// Check if this is a syntax error (client error) or a server error
+	errMsg := err.Error()
+	if strings.Contains(errMsg, "syntax error") ||
+		strings.Contains(errMsg, "no such table") ||
+		strings.Contains(errMsg, "no such column") ||
+		strings.Contains(errMsg, "constraint failed") ||
+		strings.Contains(errMsg, "argument") ||
+		strings.Contains(errMsg, "not enough args") {
+		// This is likely a client error - bad SQL syntax, constraint violations or bad parameters
+		return http.StatusBadRequest, fmt.Sprintf("Invalid SQL query: %s", errMsg)
+	}
+	// This is likely a server error - database issues, etc.
+	return http.StatusInternalServerError, fmt.Sprintf("Error executing %s query: %s", strings.ToLower(queryType), errMsg)
+}
+
+func Exec(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
 			return
 		}
-		defer db.Close()
+		defer release()
 
 		// Parse body data
 		data := ExecBody{}
-		err = json.NewDecoder(r.Body).Decode(&data)
+		err := decodeJSON(r.Body, &data)
 		if err != nil {
 			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
 			return
 		}
 
-		if data.Query == "" {
-			sendJSONError(w, "Missing query parameter", http.StatusBadRequest)
+		statements, script, err := parseExecBody(data)
+		if err != nil {
+			sendResolveError(w, err)
 			return
 		}
 
-		// Check if query is safe
-		if !isQuerySafe(data.Query) {
-			sendJSONError(w, "Query contains dangerous operations that are not allowed", http.StatusForbidden)
+		// Rows of single statements can be streamed in other formats
+		format, err := negotiateFormat(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		if script && format != formatJSON {
+			sendJSONError(w, fmt.Sprintf("Scripts are not available in %s responses", format), http.StatusBadRequest)
 			return
 		}
 
-		// Determine query type
-		queryType := determineQueryType(data.Query)
-
-		// Execute query based on type
-		var result interface{}
+		// Statements may only perform the actions allowed by the exec policy
+		policy, err := loadExecPolicy()
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		if queryType == "SHOW_TABLES" {
-			// Handle SHOW TABLES command
-			tables, err := listTables(db)
-			if err != nil {
-				// For listing tables, most errors would be server-side issues
-				// since this is a simple query on sqlite_master
-				sendJSONError(w, fmt.Sprintf("Error listing tables: %s", err.Error()), http.StatusInternalServerError)
+		for _, statement := range statements {
+			// Transactions are controlled through the /__/tx endpoints
+			if (script || r.Header.Get(transactionHeader) != "") && isTransactionControl(statement.query) {
+				sendJSONError(w, "Transaction statements are not allowed in a script or transaction, use the /__/tx endpoints", http.StatusBadRequest)
 				return
 			}
+		}
 
-			// Convert to rows format for consistency
-			var rows []map[string]interface{}
-			for _, table := range tables {
-				rows = append(rows, map[string]interface{}{
-					"table_name": table,
-				})
-			}
-
-			result = map[string]interface{}{
-				"status": "success",
-				"type":   "show_tables",
-				"tables": tables,
-				"rows":   rows,
-				"count":  len(tables),
+		if !script {
+			var result map[string]interface{}
+			streamed := false
+			err := runAuthorized(db, policy, func() (err error) {
+				streamed, err = streamExecStatement(w, format, db.conn(), db, statements[0])
+				if streamed || err != nil {
+					return err
+				}
+				result, err = runExecStatement(db.conn(), db, statements[0])
+				return err
+			})
+			var failure *streamFailure
+			if errors.As(err, &failure) {
+				abortStream(w)
+				return
 			}
-		} else if isDataReturningQuery(queryType) {
-			// Handle queries that return data (SELECT, PRAGMA, EXPLAIN, etc.)
-			rows, err := executeSelect(db, data.Query)
 			if err != nil {
-				// Check if this is a syntax error (client error) or a server error
-				errMsg := err.Error()
-				if strings.Contains(errMsg, "syntax error") ||
-					strings.Contains(errMsg, "no such table") ||
-					strings.Contains(errMsg, "no such column") {
-					// This is likely a client error - bad SQL syntax or referencing non-existent tables/columns
-					sendJSONError(w, fmt.Sprintf("Invalid SQL query: %s", errMsg), http.StatusBadRequest)
-				} else {
-					// This is likely a server error - database issues, etc.
-					sendJSONError(w, fmt.Sprintf("Error executing %s query: %s", strings.ToLower(queryType), errMsg), http.StatusInternalServerError)
-				}
+				status, message := execErrorStatus(err, statements[0].query)
+				sendJSONError(w, message, status)
 				return
 			}
-			result = map[string]interface{}{
-				"status": "success",
-				"type":   strings.ToLower(queryType),
-				"rows":   rows,
-				"count":  len(rows),
+			if streamed {
+				return
 			}
-		} else {
-			// Handle non-data-returning queries (INSERT, UPDATE, DELETE, etc.)
-			rowsAffected, err := executeNonSelect(db, data.Query)
+			result["status"] = "success"
+
+			// Return result
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		// Scripts run in one transaction, rolled back on the first failure
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error starting transaction: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		results := make([]map[string]interface{}, len(statements))
+		for i, statement := range statements {
+			err := runAuthorized(db, policy, func() (err error) {
+				results[i], err = runExecStatement(db.conn(), tx, statement)
+				return err
+			})
 			if err != nil {
-				// Check if this is a syntax error (client error) or a server error
-				errMsg := err.Error()
-				if strings.Contains(errMsg, "syntax error") ||
-					strings.Contains(errMsg, "no such table") ||
-					strings.Contains(errMsg, "no such column") ||
-					strings.Contains(errMsg, "constraint failed") {
-					// This is likely a client error - bad SQL syntax or constraint violations
-					sendJSONError(w, fmt.Sprintf("Invalid SQL query: %s", errMsg), http.StatusBadRequest)
-				} else {
-					// This is likely a server error - database issues, etc.
-					sendJSONError(w, fmt.Sprintf("Error executing %s query: %s", strings.ToLower(queryType), errMsg), http.StatusInternalServerError)
-				}
+				status, message := execErrorStatus(err, statement.query)
+				sendJSONError(w, fmt.Sprintf("Statement %d: %s", i, message), status)
 				return
 			}
-			result = map[string]interface{}{
-				"status":        "success",
-				"type":          strings.ToLower(queryType),
-				"rows_affected": rowsAffected,
-			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error committing transaction: %s", err.Error()), http.StatusInternalServerError)
+			return
 		}
 
 		// Return result
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(result)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"results": results,
+		})
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
@@ -22,7 +23,7 @@ func TestExec(t *testing.T) {
 
 	// Create a test router
 	router := httprouter.New()
-	router.OPTIONS("/__/exec", Exec(tmpFile.Name()))
+	router.OPTIONS("/__/exec", Exec(openTestPool(t, tmpFile.Name())))
 
 	// Create a test database with a table
 	createTableBody := ExecBody{
@@ -162,3 +163,231 @@ func TestExec(t *testing.T) {
 		t.Errorf("Expected at least one row in explain output")
 	}
 }
+
+func TestExecParamsAndScripts(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.OPTIONS("/__/exec", Exec(pool))
+
+	send := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("OPTIONS", "/__/exec", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// A script runs every statement, triggers included, with one result each
+	rr, response := send(`{"query": "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, paw INTEGER); CREATE TABLE log (msg TEXT); CREATE TRIGGER cats_log AFTER INSERT ON cats BEGIN INSERT INTO log VALUES ('new; cat'); END; -- done"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if results := response["results"].([]interface{}); len(results) != 3 {
+		t.Errorf("Expected 3 results, got %v", results)
+	}
+
+	// Positional parameters
+	rr, response = send(`{"query": "INSERT INTO cats (name, paw) VALUES (?, ?)", "params": ["Tequila", 4]}`)
+	if rr.Code != http.StatusOK || response["rows_affected"] != float64(1) || response["last_insert_id"] != float64(1) {
+		t.Fatalf("Expected one inserted row with id 1, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Named parameters
+	rr, response = send(`{"query": "SELECT name FROM cats WHERE paw = :paw AND name = :name", "params": {"paw": 4, ":name": "Tequila"}}`)
+	if rr.Code != http.StatusOK || response["count"] != float64(1) {
+		t.Errorf("Expected one matching cat, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Values are never interpolated
+	rr, response = send(`{"query": "SELECT name FROM cats WHERE name = ?", "params": ["' OR 1=1 --"]}`)
+	if rr.Code != http.StatusOK || response["count"] != float64(0) {
+		t.Errorf("Expected no cats, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Statements run in one transaction with their own parameters
+	rr, response = send(`{"statements": [
+		{"query": "INSERT INTO cats (name, paw) VALUES (?, ?)", "params": ["Mezcal", 3]},
+		{"query": "UPDATE cats SET paw = paw + 1 WHERE name = :name", "params": {"name": "Mezcal"}},
+		{"query": "SELECT paw FROM cats WHERE name = ?", "params": ["Mezcal"]}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	results := response["results"].([]interface{})
+	if results[0].(map[string]interface{})["last_insert_id"] != float64(2) {
+		t.Errorf("Expected the insert to report id 2, got %v", results[0])
+	}
+	rows := results[2].(map[string]interface{})["rows"].([]interface{})
+	if rows[0].(map[string]interface{})["paw"] != float64(4) {
+		t.Errorf("Expected the update to be visible to later statements, got %v", rows)
+	}
+
+	// A failing statement rolls the whole script back
+	rr, response = send(`{"statements": [
+		{"query": "INSERT INTO cats (name, paw) VALUES ('Pisco', 4)"},
+		{"query": "INSERT INTO cats (id, name) VALUES (1, 'Duplicate')"}
+	]}`)
+	if rr.Code != http.StatusBadRequest || !strings.HasPrefix(response["message"].(string), "Statement 1:") {
+		t.Errorf("Expected status 400 for statement 1, got %d: %s", rr.Code, rr.Body.String())
+	}
+	_, response = send(`{"query": "SELECT * FROM cats"}`)
+	if response["count"] != float64(2) {
+		t.Errorf("Expected the script to be rolled back, got %v cats", response["count"])
+	}
+
+	// Invalid requests
+	for _, body := range []string{
+		`{"query": "SELECT 1; SELECT 2", "params": [1]}`,
+		`{"query": "SELECT 1", "statements": [{"query": "SELECT 2"}]}`,
+		`{"query": "SELECT ?", "params": "one"}`,
+		`{"query": "SELECT ?, ?", "params": [1]}`,
+		`{"query": "BEGIN; SELECT 1"}`,
+	} {
+		if rr, _ := send(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d: %s", body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestExecPolicy(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.OPTIONS("/__/exec", Exec(pool))
+
+	send := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("OPTIONS", "/__/exec", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	if rr, _ := send(`{"query": "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT); CREATE INDEX cats_name ON cats (name); CREATE VIEW cat_names AS SELECT name FROM cats; CREATE TABLE log (msg TEXT)"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Statements are checked by what they do, not by their text
+	if rr, _ := send(`{"query": "SELECT 'DELETE FROM cats; DROP TABLE cats' AS text"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for a string literal, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for body, action := range map[string]string{
+		`{"query": "DROP  TABLE cats"}`:     "drop on cats",
+		`{"query": "DROP VIEW cat_names"}`:  "drop on cat_names",
+		`{"query": "DROP INDEX cats_name"}`: "drop on cats_name",
+		`{"query": "WITH gone AS (SELECT 1) DELETE FROM cats WHERE id IN (SELECT * FROM gone)"}`: "delete on cats",
+		`{"query": "ALTER TABLE cats ADD COLUMN paw INTEGER"}`:                                   "alter on cats",
+		`{"query": "ATTACH DATABASE ':memory:' AS other"}`:                                       "attach on :memory:",
+	} {
+		rr, response := send(body)
+		if rr.Code != http.StatusForbidden || response["message"] != "Query not allowed: "+action {
+			t.Errorf("Expected status 403 naming %q for %s, got %d: %s", action, body, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Per table policies, with "*" for the other tables
+	t.Setenv("SQLITE_REST_EXEC_POLICY", `{"*": ["read"], "log": ["read", "insert", "delete"]}`)
+	if rr, _ := send(`{"query": "INSERT INTO log VALUES ('hello'); DELETE FROM log"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for writes to log, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr, response := send(`{"query": "INSERT INTO log VALUES ('hello'); INSERT INTO cats (name) VALUES ('Tequila')"}`)
+	if rr.Code != http.StatusForbidden || response["message"] != "Statement 1: Query not allowed: insert on cats" {
+		t.Errorf("Expected status 403 for statement 1, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, response := send(`{"query": "SELECT * FROM log"}`); response["count"] != float64(0) {
+		t.Errorf("Expected the denied script to be rolled back, got %v", response["count"])
+	}
+	if rr, _ := send(`{"query": "SELECT upper(msg) FROM log"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a function call, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	t.Setenv("SQLITE_REST_EXEC_POLICY", `{"*": ["everything"]}`)
+	if rr, _ := send(`{"query": "SELECT 1"}`); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 for an invalid policy, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The legacy list of dangerous operations still applies
+	t.Setenv("SQLITE_REST_EXEC_POLICY", "")
+	t.Setenv("SQLITE_REST_DANGEROUS_OPS", "DROP TABLE")
+	if rr, _ := send(`{"query": "DELETE FROM log"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for DELETE, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send(`{"query": "DROP TABLE log"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for DROP TABLE, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestExecStatementClassification(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.OPTIONS("/__/exec", Exec(pool))
+
+	send := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("OPTIONS", "/__/exec", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	rr, response := send(`{"query": "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)"}`)
+	if rr.Code != http.StatusOK || response["type"] != "create" || response["readonly"] != false {
+		t.Fatalf("Expected a mutating create, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Statements returning rows are recognized whatever they start with
+	for _, tc := range []struct {
+		query    string
+		kind     string
+		readonly bool
+		count    float64
+	}{
+		{"INSERT INTO cats (name) VALUES ('Tequila'), ('Mezcal') RETURNING id, name", "insert", false, 2},
+		{"WITH named AS (SELECT name FROM cats) SELECT * FROM named", "with", true, 2},
+		{"VALUES (1, 'one'), (2, 'two'), (3, 'three')", "values", true, 3},
+		{"-- the first cat\nSELECT name FROM cats WHERE id = 1", "select", true, 1},
+		{"/* every cat */ SELECT name FROM cats", "select", true, 2},
+	} {
+		body, _ := json.Marshal(map[string]string{"query": tc.query})
+		rr, response := send(string(body))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for %q, got %d: %s", tc.query, rr.Code, rr.Body.String())
+			continue
+		}
+		if response["type"] != tc.kind || response["readonly"] != tc.readonly || response["count"] != tc.count {
+			t.Errorf("Expected %s with readonly %v and %v rows for %q, got %s", tc.kind, tc.readonly, tc.count, tc.query, rr.Body.String())
+		}
+	}
+
+	// Statements returning no rows report the changes
+	rr, response = send(`{"query": "WITH gone AS (SELECT 1) UPDATE cats SET name = upper(name)"}`)
+	if rr.Code != http.StatusOK || response["readonly"] != false || response["rows_affected"] != float64(2) {
+		t.Errorf("Expected 2 updated rows, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,26 +1,24 @@
 package controllers
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/paradoxe35/sqlite-rest/pkg/db"
 )
 
-func Get(dbPath string) httprouter.Handle {
+func Get(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+		// Use the shared reader pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, false)
+		if !ok {
 			return
 		}
-		defer db.Close()
+		defer release()
 
 		// Parse table name from params
 		tableSelect := params.ByName("table")
@@ -29,26 +27,66 @@ func Get(dbPath string) httprouter.Handle {
 			return
 		}
 
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Choose the response format from the format parameter or Accept
+		format, err := negotiateFormat(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
 		// Parse id from params
 		idParam := params.ByName("id")
 		if idParam == "" {
 			sendJSONError(w, "Missing ID parameter", http.StatusBadRequest)
 			return
 		}
-		id, err := strconv.ParseInt(idParam, 10, 64)
+		key, err := table.ParseKey(keyParam(r, idParam))
 		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Invalid ID format: %s", err.Error()), http.StatusBadRequest)
+			sendResolveError(w, err)
 			return
 		}
+		keyWhere, keyArgs := key.Where()
 
 		// Parse columns from params or use all
-		columnsSelect := r.URL.Query().Get("columns")
-		if columnsSelect == "" {
-			columnsSelect = "*"
+		columnsSelect, err := table.SelectList(r.URL.Query().Get("columns"))
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Read the ETag and the record from the same snapshot
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error retrieving record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		etag, err := table.rowETag(tx, key)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error retrieving record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		// The format may be chosen by Accept, each one has its own tag
+		w.Header().Set("Vary", "Accept")
+		if etag != "" {
+			etag = formatETag(etag, format)
+			w.Header().Set("ETag", etag)
+			if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && matchETag(ifNoneMatch, etag, true) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
 		}
 
 		// Execute query
-		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE id = %d", columnsSelect, tableSelect, id))
+		rows, err := tx.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s", columnsSelect, table.Quoted(), keyWhere), keyArgs...)
 		if err != nil {
 			// Check if this is a syntax error (client error) or a server error
 			errMsg := err.Error()
@@ -63,99 +101,45 @@ func Get(dbPath string) httprouter.Handle {
 		}
 		defer rows.Close()
 
-		// Get column names
-		columnNames, err := rows.Columns()
+		scanner, err := newRowScanner(rows, 0)
 		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Error retrieving column names: %s", err.Error()), http.StatusInternalServerError)
+			sendJSONError(w, fmt.Sprintf("Error retrieving columns: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
 
-		// Get column types
-		columnTypes, err := rows.ColumnTypes()
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Error retrieving column types: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-
-		// Create slice of pointers to scan into
-		columnPtrs := make([]interface{}, len(columnNames))
-
-		// Infer type from column type
-		for i := range columnNames {
-			// Refer to https://www.sqlite.org/datatype3.html index 3.1.1
-			switch strings.ToUpper(columnTypes[i].DatabaseTypeName()) {
-			case "PRIMARY_KEY", "INTEGER", "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8", "DECIMAL":
-				columnPtrs[i] = new(sql.NullInt64)
-			case "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT", "NUMERIC":
-				columnPtrs[i] = new(sql.NullFloat64)
-			case "BLOB":
-				columnPtrs[i] = new([]byte)
-			case "TEXT", "CHARACTER", "VARCHAR", "VARYING CHARACTER", "NCHAR", "NATIVE CHARACTER", "NVARCHAR", "CLOB", "DATE", "DATETIME":
-				columnPtrs[i] = new(sql.NullString)
-			case "BOOLEAN", "BOOL":
-				columnPtrs[i] = new(sql.NullBool)
-			default:
-				columnPtrs[i] = new(sql.NullString)
-			}
-		}
-
 		// Check if row exists
 		next := rows.Next()
 		if !next {
-			sendJSONError(w, fmt.Sprintf("Record with ID %d not found", id), http.StatusNotFound)
+			sendJSONError(w, fmt.Sprintf("Record with key %s not found", key), http.StatusNotFound)
 			return
 		}
 
-		// Scan row into column pointers
-		err = rows.Scan(columnPtrs...)
+		// Scan row into data map
+		data, _, err := scanner.Scan()
 		if err != nil {
 			sendJSONError(w, fmt.Sprintf("Error scanning record data: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
 
-		// Compose data map
-		data := make(map[string]interface{})
-		for i, columnKey := range columnNames {
-
-			// Preserve null values from db
-			switch strings.ToUpper(columnTypes[i].DatabaseTypeName()) {
-			case "PRIMARY_KEY", "INTEGER", "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8", "DECIMAL":
-				if columnPtrs[i].(*sql.NullInt64).Valid {
-					data[columnKey] = columnPtrs[i].(*sql.NullInt64).Int64
-				} else {
-					data[columnKey] = nil
-				}
-			case "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT", "NUMERIC":
-				if columnPtrs[i].(*sql.NullFloat64).Valid {
-					data[columnKey] = columnPtrs[i].(*sql.NullFloat64).Float64
-				} else {
-					data[columnKey] = nil
-				}
-			case "BLOB":
-				if columnPtrs[i].(*[]byte) != nil {
-					data[columnKey] = columnPtrs[i].(*[]byte)
-				} else {
-					data[columnKey] = nil
-				}
-			case "TEXT", "CHARACTER", "VARCHAR", "VARYING CHARACTER", "NCHAR", "NATIVE CHARACTER", "NVARCHAR", "CLOB", "DATE", "DATETIME":
-				if columnPtrs[i].(*sql.NullString).Valid {
-					data[columnKey] = columnPtrs[i].(*sql.NullString).String
-				} else {
-					data[columnKey] = nil
-				}
-			case "BOOLEAN", "BOOL":
-				if columnPtrs[i].(*sql.NullBool).Valid {
-					data[columnKey] = columnPtrs[i].(*sql.NullBool).Bool
-				} else {
-					data[columnKey] = nil
-				}
-			default:
-				if columnPtrs[i].(*sql.NullString).Valid {
-					data[columnKey] = columnPtrs[i].(*sql.NullString).String
-				} else {
-					data[columnKey] = nil
-				}
+		// Other formats hold the record only. It is written out before the
+		// headers are sent, so failures are still reported.
+		if format != formatJSON {
+			var body bytes.Buffer
+			writer, err := newRowWriter(&body, format, scanner.Columns())
+			if err == nil {
+				err = writer.Write(data)
 			}
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				sendJSONError(w, fmt.Sprintf("Error writing record: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			streamHeaders(w, format, table.Name)
+			w.WriteHeader(http.StatusOK)
+			w.Write(body.Bytes())
+			return
 		}
 
 		// Return success response
@@ -1,11 +1,9 @@
 package controllers
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 
@@ -13,21 +11,14 @@ import (
 	"github.com/paradoxe35/sqlite-rest/pkg/db"
 )
 
-type Filter struct {
-	Column   string `json:"column"`
-	Operator string `json:"operator"`
-	Value    string `json:"value"`
-}
-
-func GetAll(dbPath string) httprouter.Handle {
+func GetAll(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+		// Use the shared reader pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, false)
+		if !ok {
 			return
 		}
-		defer db.Close()
+		defer release()
 
 		// Parse table name from params
 		tableSelect := params.ByName("table")
@@ -36,61 +27,113 @@ func GetAll(dbPath string) httprouter.Handle {
 			return
 		}
 
-		// Parse columns from params or use all
-		var columnsSelect string
-		columnsParam := r.URL.Query().Get("cols")
-		if columnsParam == "" {
-			columnsSelect = "*"
-		} else {
-			columnsSelect = columnsParam
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
 		}
 
-		// Parse filters_raw from query string and build WHERE clause
-		var whereClause string
-		filtersParam := r.URL.Query().Get("filters_raw")
+		// Choose the response format from the format parameter or Accept
+		format, err := negotiateFormat(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
 
-		if filtersParam != "" {
-			unescapedFilters, err := url.QueryUnescape(filtersParam)
-			if err != nil {
-				sendJSONError(w, fmt.Sprintf("Error unescaping filters_raw: %s", err.Error()), http.StatusBadRequest)
-				return
-			}
-			whereClause = "WHERE " + unescapedFilters
+		// Parse columns from params or use all
+		query := r.URL.Query()
+		columnsSelect, err := table.SelectList(query.Get("cols"))
+		if err != nil {
+			sendResolveError(w, err)
+			return
 		}
 
-		filtersStruct := r.URL.Query().Get("filters")
-		if filtersStruct != "" {
-			if filtersParam != "" {
-				sendJSONError(w, "Cannot use both filters and filters_raw parameters", http.StatusBadRequest)
+		// Parse columns, embedded resources and aggregates from the select
+		// parameter
+		var embeds []*embedSelect
+		var agg *aggregation
+		if selectParam := query.Get("select"); selectParam != "" {
+			if query.Get("cols") != "" {
+				sendJSONError(w, "Cannot use both cols and select parameters", http.StatusBadRequest)
 				return
 			}
-
-			filterArr := []Filter{}
-
-			unescapedFilters, err := url.QueryUnescape(filtersStruct)
+			sel, err := parseSelect(selectParam)
+			if err == nil {
+				agg, err = table.Aggregation(sel, query.Get("group_by"))
+			}
+			if err == nil && agg != nil {
+				columnsSelect = agg.Select()
+			} else if err == nil {
+				embeds = sel.Embeds
+				columnsSelect, err = selectColumns(table, sel.Columns, embeds)
+			}
+			if err == nil {
+				query = routeEmbedParams(query, embeds)
+				err = resolveEmbeds(db, table, embeds)
+			}
 			if err != nil {
-				sendJSONError(w, fmt.Sprintf("Error unescaping filters: %s", err.Error()), http.StatusBadRequest)
+				sendResolveError(w, err)
+				return
+			}
+		}
+		if agg == nil && (query.Get("group_by") != "" || query.Get("having") != "") {
+			sendJSONError(w, "The group_by and having parameters require the select parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Filter groups with the having parameter
+		var havingClause string
+		var havingArgs []interface{}
+		if agg != nil {
+			if query.Get("count") != "" || query.Get("cursor") != "" {
+				sendJSONError(w, "Cannot use count or cursor parameters with aggregates", http.StatusBadRequest)
 				return
 			}
-			err = json.Unmarshal([]byte(unescapedFilters), &filterArr)
+			havingClause, havingArgs, err = agg.Having(query["having"])
 			if err != nil {
-				sendJSONError(w, fmt.Sprintf("Invalid filters format: %s", err.Error()), http.StatusBadRequest)
+				sendResolveError(w, err)
 				return
 			}
+		}
 
-			var filterArrStr []string
-			for _, filter := range filterArr {
-				filterArrStr = append(filterArrStr, fmt.Sprintf("%s %s '%s'", filter.Column, filter.Operator, filter.Value))
-			}
+		// Build WHERE clause from filters_raw, filters and column filters
+		var whereClause string
+		conditions, whereArgs, err := buildWhere(query, table)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		if conditions != "" {
+			whereClause = "WHERE " + conditions
+		}
 
-			whereClause = "WHERE " + strings.Join(filterArrStr, " AND ")
+		// Parse the count method from query string
+		countMethod, err := parseCountMethod(r.URL.Query().Get("count"))
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Streamed formats hold the rows only
+		if format != formatJSON && (len(embeds) > 0 || countMethod != "") {
+			sendJSONError(w, fmt.Sprintf("Embedded resources and counts are not available in %s responses", format), http.StatusBadRequest)
+			return
 		}
 
 		// Parse limitClause from query string
 		var limitClause string
+		var limitArgs []interface{}
+		var limit, offset int64
 		limitParam := r.URL.Query().Get("limit")
 		if limitParam != "" {
-			limitClause = "LIMIT " + limitParam
+			limit, err = strconv.ParseInt(limitParam, 10, 64)
+			if err != nil || limit < 0 {
+				sendJSONError(w, fmt.Sprintf("Invalid limit parameter: %s", limitParam), http.StatusBadRequest)
+				return
+			}
+			limitClause = "LIMIT ?"
+			limitArgs = append(limitArgs, limit)
 		}
 
 		// Parse offsetClause from query string
@@ -101,26 +144,91 @@ func GetAll(dbPath string) httprouter.Handle {
 			return
 		}
 		if offsetParam != "" {
-			offsetClause = "OFFSET " + offsetParam
+			offset, err = strconv.ParseInt(offsetParam, 10, 64)
+			if err != nil || offset < 0 {
+				sendJSONError(w, fmt.Sprintf("Invalid offset parameter: %s", offsetParam), http.StatusBadRequest)
+				return
+			}
+			offsetClause = "OFFSET ?"
+			limitArgs = append(limitArgs, offset)
 		}
 
-		// Parse order by from query string
-		var orderByClause string
+		// Parse order by and direction from query string
 		orderByParam := r.URL.Query().Get("order_by")
-		if orderByParam != "" {
-			orderByClause = "ORDER BY " + orderByParam
-		}
-
-		// Parse order direction from query string
 		orderDir := r.URL.Query().Get("order_dir")
 		if orderDir != "" && orderByParam == "" {
 			sendJSONError(w, "Cannot use order_dir parameter without order_by parameter", http.StatusBadRequest)
 			return
 		}
+		var orderTerms []orderTerm
+		if agg != nil {
+			orderTerms, err = agg.OrderTerms(orderByParam, orderDir)
+		} else {
+			orderTerms, err = table.OrderTerms(orderByParam, orderDir)
+		}
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		orderBy := orderByClause(orderTerms)
+
+		// Paged queries are also ordered by the primary key so every row has
+		// a unique position a cursor can resume from
+		cursorParam := r.URL.Query().Get("cursor")
+		if cursorParam != "" && offsetParam != "" {
+			sendJSONError(w, "Cannot use both cursor and offset parameters", http.StatusBadRequest)
+			return
+		}
+		var keysetTerms []orderTerm
+		keyset := false
+		if (limitParam != "" || cursorParam != "") && agg == nil {
+			keysetTerms, keyset = table.KeysetTerms(orderTerms)
+		}
+		if cursorParam != "" && !keyset {
+			sendJSONError(w, fmt.Sprintf("Cursor pagination is not supported on view %s", table.Name), http.StatusBadRequest)
+			return
+		}
+		queryWhere := whereClause
+		queryArgs := whereArgs
+		var selected []string
+		if columnsSelect != "" {
+			selected = append(selected, columnsSelect)
+		}
+		if keyset {
+			orderBy = orderByClause(keysetTerms)
+			selected = append(selected, cursorSelect(keysetTerms))
+		}
+
+		// Embedded resources are matched on hidden key columns
+		embedColumns, embedWidth := embedKeySelect(embeds)
+		selected = append(selected, embedColumns...)
+
+		// Resume after the last row of the previous page
+		if cursorParam != "" {
+			cursorValues, err := decodeCursor(cursorParam, table, keysetTerms)
+			if err != nil {
+				sendResolveError(w, err)
+				return
+			}
+			seek, seekArgs := seekCondition(keysetTerms, cursorValues)
+			if conditions != "" {
+				queryWhere = fmt.Sprintf("WHERE (%s) AND %s", conditions, seek)
+			} else {
+				queryWhere = "WHERE " + seek
+			}
+			queryArgs = append(append([]interface{}{}, whereArgs...), seekArgs...)
+		}
+
+		// Group rows when aggregating
+		var groupByClause string
+		if agg != nil {
+			groupByClause = agg.GroupBy()
+			queryArgs = append(append([]interface{}{}, queryArgs...), havingArgs...)
+		}
 
 		// Execute query
-		query := fmt.Sprintf("SELECT %s FROM %s %s %s %s %s %s", columnsSelect, tableSelect, whereClause, orderByClause, orderDir, limitClause, offsetClause)
-		rows, err := db.Query(query)
+		statement := fmt.Sprintf("SELECT %s FROM %s %s %s %s %s %s %s", strings.Join(selected, ", "), table.Quoted(), queryWhere, groupByClause, havingClause, orderBy, limitClause, offsetClause)
+		rows, err := db.Query(statement, append(queryArgs, limitArgs...)...)
 		if err != nil {
 			// Check if this is a syntax error (client error) or a server error
 			errMsg := err.Error()
@@ -130,6 +238,8 @@ func GetAll(dbPath string) httprouter.Handle {
 				sendJSONError(w, fmt.Sprintf("Invalid column in query: %s", errMsg), http.StatusBadRequest)
 			} else if strings.Contains(errMsg, "syntax error") {
 				sendJSONError(w, fmt.Sprintf("SQL syntax error: %s", errMsg), http.StatusBadRequest)
+			} else if strings.Contains(errMsg, "not authorized") {
+				sendJSONError(w, "Query not allowed: reads cannot write", http.StatusForbidden)
 			} else {
 				sendJSONError(w, fmt.Sprintf("Error executing query: %s", errMsg), http.StatusInternalServerError)
 			}
@@ -137,132 +247,144 @@ func GetAll(dbPath string) httprouter.Handle {
 		}
 		defer rows.Close()
 
-		// Get column names
-		var columnNames []string
-		columnNames, err = rows.Columns()
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Error retrieving column names: %s", err.Error()), http.StatusInternalServerError)
-			return
+		// Hidden cursor and embedding key columns come last and are scanned
+		// as stored
+		cursorWidth := 0
+		if keyset {
+			cursorWidth = len(keysetTerms)
 		}
-
-		// Get column types
-		columnTypes, err := rows.ColumnTypes()
+		scanner, err := newRowScanner(rows, cursorWidth+embedWidth)
 		if err != nil {
 			sendJSONError(w, fmt.Sprintf("Error retrieving column types: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
 
-		// Scan rows
-		var data []map[string]interface{}
-		for rows.Next() {
-			// Create slice of pointers to scan into
-			columnPtrs := make([]interface{}, len(columnNames))
-
-			// Infer type from column type
-			for i := range columnNames {
-				switch strings.ToUpper(columnTypes[i].DatabaseTypeName()) {
-				case "PRIMARY_KEY", "INTEGER", "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8", "DECIMAL":
-					columnPtrs[i] = new(sql.NullInt64)
-				case "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT", "NUMERIC":
-					columnPtrs[i] = new(sql.NullFloat64)
-				case "BLOB":
-					columnPtrs[i] = new([]byte)
-				case "TEXT", "CHARACTER", "VARCHAR", "VARYING CHARACTER", "NCHAR", "NATIVE CHARACTER", "NVARCHAR", "CLOB", "DATE", "DATETIME":
-					columnPtrs[i] = new(sql.NullString)
-				case "BOOLEAN", "BOOL":
-					columnPtrs[i] = new(sql.NullBool)
-				default:
-					columnPtrs[i] = new(sql.NullString)
+		// Other formats are written as the rows are scanned
+		if format != formatJSON {
+			if err := streamRows(w, format, table.Name, scanner); err != nil {
+				var failure *streamFailure
+				if errors.As(err, &failure) {
+					abortStream(w)
+					return
 				}
+				sendJSONError(w, fmt.Sprintf("Error scanning row data: %s", err.Error()), http.StatusInternalServerError)
 			}
+			return
+		}
+
+		// Rows are written as they are scanned, except when embedding
+		// resources, which are loaded in batches for every row at once.
+		// Content-Range cannot be a trailer, so counted pages are held until
+		// their range is known, and streamed pages describe their range with
+		// the offset and total_rows fields only.
+		stream := newJSONStream(w, "data", "Link")
+		if countMethod != "" {
+			stream.Hold()
+		}
+		var lastKey []interface{}
+		var data []map[string]interface{}
+		var embedKeys [][]interface{}
 
-			// Scan row into column pointers
-			err = rows.Scan(columnPtrs...)
+		// Scan rows
+		for rows.Next() {
+			rowData, hidden, err := scanner.Scan()
 			if err != nil {
-				sendJSONError(w, fmt.Sprintf("Error scanning row data: %s", err.Error()), http.StatusInternalServerError)
+				stream.Fail(fmt.Sprintf("Error scanning row data: %s", err.Error()), http.StatusInternalServerError)
 				return
 			}
-
-			// Compose row data map
-			rowData := make(map[string]interface{})
-			for i, columnKey := range columnNames {
-
-				// Preserve null values from db
-				switch strings.ToUpper(columnTypes[i].DatabaseTypeName()) {
-				case "PRIMARY_KEY", "INTEGER", "INT", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8", "DECIMAL":
-					if columnPtrs[i].(*sql.NullInt64).Valid {
-						rowData[columnKey] = columnPtrs[i].(*sql.NullInt64).Int64
-					} else {
-						rowData[columnKey] = nil
-					}
-				case "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT", "NUMERIC":
-					if columnPtrs[i].(*sql.NullFloat64).Valid {
-						rowData[columnKey] = columnPtrs[i].(*sql.NullFloat64).Float64
-					} else {
-						rowData[columnKey] = nil
-					}
-				case "BLOB":
-					if columnPtrs[i].(*[]byte) != nil {
-						rowData[columnKey] = columnPtrs[i].(*[]byte)
-					} else {
-						rowData[columnKey] = nil
-					}
-				case "TEXT", "CHARACTER", "VARCHAR", "VARYING CHARACTER", "NCHAR", "NATIVE CHARACTER", "NVARCHAR", "CLOB", "DATE", "DATETIME":
-					if columnPtrs[i].(*sql.NullString).Valid {
-						rowData[columnKey] = columnPtrs[i].(*sql.NullString).String
-					} else {
-						rowData[columnKey] = nil
-					}
-				case "BOOLEAN", "BOOL":
-					if columnPtrs[i].(*sql.NullBool).Valid {
-						rowData[columnKey] = columnPtrs[i].(*sql.NullBool).Bool
-					} else {
-						rowData[columnKey] = nil
-					}
-				default:
-					if columnPtrs[i].(*sql.NullString).Valid {
-						rowData[columnKey] = columnPtrs[i].(*sql.NullString).String
-					} else {
-						rowData[columnKey] = nil
-					}
-				}
+			lastKey = hidden[:cursorWidth]
+			if len(embeds) > 0 {
+				data = append(data, rowData)
+				embedKeys = append(embedKeys, hidden[cursorWidth:])
+			} else if err := stream.Write(rowData); err != nil {
+				stream.Fail(fmt.Sprintf("Error writing row data: %s", err.Error()), http.StatusInternalServerError)
+				return
 			}
-			data = append(data, rowData)
 		}
 
 		// Check for errors from iterating over rows
 		if err = rows.Err(); err != nil {
-			sendJSONError(w, fmt.Sprintf("Error iterating over rows: %s", err.Error()), http.StatusInternalServerError)
+			stream.Fail(fmt.Sprintf("Error iterating over rows: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
+		rows.Close()
+
+		// Load embedded resources in batches
+		if len(embeds) > 0 {
+			if err := attachEmbeds(db, data, embedKeys, embeds); err != nil {
+				stream.Fail(fmt.Sprintf("Error loading embedded resources: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			for _, rowData := range data {
+				if err := stream.Write(rowData); err != nil {
+					stream.Fail(fmt.Sprintf("Error writing row data: %s", err.Error()), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		rowCount := stream.Rows()
 
-		// Compose response and return data
+		// Count the rows matching the filters when requested
+		var total *int64
+		if countMethod != "" {
+			count, method, err := countRows(db, table, countMethod, conditions, whereArgs)
+			if err != nil {
+				stream.Fail(fmt.Sprintf("Error counting rows: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			total = &count
+			countMethod = method
+		}
+
+		// Compose the fields following the data
 		response := map[string]interface{}{
 			"status":     "success",
-			"total_rows": len(data),
-			"data":       data,
+			"total_rows": rowCount,
 		}
 
 		if offsetParam != "" {
-			offset, _ := strconv.ParseInt(offsetParam, 10, 64)
 			response["offset"] = offset
 		} else {
 			response["offset"] = nil
 		}
 
 		if limitParam != "" {
-			limit, _ := strconv.ParseInt(limitParam, 10, 64)
 			response["limit"] = limit
 		} else {
 			response["limit"] = nil
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		err = json.NewEncoder(w).Encode(response)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Error encoding response: %s", err.Error()), http.StatusInternalServerError)
-			return
+		if total != nil {
+			response["total_count"] = *total
+			response["count_method"] = countMethod
 		}
+
+		// A full page may be followed by another one
+		var nextCursor string
+		if keyset && limitParam != "" {
+			response["next_cursor"] = nil
+			if limit > 0 && int64(rowCount) == limit {
+				nextCursor, err = encodeCursor(table, keysetTerms, lastKey)
+				if err != nil {
+					stream.Fail(fmt.Sprintf("Error encoding cursor: %s", err.Error()), http.StatusInternalServerError)
+					return
+				}
+				response["next_cursor"] = nextCursor
+			}
+		}
+
+		// Describe the page in headers, or in trailers when the rows were
+		// streamed. Cursor pages have no known offset.
+		headers := make(map[string]string)
+		var links []string
+		if cursorParam != "" {
+			links = cursorLinks(r, nextCursor)
+		} else {
+			headers["Content-Range"] = contentRange(offset, rowCount, total)
+			links = paginationLinks(r, offset, limit, rowCount, total)
+		}
+		headers["Link"] = strings.Join(links, ", ")
+
+		stream.Close(response, headers)
 	}
 }
@@ -11,15 +11,10 @@ import (
 )
 
 // GetTables returns a list of all tables in the database
-func GetTables(dbPath string) httprouter.Handle {
+func GetTables(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-		defer db.Close()
+		// Use the shared reader pool
+		db := pool.Reader
 
 		// Get tables
 		tables, err := listTables(db)
@@ -50,15 +45,10 @@ func GetTables(dbPath string) httprouter.Handle {
 }
 
 // GetTableSchema returns the schema of a specific table
-func GetTableSchema(dbPath string) httprouter.Handle {
+func GetTableSchema(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-		defer db.Close()
+		// Use the shared reader pool
+		db := pool.Reader
 
 		// Parse table name from params
 		tableName := params.ByName("table")
@@ -108,15 +98,10 @@ func GetTableSchema(dbPath string) httprouter.Handle {
 }
 
 // GetDatabaseInfo returns general information about the database
-func GetDatabaseInfo(dbPath string) httprouter.Handle {
+func GetDatabaseInfo(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-		defer db.Close()
+		// Use the shared reader pool
+		db := pool.Reader
 
 		// Get tables
 		tables, err := listTables(db)
@@ -147,6 +132,13 @@ func GetDatabaseInfo(dbPath string) httprouter.Handle {
 
 		dbSize := pageCount * pageSize
 
+		// Get the pragmas in effect on the pooled connections
+		pragmas, err := pool.EffectivePragmas()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error reading pragmas: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
 		// Set response headers
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -158,21 +150,17 @@ func GetDatabaseInfo(dbPath string) httprouter.Handle {
 			"table_count":    len(tables),
 			"tables":         tables,
 			"database_size":  dbSize,
-			"database_path":  dbPath,
+			"database_path":  pool.Path(),
+			"pragmas":        pragmas,
 		})
 	}
 }
 
 // GetForeignKeys returns foreign key relationships for a specific table
-func GetForeignKeys(dbPath string) httprouter.Handle {
+func GetForeignKeys(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-		defer db.Close()
+		// Use the shared reader pool
+		db := pool.Reader
 
 		// Parse table name from params
 		tableName := params.ByName("table")
@@ -237,21 +225,15 @@ func GetApiVersion() httprouter.Handle {
 }
 
 // HealthCheck returns a simple health check response
-func HealthCheck(dbPath string) httprouter.Handle {
+func HealthCheck(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
-			return
-		}
-		defer db.Close()
-
-		// Check if database is accessible
-		err = db.Ping()
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database ping failed: %s", err.Error()), http.StatusInternalServerError)
-			return
+		// Check if both pools can reach the database
+		for _, db := range []*sql.DB{pool.Reader, pool.Writer} {
+			err := db.Ping()
+			if err != nil {
+				sendJSONError(w, fmt.Sprintf("Database ping failed: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
 		}
 
 		// Set response headers
@@ -271,46 +253,32 @@ func HealthCheck(dbPath string) httprouter.Handle {
 // getTableSchema returns the schema of a specific table
 func getTableSchema(db *sql.DB, tableName string) ([]map[string]interface{}, error) {
 	// In SQLite, we can use PRAGMA table_info to get table schema
-	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+	columns, err := readTableInfo(db, tableName)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var schema []map[string]interface{}
-	for rows.Next() {
-		var cid int
-		var name, dataType string
-		var notNull, pk int
-		var dfltValue interface{}
-
-		if err := rows.Scan(&cid, &name, &dataType, &notNull, &dfltValue, &pk); err != nil {
-			return nil, err
-		}
-
+	for cid, c := range columns {
 		column := map[string]interface{}{
 			"cid":         cid,
-			"name":        name,
-			"type":        dataType,
-			"notnull":     notNull == 1,
-			"default_val": dfltValue,
-			"pk":          pk == 1,
+			"name":        c.Name,
+			"type":        c.Type,
+			"notnull":     c.NotNull,
+			"default_val": c.Default,
+			"pk":          c.PK > 0,
 		}
 
 		schema = append(schema, column)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
 	return schema, nil
 }
 
 // getForeignKeys returns foreign key relationships for a specific table
 func getForeignKeys(db *sql.DB, tableName string) ([]map[string]interface{}, error) {
 	// In SQLite, we can use PRAGMA foreign_key_list to get foreign keys
-	rows, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", tableName))
+	rows, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", quoteIdent(tableName)))
 	if err != nil {
 		return nil, err
 	}
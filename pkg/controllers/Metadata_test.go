@@ -21,7 +21,7 @@ func TestGetTables(t *testing.T) {
 
 	// Create a test router
 	router := httprouter.New()
-	router.GET("/__/tables", GetTables(tmpFile.Name()))
+	router.GET("/__/tables", GetTables(openTestPool(t, tmpFile.Name())))
 
 	// Create a test request
 	req, err := http.NewRequest("GET", "/__/tables", nil)
@@ -72,7 +72,7 @@ func TestHealthCheck(t *testing.T) {
 
 	// Create a test router
 	router := httprouter.New()
-	router.GET("/__/health", HealthCheck(tmpFile.Name()))
+	router.GET("/__/health", HealthCheck(openTestPool(t, tmpFile.Name())))
 
 	// Create a test request
 	req, err := http.NewRequest("GET", "/__/health", nil)
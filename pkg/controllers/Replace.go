@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+func Replace(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Parse table name from params
+		tableSelect := params.ByName("table")
+		if tableSelect == "" {
+			sendJSONError(w, "Missing table parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Parse id from params
+		idParam := params.ByName("id")
+		if idParam == "" {
+			sendJSONError(w, "Missing ID parameter", http.StatusBadRequest)
+			return
+		}
+		key, err := table.ParseKey(keyParam(r, idParam))
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		keyWhere, keyArgs := key.Where()
+
+		// Read what is returned of the record
+		repr, err := table.Representation(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Parse body data
+		data := make(map[string]interface{})
+		err = decodeJSON(r.Body, &data)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		// Key columns come from the URL, the body may only repeat them
+		for i, column := range key.Columns {
+			for name, value := range data {
+				if !strings.EqualFold(name, column) {
+					continue
+				}
+				bound, err := bindValue(value)
+				if err != nil || fmt.Sprint(bound) != fmt.Sprint(key.Values[i]) {
+					sendJSONError(w, fmt.Sprintf("Key column %s in body does not match the URL", column), http.StatusBadRequest)
+					return
+				}
+				delete(data, name)
+			}
+		}
+
+		// Extract and validate columns and bound values from data
+		columnNames, columnValues, err := bindInsert(table, data)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		keyColumns := table.quotedKey()
+		columnNames = append(columnNames, keyColumns...)
+		columnValues = append(columnValues, key.Values...)
+
+		// Every other column is replaced, those missing from the body are
+		// reset to their default
+		conflict := &conflictClause{target: keyColumns, resolution: mergeDuplicates, update: []string{}, version: table.quotedVersion()}
+		for _, column := range table.ColumnNames() {
+			conflict.update = append(conflict.update, quoteIdent(column))
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error replacing record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Check the record is still the one the client read
+		matched, err := table.checkIfMatch(tx, r, key)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error replacing record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if !matched {
+			sendJSONError(w, fmt.Sprintf("Record with key %s does not match If-Match", key), http.StatusPreconditionFailed)
+			return
+		}
+
+		var exists bool
+		var rows []map[string]interface{}
+		var etag string
+		err = tx.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s)", table.Quoted(), keyWhere), keyArgs...).Scan(&exists)
+		if err == nil {
+			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) %s", table.Quoted(), strings.Join(columnNames, ", "), placeholders(len(columnValues)), conflict.SQL(columnNames))
+			_, rows, err = table.execReturning(tx, query, columnValues, repr)
+		}
+		if err == nil {
+			etag, err = table.rowETag(tx, key)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			// Check if this is a syntax error (client error) or a server error
+			errMsg := err.Error()
+			if strings.Contains(errMsg, "constraint failed") || strings.Contains(errMsg, "UNIQUE constraint") {
+				sendJSONError(w, fmt.Sprintf("Constraint violation: %s", errMsg), http.StatusBadRequest)
+			} else if strings.Contains(errMsg, "datatype mismatch") {
+				sendJSONError(w, fmt.Sprintf("Invalid key %s: %s", key, errMsg), http.StatusBadRequest)
+			} else {
+				sendJSONError(w, fmt.Sprintf("Error replacing record: %s", errMsg), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Return success response, created when the key was new
+		response := map[string]interface{}{
+			"status": "success",
+			"id":     key.Value(),
+		}
+		if repr != nil && len(rows) > 0 {
+			response["data"] = rows[0]
+		}
+		status := http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+			w.Header().Set("Location", table.location(key))
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
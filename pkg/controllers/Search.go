@@ -0,0 +1,504 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// SearchIndexBody is the request body creating a search index
+type SearchIndexBody struct {
+	Columns  []string `json:"columns"`
+	Tokenize string   `json:"tokenize,omitempty"`
+}
+
+// searchIndexSuffix names the FTS5 table indexing a table, e.g. articles_fts
+const searchIndexSuffix = "_fts"
+
+// Defaults of the search parameters
+const (
+	defaultSearchLimit   = 20
+	defaultSnippetTokens = 16
+	maxSnippetTokens     = 64
+)
+
+// validTokenize matches tokenizer specifications, e.g. "porter unicode61"
+// or "unicode61 remove_diacritics 2"
+var validTokenize = regexp.MustCompile(`^[A-Za-z0-9_ ]+$`)
+
+// tokenizeOption reads the tokenizer from the statement creating an index
+var tokenizeOption = regexp.MustCompile(`tokenize\s*=\s*'((?:[^']|'')*)'`)
+
+// searchIndex is the external content FTS5 table indexing columns of a
+// table, kept in sync by triggers
+type searchIndex struct {
+	Name     string
+	Table    *tableSchema
+	Columns  []string
+	Tokenize string
+}
+
+// Quoted returns the quoted name of the FTS5 table
+func (s *searchIndex) Quoted() string {
+	return quoteIdent(s.Name)
+}
+
+// triggerNames returns the names of the sync triggers
+func (s *searchIndex) triggerNames() []string {
+	return []string{s.Name + "_ai", s.Name + "_ad", s.Name + "_au"}
+}
+
+// Describe returns the index as sent in responses
+func (s *searchIndex) Describe() map[string]interface{} {
+	return map[string]interface{}{
+		"table":    s.Table.Name,
+		"index":    s.Name,
+		"columns":  s.Columns,
+		"tokenize": s.Tokenize,
+		"triggers": s.triggerNames(),
+	}
+}
+
+// quoteString quotes a SQL string literal
+func quoteString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// createStatements returns the statements creating the FTS5 table and the
+// triggers applying every insert, delete and update of the table to it, as
+// described in https://www.sqlite.org/fts5.html#external_content_tables
+func (s *searchIndex) createStatements() []string {
+	quotedColumns := make([]string, len(s.Columns))
+	newValues := make([]string, len(s.Columns))
+	oldValues := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		quotedColumns[i] = quoteIdent(column)
+		newValues[i] = "new." + quoteIdent(column)
+		oldValues[i] = "old." + quoteIdent(column)
+	}
+	columns := strings.Join(quotedColumns, ", ")
+
+	options := fmt.Sprintf("content=%s, content_rowid='rowid'", quoteString(s.Table.Name))
+	if s.Tokenize != "" {
+		options += ", tokenize=" + quoteString(s.Tokenize)
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (rowid, %s) VALUES (new.rowid, %s);", s.Quoted(), columns, strings.Join(newValues, ", "))
+	remove := fmt.Sprintf("INSERT INTO %s (%s, rowid, %s) VALUES ('delete', old.rowid, %s);", s.Quoted(), s.Quoted(), columns, strings.Join(oldValues, ", "))
+	triggers := s.triggerNames()
+
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, %s)", s.Quoted(), columns, options),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN %s END", quoteIdent(triggers[0]), s.Table.Quoted(), insert),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN %s END", quoteIdent(triggers[1]), s.Table.Quoted(), remove),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN %s %s END", quoteIdent(triggers[2]), s.Table.Quoted(), remove, insert),
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES ('rebuild')", s.Quoted(), s.Quoted()),
+	}
+}
+
+// readSearchIndex returns the search index of a table, nil when it has none
+func readSearchIndex(db querier, table *tableSchema) (*searchIndex, error) {
+	name := table.Name + searchIndexSuffix
+	var definition string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ? AND sql LIKE 'CREATE VIRTUAL TABLE%USING fts5%'", name).Scan(&definition)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := readTableInfo(db, name)
+	if err != nil {
+		return nil, err
+	}
+	index := &searchIndex{Name: name, Table: table}
+	for _, column := range columns {
+		index.Columns = append(index.Columns, column.Name)
+	}
+	if match := tokenizeOption.FindStringSubmatch(definition); match != nil {
+		index.Tokenize = strings.ReplaceAll(match[1], "''", "'")
+	}
+	return index, nil
+}
+
+// resolveSearchIndex resolves the table named in params and its search index
+func resolveSearchIndex(w http.ResponseWriter, db querier, params httprouter.Params) (*searchIndex, bool) {
+	table, err := resolveTable(db, params.ByName("table"))
+	if err != nil {
+		sendResolveError(w, err)
+		return nil, false
+	}
+	index, err := readSearchIndex(db, table)
+	if err != nil {
+		sendJSONError(w, fmt.Sprintf("Error reading search index: %s", err.Error()), http.StatusInternalServerError)
+		return nil, false
+	}
+	if index == nil {
+		sendJSONError(w, fmt.Sprintf("Table %s has no search index", table.Name), http.StatusNotFound)
+		return nil, false
+	}
+	return index, true
+}
+
+// searchQueryErrors are the errors FTS5 reports for malformed queries
+var searchQueryErrors = []string{"fts5: syntax error", "unterminated string", "no such column", "unknown special query"}
+
+// sendSearchError sends the error of a full-text statement. SQLite builds
+// without FTS5 report it as a missing module.
+func sendSearchError(w http.ResponseWriter, err error) {
+	message := err.Error()
+	if strings.Contains(message, "no such module: fts5") {
+		sendJSONError(w, "Full-text search is not available, the server was built without FTS5 (the sqlite_fts5 build tag)", http.StatusNotImplemented)
+		return
+	}
+	for _, queryError := range searchQueryErrors {
+		if strings.Contains(message, queryError) {
+			sendJSONError(w, fmt.Sprintf("Invalid search query: %s", message), http.StatusBadRequest)
+			return
+		}
+	}
+	sendJSONError(w, writeErrorMessage(err), writeErrorStatus(err))
+}
+
+// sendSearchIndex sends the description of a search index
+func sendSearchIndex(w http.ResponseWriter, index *searchIndex, status int) {
+	response := index.Describe()
+	response["status"] = "success"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateSearchIndex creates an external content FTS5 index over columns of a
+// table, with triggers keeping it in sync, and indexes the existing rows
+func CreateSearchIndex(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Parse body data
+		body := SearchIndexBody{}
+		if err := decodeJSON(r.Body, &body); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		table, err := resolveTable(db, params.ByName("table"))
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		if table.View {
+			sendJSONError(w, fmt.Sprintf("Cannot index view %s, triggers cannot keep the index in sync", table.Name), http.StatusBadRequest)
+			return
+		}
+		if len(body.Columns) == 0 {
+			sendJSONError(w, "Missing columns in request body", http.StatusBadRequest)
+			return
+		}
+		if body.Tokenize != "" && !validTokenize.MatchString(body.Tokenize) {
+			sendJSONError(w, fmt.Sprintf("Invalid tokenize option: %s", body.Tokenize), http.StatusBadRequest)
+			return
+		}
+
+		index := &searchIndex{Name: table.Name + searchIndexSuffix, Table: table, Tokenize: body.Tokenize}
+		for _, name := range body.Columns {
+			column, err := table.Column(name)
+			if err != nil {
+				sendResolveError(w, err)
+				return
+			}
+			if containsString(index.Columns, column) {
+				sendJSONError(w, fmt.Sprintf("Duplicate column: %s", column), http.StatusBadRequest)
+				return
+			}
+			index.Columns = append(index.Columns, column)
+		}
+
+		// The index refers to rows by rowid
+		if _, err := db.Exec(fmt.Sprintf("SELECT rowid FROM %s LIMIT 0", table.Quoted())); err != nil {
+			sendJSONError(w, fmt.Sprintf("Cannot index table %s, it has no rowid", table.Name), http.StatusBadRequest)
+			return
+		}
+
+		existing, err := readSearchIndex(db, table)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error reading search index: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			sendJSONError(w, fmt.Sprintf("Table %s already has a search index", table.Name), http.StatusConflict)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error creating search index: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		for _, statement := range index.createStatements() {
+			if _, err := tx.Exec(statement); err != nil {
+				sendSearchError(w, err)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error creating search index: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/__/tables/%s/search-index", table.Name))
+		sendSearchIndex(w, index, http.StatusCreated)
+	}
+}
+
+// GetSearchIndex describes the search index of a table
+func GetSearchIndex(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared reader pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, false)
+		if !ok {
+			return
+		}
+		defer release()
+
+		index, ok := resolveSearchIndex(w, db, params)
+		if !ok {
+			return
+		}
+		sendSearchIndex(w, index, http.StatusOK)
+	}
+}
+
+// DropSearchIndex drops the search index of a table and its triggers
+func DropSearchIndex(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		index, ok := resolveSearchIndex(w, db, params)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error dropping search index: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		statements := []string{}
+		for _, trigger := range index.triggerNames() {
+			statements = append(statements, "DROP TRIGGER IF EXISTS "+quoteIdent(trigger))
+		}
+		statements = append(statements, "DROP TABLE "+index.Quoted())
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				sendSearchError(w, err)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error dropping search index: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"table":  index.Table.Name,
+			"index":  index.Name,
+		})
+	}
+}
+
+// RebuildSearchIndex reindexes every row of the table, e.g. after changes
+// made while the triggers were missing
+func RebuildSearchIndex(pool *db.Pool) httprouter.Handle {
+	return searchIndexCommand(pool, "rebuild")
+}
+
+// OptimizeSearchIndex merges the index segments for faster queries
+func OptimizeSearchIndex(pool *db.Pool) httprouter.Handle {
+	return searchIndexCommand(pool, "optimize")
+}
+
+// searchIndexCommand runs an FTS5 maintenance command on the search index of
+// a table
+func searchIndexCommand(pool *db.Pool, command string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		index, ok := resolveSearchIndex(w, db, params)
+		if !ok {
+			return
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (?)", index.Quoted(), index.Quoted()), command); err != nil {
+			sendSearchError(w, err)
+			return
+		}
+		sendSearchIndex(w, index, http.StatusOK)
+	}
+}
+
+// searchIntParam parses a non-negative integer parameter
+func searchIntParam(r *http.Request, name string, fallback int64) (int64, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return fallback, nil
+	}
+	value, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || value < 0 {
+		return 0, &requestError{message: fmt.Sprintf("Invalid %s parameter: %s", name, param)}
+	}
+	return value, nil
+}
+
+// Search returns the records of a table matching a full-text query, best
+// matches first, with their bm25 score, highlighted columns and a snippet
+func Search(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared reader pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, false)
+		if !ok {
+			return
+		}
+		defer release()
+
+		index, ok := resolveSearchIndex(w, db, params)
+		if !ok {
+			return
+		}
+		table := index.Table
+
+		query := r.URL.Query()
+		match := query.Get("q")
+		if strings.TrimSpace(match) == "" {
+			sendJSONError(w, "Missing q parameter", http.StatusBadRequest)
+			return
+		}
+
+		limit, err := searchIntParam(r, "limit", defaultSearchLimit)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		offset, err := searchIntParam(r, "offset", 0)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		tokens, err := searchIntParam(r, "snippet_tokens", defaultSnippetTokens)
+		if err == nil && (tokens < 1 || tokens > maxSnippetTokens) {
+			err = &requestError{message: fmt.Sprintf("Invalid snippet_tokens parameter, expected 1 to %d: %d", maxSnippetTokens, tokens)}
+		}
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Restrict the query to some of the indexed columns with a column
+		// filter, as in {title body} : (query)
+		if param := query.Get("columns"); param != "" {
+			var scoped []string
+			for _, name := range strings.Split(param, ",") {
+				column, ok := matchIdent(index.Columns, strings.TrimSpace(name))
+				if !ok {
+					sendResolveError(w, &identifierError{kind: "search column", name: name, valid: index.Columns})
+					return
+				}
+				scoped = append(scoped, quoteIdent(column))
+			}
+			match = fmt.Sprintf("{%s} : (%s)", strings.Join(scoped, " "), match)
+		}
+
+		startMark, endMark := "<b>", "</b>"
+		if query.Has("start_mark") {
+			startMark = query.Get("start_mark")
+		}
+		if query.Has("end_mark") {
+			endMark = query.Get("end_mark")
+		}
+
+		// Record columns come first, followed by the hidden score, one
+		// highlight per indexed column and the snippet
+		selected := make([]string, 0, len(table.Columns)+len(index.Columns)+2)
+		for _, column := range table.Columns {
+			selected = append(selected, fmt.Sprintf("t.%s", quoteIdent(column.Name)))
+		}
+		selected = append(selected, fmt.Sprintf("bm25(%s)", index.Quoted()))
+		args := []interface{}{}
+		for i := range index.Columns {
+			selected = append(selected, fmt.Sprintf("highlight(%s, %d, ?, ?)", index.Quoted(), i))
+			args = append(args, startMark, endMark)
+		}
+		selected = append(selected, fmt.Sprintf("snippet(%s, -1, ?, ?, '…', ?)", index.Quoted()))
+		args = append(args, startMark, endMark, tokens, match, limit, offset)
+
+		statement := fmt.Sprintf("SELECT %s FROM %s JOIN %s AS t ON t.rowid = %s.rowid WHERE %s MATCH ? ORDER BY bm25(%s) LIMIT ? OFFSET ?",
+			strings.Join(selected, ", "), index.Quoted(), table.Quoted(), index.Quoted(), index.Quoted(), index.Quoted())
+		rows, err := db.Query(statement, args...)
+		if err != nil {
+			sendSearchError(w, err)
+			return
+		}
+		defer rows.Close()
+
+		results, extras, err := scanAll(rows, len(index.Columns)+2)
+		if err != nil {
+			sendSearchError(w, err)
+			return
+		}
+
+		data := make([]map[string]interface{}, len(results))
+		for i, record := range results {
+			highlights := make(map[string]interface{}, len(index.Columns))
+			for j, column := range index.Columns {
+				highlights[column] = extras[i][j+1]
+			}
+			data[i] = map[string]interface{}{
+				"record":    record,
+				"score":     extras[i][0],
+				"highlight": highlights,
+				"snippet":   extras[i][len(index.Columns)+1],
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     "success",
+			"data":       data,
+			"total_rows": len(data),
+			"limit":      limit,
+			"offset":     offset,
+		})
+	}
+}
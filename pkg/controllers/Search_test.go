@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/middleware"
+)
+
+func TestSearch(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`CREATE TABLE articles (id INTEGER PRIMARY KEY, title TEXT, body TEXT, draft BOOLEAN);
+		INSERT INTO articles (title, body, draft) VALUES
+			('Cats at home', 'How cats choose the warmest spot of the house', 0),
+			('Dogs outside', 'Dogs and a cat sharing the garden', 1);
+		CREATE VIEW drafts AS SELECT * FROM articles WHERE draft`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := middleware.NewCustomRouter()
+	router.POST("/__/tables/:table/search-index", CreateSearchIndex(pool))
+	router.GET("/__/tables/:table/search-index", GetSearchIndex(pool))
+	router.DELETE("/__/tables/:table/search-index", DropSearchIndex(pool))
+	router.POST("/__/tables/:table/search-index/rebuild", RebuildSearchIndex(pool))
+	router.POST("/__/tables/:table/search-index/optimize", OptimizeSearchIndex(pool))
+	router.GET("/__/search/:table", Search(pool))
+	router.POST("/:table", Create(pool))
+	router.PATCH("/:table/:id", Update(pool))
+	router.DELETE("/:table/:id", Delete(pool))
+
+	send := func(method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	rr, _ := send("POST", "/__/tables/articles/search-index", `{"columns": ["title", "body"], "tokenize": "porter"}`)
+	if rr.Code == http.StatusNotImplemented {
+		t.Skip("SQLite was built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/__/tables/articles/search-index" {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Invalid indexes are rejected
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/__/tables/articles/search-index", `{"columns": ["title"]}`, http.StatusConflict},
+		{"/__/tables/drafts/search-index", `{"columns": ["title"]}`, http.StatusBadRequest},
+		{"/__/tables/dogs/search-index", `{"columns": ["title"]}`, http.StatusBadRequest},
+		{"/__/tables/articles/search-index", `{"columns": []}`, http.StatusBadRequest},
+		{"/__/tables/articles/search-index", `{"columns": ["author"]}`, http.StatusBadRequest},
+		{"/__/tables/articles/search-index", `{"columns": ["title"], "tokenize": "porter'); DROP TABLE articles; --"}`, http.StatusBadRequest},
+	} {
+		if rr, _ := send("POST", tc.path, tc.body); rr.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tc.path, tc.body, tc.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr, response := send("GET", "/__/tables/articles/search-index", "")
+	if rr.Code != http.StatusOK || response["index"] != "articles_fts" || response["tokenize"] != "porter" || len(response["columns"].([]interface{})) != 2 {
+		t.Errorf("Expected the index description, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	search := func(query string) []interface{} {
+		t.Helper()
+		rr, response := send("GET", "/__/search/articles?"+query, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		return response["data"].([]interface{})
+	}
+
+	// Existing rows are indexed and matches come best first, the porter
+	// tokenizer matching cat and cats alike
+	data := search("q=cats")
+	if len(data) != 2 {
+		t.Fatalf("Expected 2 matches, got %v", data)
+	}
+	first := data[0].(map[string]interface{})
+	record := first["record"].(map[string]interface{})
+	if record["title"] != "Cats at home" || record["draft"] != false {
+		t.Errorf("Expected the decoded best match first, got %v", record)
+	}
+	if first["score"].(float64) >= data[1].(map[string]interface{})["score"].(float64) {
+		t.Errorf("Expected ascending bm25 scores, got %v", data)
+	}
+	if first["highlight"].(map[string]interface{})["title"] != "<b>Cats</b> at home" {
+		t.Errorf("Expected a highlighted title, got %v", first["highlight"])
+	}
+	if !strings.Contains(first["snippet"].(string), "<b>") {
+		t.Errorf("Expected a snippet with marks, got %v", first["snippet"])
+	}
+
+	// Prefix, phrase and column-scoped queries
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"q=gard*", 1},
+		{"q=%22warmest+spot%22", 1},
+		{"q=%22spot+warmest%22", 0},
+		{"q=title:dogs", 1},
+		{"q=cat&columns=title", 1},
+		{"q=cat&limit=1", 1},
+		{"q=cat&limit=1&offset=1", 1},
+	} {
+		if data := search(tc.query); len(data) != tc.want {
+			t.Errorf("%s: expected %d matches, got %v", tc.query, tc.want, data)
+		}
+	}
+
+	data = search("q=garden&start_mark=%5B&end_mark=%5D&snippet_tokens=3")
+	if snippet := data[0].(map[string]interface{})["snippet"]; snippet != "…sharing the [garden]" {
+		t.Errorf("Expected a short custom snippet, got %q", snippet)
+	}
+
+	// Triggers keep the index in sync with writes
+	if rr, _ := send("POST", "/articles", `{"title": "Birds", "body": "A sparrow in the garden"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("PATCH", "/articles/2", `{"body": "Dogs alone"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("DELETE", "/articles/1", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if data := search("q=sparrow"); len(data) != 1 {
+		t.Errorf("Expected the inserted row to be indexed, got %v", data)
+	}
+	if data := search("q=garden"); len(data) != 1 {
+		t.Errorf("Expected the updated row to be reindexed, got %v", data)
+	}
+	if data := search("q=warmest"); len(data) != 0 {
+		t.Errorf("Expected the deleted row to be removed, got %v", data)
+	}
+
+	for _, command := range []string{"rebuild", "optimize"} {
+		if rr, _ := send("POST", "/__/tables/articles/search-index/"+command, ""); rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", command, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Invalid searches are client errors
+	for _, query := range []string{"", "q=%22unbalanced", "q=author:cat", "q=cat&columns=author", "q=cat&limit=-1", "q=cat&snippet_tokens=100"} {
+		if rr, _ := send("GET", "/__/search/articles?"+query, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr, _ := send("DELETE", "/__/tables/articles/search-index", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("GET", "/__/search/articles?q=cat", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without an index, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// Writes still work once the triggers are gone
+	if rr, _ := send("POST", "/articles", `{"title": "Fish"}`); rr.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
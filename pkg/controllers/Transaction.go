@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+type SavepointBody struct {
+	Name string `json:"name"`
+}
+
+// sendTransaction sends the state of an open interactive transaction
+func sendTransaction(w http.ResponseWriter, session *db.Session, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "success",
+		"id":         session.ID,
+		"expires_at": session.Deadline().UTC().Format(time.RFC3339),
+	})
+}
+
+// sendSavepointError sends the error of a savepoint statement
+func sendSavepointError(w http.ResponseWriter, name string, err error) {
+	if strings.Contains(err.Error(), "no such savepoint") {
+		sendJSONError(w, fmt.Sprintf("Savepoint %s not found", name), http.StatusNotFound)
+		return
+	}
+	sendJSONError(w, writeErrorMessage(err), writeErrorStatus(err))
+}
+
+func BeginTransaction(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		session, err := pool.BeginSession()
+		if errors.Is(err, db.ErrSessionLimit) {
+			sendJSONError(w, "Too many open transactions", http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error starting transaction: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Location", "/__/tx/"+session.ID)
+		sendTransaction(w, session, http.StatusCreated)
+	}
+}
+
+func GetTransaction(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id := params.ByName("id")
+		session, err := pool.AcquireSession(id)
+		if err != nil {
+			sendSessionError(w, id, err)
+			return
+		}
+
+		// Reading the state keeps the transaction alive
+		session.Release()
+		sendTransaction(w, session, http.StatusOK)
+	}
+}
+
+func CommitTransaction(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id := params.ByName("id")
+		session, err := pool.AcquireSession(id)
+		if err != nil {
+			sendSessionError(w, id, err)
+			return
+		}
+		defer session.Release()
+
+		// The transaction ends even when the commit fails
+		if err := session.Commit(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error committing transaction %s: %s", id, writeErrorMessage(err)), writeErrorStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"id":     id,
+		})
+	}
+}
+
+func RollbackTransaction(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id := params.ByName("id")
+		session, err := pool.AcquireSession(id)
+		if err != nil {
+			sendSessionError(w, id, err)
+			return
+		}
+		defer session.Release()
+
+		if err := session.Rollback(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Error rolling back transaction %s: %s", id, err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"id":     id,
+		})
+	}
+}
+
+func CreateSavepoint(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Parse body data
+		body := SavepointBody{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(body.Name) == "" {
+			sendJSONError(w, "Missing name in request body", http.StatusBadRequest)
+			return
+		}
+
+		id := params.ByName("id")
+		session, err := pool.AcquireSession(id)
+		if err != nil {
+			sendSessionError(w, id, err)
+			return
+		}
+		defer session.Release()
+
+		if _, err := session.Tx.Exec("SAVEPOINT " + quoteIdent(body.Name)); err != nil {
+			sendSavepointError(w, body.Name, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "success",
+			"id":        id,
+			"savepoint": body.Name,
+		})
+	}
+}
+
+func ReleaseSavepoint(pool *db.Pool) httprouter.Handle {
+	return savepointAction(pool, "RELEASE %s")
+}
+
+func RollbackToSavepoint(pool *db.Pool) httprouter.Handle {
+	return savepointAction(pool, "ROLLBACK TO %s")
+}
+
+// savepointAction returns a handler running a statement on the savepoint
+// named in the path
+func savepointAction(pool *db.Pool, statement string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		id := params.ByName("id")
+		name := params.ByName("name")
+		session, err := pool.AcquireSession(id)
+		if err != nil {
+			sendSessionError(w, id, err)
+			return
+		}
+		defer session.Release()
+
+		if _, err := session.Tx.Exec(fmt.Sprintf(statement, quoteIdent(name))); err != nil {
+			sendSavepointError(w, name, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "success",
+			"id":        id,
+			"savepoint": name,
+		})
+	}
+}
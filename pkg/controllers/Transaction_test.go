@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+	"github.com/paradoxe35/sqlite-rest/pkg/middleware"
+)
+
+func TestTransactions(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)")
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := middleware.NewCustomRouter()
+	router.POST("/__/tx", BeginTransaction(pool))
+	router.GET("/__/tx/:id", GetTransaction(pool))
+	router.POST("/__/tx/:id/commit", CommitTransaction(pool))
+	router.POST("/__/tx/:id/rollback", RollbackTransaction(pool))
+	router.POST("/__/tx/:id/savepoints", CreateSavepoint(pool))
+	router.POST("/__/tx/:id/savepoints/:name/rollback", RollbackToSavepoint(pool))
+	router.OPTIONS("/__/exec", Exec(pool))
+	router.GET("/:table", GetAll(pool))
+	router.POST("/:table", Create(pool))
+
+	send := func(method, path, tx, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tx != "" {
+			req.Header.Set("X-Transaction-Id", tx)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	countCats := func(tx string) float64 {
+		_, response := send("GET", "/cats", tx, "")
+		return response["total_rows"].(float64)
+	}
+
+	rr, response := send("POST", "/__/tx", "", "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	id := response["id"].(string)
+	if rr.Header().Get("Location") != "/__/tx/"+id || response["expires_at"] == nil {
+		t.Errorf("Expected the transaction location and expiry, got %s", rr.Body.String())
+	}
+
+	// Writes in the transaction are only visible inside it
+	if rr, _ := send("POST", "/cats", id, `{"name": "Tequila"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("OPTIONS", "/__/exec", id, `{"query": "INSERT INTO cats (name) VALUES ('Mezcal')"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := countCats(id); n != 2 {
+		t.Errorf("Expected 2 cats inside the transaction, got %v", n)
+	}
+	if n := countCats(""); n != 0 {
+		t.Errorf("Expected no cats outside the transaction, got %v", n)
+	}
+
+	// Reads in the transaction cannot write through raw filters
+	rr, _ = send("GET", "/cats?filters_raw=1)%3B%20CREATE%20TABLE%20stolen%20AS%20SELECT%20*%20FROM%20cats%20WHERE%20(1", id, "")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a writing read, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("GET", "/cats?filters_raw=name%20%3D%20'Tequila'", id, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected raw filters to read in the transaction, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Savepoints undo part of the transaction
+	if rr, _ := send("POST", "/__/tx/"+id+"/savepoints", "", `{"name": "before_pisco"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	send("POST", "/cats", id, `{"name": "Pisco"}`)
+	if rr, _ := send("POST", "/__/tx/"+id+"/savepoints/before_pisco/rollback", "", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("POST", "/__/tx/"+id+"/savepoints/unknown/rollback", "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown savepoint, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Transaction statements would break the session
+	if rr, _ := send("OPTIONS", "/__/exec", id, `{"query": "COMMIT"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for COMMIT, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A failed request only undoes its own changes
+	if rr, _ := send("POST", "/cats", id, `[{"name": "Rum"}, {"id": 1, "name": "Tequila"}]`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a duplicate key, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr, _ := send("POST", "/__/tx/"+id+"/commit", "", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := countCats(""); n != 2 {
+		t.Errorf("Expected 2 committed cats, got %v", n)
+	}
+
+	// Ended transactions are gone
+	if rr, _ := send("GET", "/cats", id, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an ended transaction, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := send("GET", "/__/tx/"+id, "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an ended transaction, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Rolled back transactions leave no changes
+	_, response = send("POST", "/__/tx", "", "")
+	id = response["id"].(string)
+	send("POST", "/cats", id, `{"name": "Pisco"}`)
+	if rr, _ := send("POST", "/__/tx/"+id+"/rollback", "", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := countCats(""); n != 2 {
+		t.Errorf("Expected the rollback to leave 2 cats, got %v", n)
+	}
+}
+
+func TestTransactionWriteLock(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)")
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	config := db.DefaultPoolConfig()
+	config.Pragmas.BusyTimeout = 50
+	pool, err := db.NewPool(tmpFile.Name(), config)
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	defer pool.Close()
+
+	router := middleware.NewCustomRouter()
+	router.POST("/__/tx", BeginTransaction(pool))
+	router.POST("/__/tx/:id/commit", CommitTransaction(pool))
+	router.GET("/:table", GetAll(pool))
+	router.POST("/:table", Create(pool))
+
+	send := func(method, path, tx, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tx != "" {
+			req.Header.Set("X-Transaction-Id", tx)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	begin := func() string {
+		var response map[string]interface{}
+		json.Unmarshal(send("POST", "/__/tx", "", "").Body.Bytes(), &response)
+		return response["id"].(string)
+	}
+
+	// Transactions that only read never take the write lock
+	reading := begin()
+	if rr := send("GET", "/cats", reading, ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("POST", "/cats", "", `{"name": "Tequila"}`); rr.Code != http.StatusCreated {
+		t.Errorf("Expected a write beside a reading transaction, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Once a transaction wrote, other writes time out as unavailable
+	writing := begin()
+	if rr := send("POST", "/cats", writing, `{"name": "Mezcal"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := send("POST", "/cats", "", `{"name": "Pisco"}`)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected status 503 with Retry-After while the lock is held, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Writes go through again once the transaction ends
+	if rr := send("POST", "/__/tx/"+writing+"/commit", "", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("POST", "/cats", "", `{"name": "Pisco"}`); rr.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 after the commit, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -4,22 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/paradoxe35/sqlite-rest/pkg/db"
 )
 
-func Update(dbPath string) httprouter.Handle {
+func Update(pool *db.Pool) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// Create sql.DB instance
-		db, err := db.Open(dbPath)
-		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
 			return
 		}
-		defer db.Close()
+		defer release()
 
 		// Parse table name from params
 		tableSelect := params.ByName("table")
@@ -28,21 +26,36 @@ func Update(dbPath string) httprouter.Handle {
 			return
 		}
 
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
 		// Parse id from params
 		idParam := params.ByName("id")
 		if idParam == "" {
 			sendJSONError(w, "Missing ID parameter", http.StatusBadRequest)
 			return
 		}
-		id, err := strconv.ParseInt(idParam, 10, 64)
+		key, err := table.ParseKey(keyParam(r, idParam))
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		keyWhere, keyArgs := key.Where()
+
+		// Read what is returned of the updated record
+		repr, err := table.Representation(r)
 		if err != nil {
-			sendJSONError(w, fmt.Sprintf("Invalid ID format: %s", err.Error()), http.StatusBadRequest)
+			sendResolveError(w, err)
 			return
 		}
 
 		// Parse body data
 		data := make(map[string]interface{})
-		err = json.NewDecoder(r.Body).Decode(&data)
+		err = decodeJSON(r.Body, &data)
 		if err != nil {
 			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
 			return
@@ -53,33 +66,34 @@ func Update(dbPath string) httprouter.Handle {
 			return
 		}
 
-		// Extract keys and values from data
-		var columnValuesString string
-		for k, v := range data {
+		// Extract and validate columns and bound values from data
+		columnNames, columnValues, err := bindInsert(table, data)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		setClauses := table.setClauses(columnNames)
 
-			if v == nil {
-				columnValuesString += fmt.Sprintf("%s=NULL,", k)
-				continue
-			}
+		tx, err := db.Begin()
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error updating record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
 
-			switch v.(type) {
-			case string:
-				columnValuesString += fmt.Sprintf("%s=\"%s\",", k, v)
-			case int:
-				columnValuesString += fmt.Sprintf("%s=%d,", k, v)
-			case float64:
-				columnValuesString += fmt.Sprintf("%s=%f,", k, v)
-			case bool:
-				columnValuesString += fmt.Sprintf("%s=%t,", k, v)
-			default:
-				columnValuesString += fmt.Sprintf("%s=%v,", k, v)
-			}
+		// Check the record is still the one the client read
+		matched, err := table.checkIfMatch(tx, r, key)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error updating record: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if !matched {
+			sendJSONError(w, fmt.Sprintf("Record with key %s does not match If-Match", key), http.StatusPreconditionFailed)
+			return
 		}
-		// Remove last comma
-		columnValuesString = columnValuesString[:len(columnValuesString)-1]
 
 		// Execute query
-		result, err := db.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE id = %d", tableSelect, columnValuesString, id))
+		rowsAffected, rows, err := table.execReturning(tx, fmt.Sprintf("UPDATE %s SET %s WHERE %s", table.Quoted(), strings.Join(setClauses, ", "), keyWhere), append(columnValues, keyArgs...), repr)
 		if err != nil {
 			// Check if this is a syntax error (client error) or a server error
 			errMsg := err.Error()
@@ -96,18 +110,34 @@ func Update(dbPath string) httprouter.Handle {
 		}
 
 		// Check if any rows were affected
-		rowsAffected, _ := result.RowsAffected()
 		if rowsAffected == 0 {
-			sendJSONError(w, fmt.Sprintf("Record with ID %d not found", id), http.StatusNotFound)
+			sendJSONError(w, fmt.Sprintf("Record with key %s not found", key), http.StatusNotFound)
+			return
+		}
+
+		// Read the new ETag of the record
+		etag, err := table.rowETag(tx, key)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error updating record: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
 
 		// Return success response
+		response := map[string]interface{}{
+			"status": "success",
+			"id":     key.Value(),
+		}
+		if repr != nil {
+			response["data"] = rows[0]
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "success",
-			"id":     id,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
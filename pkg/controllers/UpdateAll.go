@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+func UpdateAll(pool *db.Pool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// Use the shared writer pool, or the transaction named by X-Transaction-Id
+		db, release, ok := openDatabase(w, r, pool, true)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// Parse table name from params
+		tableSelect := params.ByName("table")
+		if tableSelect == "" {
+			sendJSONError(w, "Missing table parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Validate table name against the schema
+		table, err := resolveTable(db, tableSelect)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Build the filtered scope of the update
+		scope, err := parseFilteredScope(r.URL.Query(), table)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Read what is returned of the updated records
+		repr, err := table.Representation(r)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+
+		// Parse body data
+		data := make(map[string]interface{})
+		err = decodeJSON(r.Body, &data)
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid request body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if len(data) == 0 {
+			sendJSONError(w, "Missing data in request body", http.StatusBadRequest)
+			return
+		}
+
+		// Extract and validate columns and bound values from data
+		columnNames, columnValues, err := bindInsert(table, data)
+		if err != nil {
+			sendResolveError(w, err)
+			return
+		}
+		setClauses := table.setClauses(columnNames)
+
+		// Execute query
+		affected, rows, err := scope.exec(db, table, fmt.Sprintf("UPDATE %s SET %s", table.Quoted(), strings.Join(setClauses, ", ")), columnValues, repr)
+		if err != nil {
+			// Check if this is a client error or a server error
+			errMsg := err.Error()
+			if _, ok := err.(*requestError); ok {
+				sendResolveError(w, err)
+			} else if strings.Contains(errMsg, "constraint failed") || strings.Contains(errMsg, "UNIQUE constraint") {
+				sendJSONError(w, fmt.Sprintf("Constraint violation: %s", errMsg), http.StatusBadRequest)
+			} else {
+				sendJSONError(w, fmt.Sprintf("Error updating records: %s", errMsg), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Return success response
+		response := map[string]interface{}{
+			"status":   "success",
+			"affected": affected,
+		}
+		if repr != nil {
+			response["data"] = rows
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selection is a parsed select parameter
+type selection struct {
+	Columns    []string
+	Embeds     []*embedSelect
+	Aggregates []aggregateSelect
+}
+
+// aggregateFunctions lists the aggregate functions accepted in select.
+// Function names are part of the SQL text, so anything else is rejected.
+var aggregateFunctions = map[string]bool{
+	"count":        true,
+	"sum":          true,
+	"avg":          true,
+	"min":          true,
+	"max":          true,
+	"group_concat": true,
+	"total":        true,
+}
+
+// aggregateSelect is an aggregate written fn(column) or alias:fn(column) in
+// select. Column is empty for count().
+type aggregateSelect struct {
+	Name     string
+	Function string
+	Column   string
+}
+
+func newAggregate(alias, function, column string) aggregateSelect {
+	if column == "*" {
+		column = ""
+	}
+	return aggregateSelect{Name: alias, Function: function, Column: column}
+}
+
+// Expr returns the aggregate SQL expression
+func (a aggregateSelect) Expr() string {
+	if a.Column == "" {
+		return a.Function + "(*)"
+	}
+	return fmt.Sprintf("%s(%s)", a.Function, quoteIdent(a.Column))
+}
+
+// aggregation is a validated grouped query: the grouped columns that are
+// selected, the aggregates and the GROUP BY columns
+type aggregation struct {
+	columns    []string
+	aggregates []aggregateSelect
+	groupBy    []string
+}
+
+// Aggregation validates the aggregates of a selection and the group_by
+// parameter. It returns nil when the query is not grouped. Aggregates are
+// named count for count() and fn_column otherwise unless given an alias.
+func (t *tableSchema) Aggregation(sel *selection, groupBy string) (*aggregation, error) {
+	if len(sel.Aggregates) == 0 && strings.TrimSpace(groupBy) == "" {
+		return nil, nil
+	}
+	if len(sel.Embeds) > 0 {
+		return nil, &requestError{message: "Cannot embed resources in an aggregated select"}
+	}
+
+	agg := &aggregation{}
+	if strings.TrimSpace(groupBy) != "" {
+		for _, name := range strings.Split(groupBy, ",") {
+			column, err := t.Column(name)
+			if err != nil {
+				return nil, err
+			}
+			agg.groupBy = append(agg.groupBy, column)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, name := range sel.Columns {
+		if strings.TrimSpace(name) == "*" {
+			return nil, &requestError{message: "Cannot select * in an aggregated select, name the grouped columns"}
+		}
+		column, err := t.Column(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, grouped := matchIdent(agg.groupBy, column); !grouped {
+			return nil, &requestError{message: fmt.Sprintf("Column %s must be in group_by to be selected with aggregates", column)}
+		}
+		agg.columns = append(agg.columns, column)
+		names[strings.ToLower(column)] = true
+	}
+
+	for _, a := range sel.Aggregates {
+		if a.Column != "" {
+			column, err := t.Column(a.Column)
+			if err != nil {
+				return nil, err
+			}
+			a.Column = column
+		} else if a.Function != "count" {
+			return nil, &requestError{message: fmt.Sprintf("Aggregate %s requires a column, e.g. %s(column)", a.Function, a.Function)}
+		}
+
+		if a.Name == "" {
+			a.Name = a.Function
+			if a.Column != "" {
+				a.Name += "_" + a.Column
+			}
+		}
+		if names[strings.ToLower(a.Name)] {
+			return nil, &requestError{message: fmt.Sprintf("Duplicate name %s in select, use an alias such as other:%s(...)", a.Name, a.Function)}
+		}
+		names[strings.ToLower(a.Name)] = true
+		agg.aggregates = append(agg.aggregates, a)
+	}
+
+	return agg, nil
+}
+
+// Select returns the select list of the grouped columns and aggregates
+func (a *aggregation) Select() string {
+	var selected []string
+	for _, column := range a.columns {
+		selected = append(selected, quoteIdent(column))
+	}
+	for _, aggregate := range a.aggregates {
+		selected = append(selected, fmt.Sprintf("%s AS %s", aggregate.Expr(), quoteIdent(aggregate.Name)))
+	}
+	return strings.Join(selected, ", ")
+}
+
+// GroupBy returns the GROUP BY clause
+func (a *aggregation) GroupBy() string {
+	if len(a.groupBy) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(a.groupBy))
+	for i, column := range a.groupBy {
+		quoted[i] = quoteIdent(column)
+	}
+	return "GROUP BY " + strings.Join(quoted, ", ")
+}
+
+// name resolves an aggregate name or grouped column as found in the results
+func (a *aggregation) name(name string) (string, error) {
+	var names []string
+	for _, aggregate := range a.aggregates {
+		names = append(names, aggregate.Name)
+	}
+	names = append(names, a.groupBy...)
+
+	canonical, ok := matchIdent(names, strings.TrimSpace(name))
+	if !ok {
+		return "", &identifierError{kind: "aggregate or grouped column", name: name, valid: names}
+	}
+	return canonical, nil
+}
+
+// expr resolves a name in the having parameter to the SQL it stands for
+func (a *aggregation) expr(name string) (string, error) {
+	canonical, err := a.name(name)
+	if err != nil {
+		return "", err
+	}
+	for _, aggregate := range a.aggregates {
+		if aggregate.Name == canonical {
+			return aggregate.Expr(), nil
+		}
+	}
+	return quoteIdent(canonical), nil
+}
+
+// OrderTerms validates order_by against the aggregate names and grouped
+// columns
+func (a *aggregation) OrderTerms(orderBy, orderDir string) ([]orderTerm, error) {
+	return parseOrderTerms(orderBy, orderDir, a.name)
+}
+
+// Having compiles the having parameters with the column filter grammar on
+// aggregate names and grouped columns, e.g. count.gt.10 or
+// or(sum_total.gte.100,count.gt.10). Comma separated conditions and repeated
+// parameters are joined with AND.
+func (a *aggregation) Having(values []string) (string, []interface{}, error) {
+	// Aggregates have no affinity to convert text values, so numbers are
+	// bound as numbers
+	compiler := &filterCompiler{column: a.expr}
+
+	var conditions []string
+	for _, value := range values {
+		condition, err := compiler.group("and", "("+value+")")
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+
+	return "HAVING " + strings.Join(conditions, " AND "), compiler.args, nil
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGetAllAggregation(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, total REAL, region TEXT);
+		INSERT INTO orders (status, total, region) VALUES
+			('paid', 10, 'eu'), ('paid', 20, 'eu'), ('paid', 30, 'us'),
+			('pending', 5, 'eu'), ('pending', 7.5, 'us'),
+			('refunded', 100, 'us');
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, tmpFile.Name())))
+
+	get := func(query url.Values) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/orders?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response.Data
+	}
+
+	rr, data := get(url.Values{
+		"select":   {"status,count(),sum(total),avg(total),max(total)"},
+		"group_by": {"status"},
+		"order_by": {"status"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(data) != 3 {
+		t.Fatalf("Expected 3 groups, got %v", data)
+	}
+	paid := data[0]
+	if paid["status"] != "paid" || paid["count"] != float64(3) || paid["sum_total"] != float64(60) || paid["avg_total"] != float64(20) || paid["max_total"] != float64(30) {
+		t.Errorf("Unexpected paid group: %v", paid)
+	}
+	if len(paid) != 5 {
+		t.Errorf("Expected only the selected columns and aggregates, got %v", paid)
+	}
+
+	// Aggregates are typed, not strings
+	var raw struct {
+		Data []map[string]json.RawMessage `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &raw)
+	if string(raw.Data[0]["count"]) != "3" {
+		t.Errorf("Expected count to be a number, got %s", raw.Data[0]["count"])
+	}
+
+	// Having, aliases, filters and ordering by aggregate
+	_, data = get(url.Values{
+		"select":    {"region,orders:count(),revenue:total(total)"},
+		"group_by":  {"region"},
+		"having":    {"orders.gte.2"},
+		"status":    {"neq.refunded"},
+		"order_by":  {"revenue"},
+		"order_dir": {"desc"},
+	})
+	if len(data) != 2 || data[0]["region"] != "us" || data[0]["revenue"] != float64(37.5) || data[1]["orders"] != float64(3) {
+		t.Errorf("Unexpected regions: %v", data)
+	}
+
+	_, data = get(url.Values{
+		"select":   {"status,count()"},
+		"group_by": {"status"},
+		"having":   {"or(count.gt.2,status.eq.refunded)"},
+		"order_by": {"status"},
+	})
+	if len(data) != 2 || data[0]["status"] != "paid" || data[1]["status"] != "refunded" {
+		t.Errorf("Unexpected groups for or having: %v", data)
+	}
+
+	// Aggregates without group_by cover the whole table
+	_, data = get(url.Values{"select": {"count(),min(total),group_concat(region)"}, "region": {"eq.eu"}})
+	if len(data) != 1 || data[0]["count"] != float64(3) || data[0]["min_total"] != float64(5) || data[0]["group_concat_region"] != "eu,eu,eu" {
+		t.Errorf("Unexpected totals: %v", data)
+	}
+
+	invalid := []url.Values{
+		{"select": {"status,median(total)"}, "group_by": {"status"}},
+		{"select": {"status,sum(nope)"}, "group_by": {"status"}},
+		{"select": {"status,sum()"}, "group_by": {"status"}},
+		{"select": {"status,count()"}},
+		{"select": {"*,count()"}, "group_by": {"status"}},
+		{"select": {"status,count()"}, "group_by": {"nope"}},
+		{"select": {"status,count()"}, "group_by": {"status"}, "having": {"nope.gt.1"}},
+		{"select": {"status,count()"}, "group_by": {"status"}, "order_by": {"total"}},
+		{"select": {"status,count(),count:sum(total)"}, "group_by": {"status"}},
+		{"select": {"status,count()"}, "group_by": {"status"}, "count": {"exact"}},
+		{"group_by": {"status"}},
+		{"having": {"count.gt.1"}},
+	}
+	for _, query := range invalid {
+		rr, _ := get(query)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query.Encode(), rr.Code, http.StatusBadRequest)
+		}
+	}
+}
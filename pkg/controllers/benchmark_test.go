@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// setupBenchmarkDB creates a database with a few hundred rows to read
+func setupBenchmarkDB(b *testing.B) string {
+	tmpFile, err := os.CreateTemp("", "bench-db-*.sqlite")
+	if err != nil {
+		b.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpFile.Close()
+	b.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	defer conn.Close()
+	conn.Exec("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, paw INTEGER)")
+	for i := 0; i < 500; i++ {
+		conn.Exec("INSERT INTO cats (name, paw) VALUES (?, ?)", fmt.Sprintf("cat %d", i), i%5)
+	}
+
+	return tmpFile.Name()
+}
+
+// perRequestPool mimics the previous behaviour of opening the database for
+// every request and closing it afterwards
+func perRequestPool(dbPath string, handler func(*db.Pool) httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		pool, err := db.NewPool(dbPath, db.DefaultPoolConfig())
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer pool.Close()
+		handler(pool)(w, r, params)
+	}
+}
+
+func benchmarkHandler(b *testing.B, path, route string, handler func(*db.Pool) httprouter.Handle) {
+	dbPath := setupBenchmarkDB(b)
+
+	handlers := map[string]httprouter.Handle{
+		"pool":             handler(openTestPool(b, dbPath)),
+		"open_per_request": perRequestPool(dbPath, handler),
+	}
+
+	for _, name := range []string{"pool", "open_per_request"} {
+		router := httprouter.New()
+		router.GET(route, handlers[name])
+
+		b.Run(name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req, _ := http.NewRequest("GET", path, nil)
+					rr := httptest.NewRecorder()
+					router.ServeHTTP(rr, req)
+					if rr.Code != http.StatusOK {
+						b.Errorf("Handler returned %d: %s", rr.Code, rr.Body.String())
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetAll(b *testing.B) {
+	benchmarkHandler(b, "/cats?limit=25&order_by=name", "/:table", GetAll)
+}
+
+func BenchmarkGet(b *testing.B) {
+	benchmarkHandler(b, "/cats/42", "/:table/:id", Get)
+}
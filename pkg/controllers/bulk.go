@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Modes accepted by the on_error parameter of bulk inserts
+const (
+	onErrorAbort    = "abort"
+	onErrorContinue = "continue"
+)
+
+// rowSource yields the rows of a request body one at a time and io.EOF
+// after the last one
+type rowSource func() (map[string]interface{}, error)
+
+// rowError is a row of a bulk payload that could not be written
+type rowError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+	status  int
+}
+
+// isNDJSON reports whether the request body is newline delimited JSON
+func isNDJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-ndjson" || mediaType == "application/jsonl"
+}
+
+// openRowSource reads the rows of a request body, which is a JSON object, a
+// JSON array of objects or NDJSON. bulk is false for a single object so the
+// response can keep its single row shape.
+func openRowSource(r *http.Request) (rowSource, bool, error) {
+	reader := bufio.NewReader(r.Body)
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+
+	if isNDJSON(r) {
+		return func() (map[string]interface{}, error) {
+			row := make(map[string]interface{})
+			if err := decoder.Decode(&row); err != nil {
+				return nil, err
+			}
+			return row, nil
+		}, true, nil
+	}
+
+	// Peek at the first character to tell arrays from objects
+	var first byte
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return nil, false, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			first = b[0]
+			break
+		}
+		reader.ReadByte()
+	}
+
+	if first != '[' {
+		done := false
+		return func() (map[string]interface{}, error) {
+			if done {
+				return nil, io.EOF
+			}
+			done = true
+			row := make(map[string]interface{})
+			if err := decoder.Decode(&row); err != nil {
+				return nil, err
+			}
+			return row, nil
+		}, false, nil
+	}
+
+	// Consume the opening bracket and decode the elements one by one
+	if _, err := decoder.Token(); err != nil {
+		return nil, true, err
+	}
+	return func() (map[string]interface{}, error) {
+		if !decoder.More() {
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		row := make(map[string]interface{})
+		if err := decoder.Decode(&row); err != nil {
+			return nil, err
+		}
+		return row, nil
+	}, true, nil
+}
+
+// insertSQL builds the INSERT statement of a row with the given quoted
+// columns, resolving conflicts when conflict is set. Upserts and writes
+// returning a representation read the row back with RETURNING, since the
+// last insert rowid is not set when a conflict is resolved.
+func insertSQL(table *tableSchema, columns []string, conflict *conflictClause, repr *representation) string {
+	query := fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", table.Quoted())
+	if len(columns) > 0 {
+		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.Quoted(), strings.Join(columns, ", "), placeholders(len(columns)))
+		if conflict != nil {
+			query += " " + conflict.SQL(columns)
+		}
+	}
+	// Keys other than the rowid may be filled by defaults, so are read back
+	if conflict != nil || repr != nil || !table.HasRowidKey() {
+		query += " " + table.returning(repr)
+	}
+	return query
+}
+
+// bindInsert validates the columns of a row against the schema and returns
+// them quoted with their bound values. The version column cannot be set.
+func bindInsert(table *tableSchema, data map[string]interface{}) ([]string, []interface{}, error) {
+	columnNames, columnValues, err := bindRow(data)
+	if err != nil {
+		return nil, nil, &requestError{message: fmt.Sprintf("Invalid request body: %s", err.Error())}
+	}
+	for i, column := range columnNames {
+		columnNames[i], err = table.QuotedColumn(column)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := table.checkManaged(columnNames); err != nil {
+		return nil, nil, err
+	}
+	return columnNames, columnValues, nil
+}
+
+// bulkResult is the outcome of a bulk insert. Keys are in payload order,
+// nil for rows that failed or conflicting rows that were ignored. Rows holds
+// the representation of the written rows when one was asked for.
+type bulkResult struct {
+	Keys     []interface{}
+	Failures []rowError
+	Written  int
+	Rows     []map[string]interface{}
+}
+
+// insertOptions are the parts of an insert shared by every row
+type insertOptions struct {
+	conflict *conflictClause
+	repr     *representation
+}
+
+// bulkInsert inserts every row of next in one transaction. Rows with the
+// same columns share a prepared statement. In abort mode the first failing
+// row rolls everything back and its error is returned along with its index,
+// in continue mode rows failing with a client error are reported and the
+// others are kept.
+func bulkInsert(db database, table *tableSchema, next rowSource, onError string, opts insertOptions) (*bulkResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	statements := make(map[string]*sql.Stmt)
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
+		}
+	}()
+
+	result := &bulkResult{}
+	if opts.repr != nil {
+		result.Rows = []map[string]interface{}{}
+	}
+	for index := 0; ; index++ {
+		data, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A malformed payload cannot be resumed
+			return nil, &rowError{Index: index, Message: fmt.Sprintf("Invalid request body: %s", err.Error()), status: http.StatusBadRequest}
+		}
+
+		inserted, err := insertRow(tx, statements, table, data, opts)
+		if err != nil {
+			failure := rowError{Index: index, Message: writeErrorMessage(err), status: writeErrorStatus(err)}
+			if onError == onErrorAbort || failure.status != http.StatusBadRequest {
+				return nil, &failure
+			}
+			result.Failures = append(result.Failures, failure)
+			result.Keys = append(result.Keys, nil)
+			continue
+		}
+		if inserted.written {
+			result.Written++
+			if opts.repr != nil {
+				result.Rows = append(result.Rows, inserted.row)
+			}
+		}
+		result.Keys = append(result.Keys, inserted.key.Value())
+	}
+
+	if len(result.Keys) == 0 {
+		return nil, &requestError{message: "Missing data in request body"}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// insertRow inserts one row of a bulk payload with a cached statement
+func insertRow(tx transaction, statements map[string]*sql.Stmt, table *tableSchema, data map[string]interface{}, opts insertOptions) (*insertedRow, error) {
+	if len(data) == 0 {
+		return nil, &requestError{message: "Empty row"}
+	}
+
+	columns, values, err := bindInsert(table, data)
+	if err != nil {
+		return nil, err
+	}
+
+	query := insertSQL(table, columns, opts.conflict, opts.repr)
+	stmt, ok := statements[query]
+	if !ok {
+		stmt, err = tx.Prepare(query)
+		if err != nil {
+			return nil, err
+		}
+		statements[query] = stmt
+	}
+
+	return execInsert(stmt, table, data, values, opts)
+}
+
+// insertOne inserts a single row of bound columns and values
+func insertOne(db database, table *tableSchema, data map[string]interface{}, columns []string, values []interface{}, opts insertOptions) (*insertedRow, error) {
+	stmt, err := db.Prepare(insertSQL(table, columns, opts.conflict, opts.repr))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	return execInsert(stmt, table, data, values, opts)
+}
+
+// insertedRow is the outcome of inserting a row. written is false when a
+// conflicting row was ignored, its key is then taken from the payload and
+// is nil when the payload does not hold it. row is the representation of
+// the written row, when one was asked for.
+type insertedRow struct {
+	key     *recordKey
+	written bool
+	row     map[string]interface{}
+}
+
+// execInsert runs a prepared insert and returns the key of the row
+func execInsert(stmt *sql.Stmt, table *tableSchema, data map[string]interface{}, values []interface{}, opts insertOptions) (*insertedRow, error) {
+	if opts.conflict == nil && opts.repr == nil && table.HasRowidKey() {
+		res, err := stmt.Exec(values...)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		return &insertedRow{key: table.insertedKey(id), written: true}, nil
+	}
+
+	rows, err := stmt.Query(values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returned, keys, err := table.scanReturning(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return &insertedRow{key: table.keyFromData(data)}, nil
+	}
+	return &insertedRow{key: keys[0], written: true, row: returned[0]}, nil
+}
+
+// Error formats the failing row for error responses
+func (e *rowError) Error() string {
+	return fmt.Sprintf("Row %d: %s", e.Index, e.Message)
+}
+
+// writeErrorStatus tells client errors, such as unknown columns or
+// constraint violations, and lock timeouts from server errors
+func writeErrorStatus(err error) int {
+	var identErr *identifierError
+	var reqErr *requestError
+	if errors.As(err, &identErr) || errors.As(err, &reqErr) || strings.Contains(err.Error(), "constraint failed") || isConflictTargetError(err) {
+		return http.StatusBadRequest
+	}
+	if isLockedError(err.Error()) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeErrorMessage describes a failed write the way single row writes do
+func writeErrorMessage(err error) string {
+	errMsg := err.Error()
+	if strings.Contains(errMsg, "constraint failed") || strings.Contains(errMsg, "UNIQUE constraint") {
+		return fmt.Sprintf("Constraint violation: %s", errMsg)
+	}
+	return errMsg
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCreateBulk(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE, paw INTEGER);
+		CREATE TABLE skus (code TEXT PRIMARY KEY, label TEXT);
+		CREATE TABLE tokens (token TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(8)))), label TEXT);
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.POST("/:table", Create(pool))
+
+	post := func(path, contentType, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	count := func() int {
+		var n int
+		pool.Reader.QueryRow("SELECT COUNT(*) FROM cats").Scan(&n)
+		return n
+	}
+
+	// JSON array with different column sets
+	rr, response := post("/cats", "application/json", `[{"name": "Tequila", "paw": 4}, {"name": "Whisky"}, {"name": "Rhum", "paw": 3}]`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	ids := response["ids"].([]interface{})
+	if len(ids) != 3 || ids[0] != float64(1) || ids[2] != float64(3) || response["inserted"] != float64(3) {
+		t.Errorf("Unexpected response: %v", response)
+	}
+	if _, ok := response["errors"]; ok {
+		t.Errorf("Expected no errors in abort mode, got %v", response)
+	}
+
+	// NDJSON with text keys
+	rr, response = post("/skus", "application/x-ndjson", "{\"code\": \"A1\", \"label\": \"Apple\"}\n\n{\"code\": \"B2\"}\n")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ids := response["ids"].([]interface{}); len(ids) != 2 || ids[0] != "A1" || ids[1] != "B2" {
+		t.Errorf("Unexpected NDJSON keys: %v", response)
+	}
+
+	// A failing row rolls back the whole payload
+	rr, response = post("/cats", "application/json", `[{"name": "Gin"}, {"name": "Tequila"}]`)
+	if rr.Code != http.StatusBadRequest || !strings.HasPrefix(response["message"].(string), "Row 1: Constraint violation") {
+		t.Errorf("Expected a constraint violation on row 1, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if count() != 3 {
+		t.Errorf("Expected the payload to be rolled back, got %d rows", count())
+	}
+
+	// Continue mode keeps the valid rows
+	rr, response = post("/cats?on_error=continue", "application/json", `[{"name": "Gin"}, {"name": "Tequila"}, {"nope": 1}, {}, {"name": "Vodka"}]`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	ids = response["ids"].([]interface{})
+	if len(ids) != 5 || ids[0] == nil || ids[1] != nil || ids[2] != nil || ids[3] != nil || ids[4] == nil || response["inserted"] != float64(2) {
+		t.Errorf("Unexpected keys in continue mode: %v", response)
+	}
+	if errs := response["errors"].([]interface{}); len(errs) != 3 || errs[0].(map[string]interface{})["index"] != float64(1) {
+		t.Errorf("Unexpected errors in continue mode: %v", response["errors"])
+	}
+	if count() != 5 {
+		t.Errorf("Expected 5 rows, got %d", count())
+	}
+
+	// A single object keeps its response shape
+	rr, response = post("/cats", "application/json", ` {"name": "Sake"}`)
+	if rr.Code != http.StatusCreated || response["id"] != float64(6) {
+		t.Errorf("Unexpected single insert response %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Keys filled by a default are read back
+	rr, response = post("/tokens", "application/json", `{"label": "single"}`)
+	token, _ := response["id"].(string)
+	if rr.Code != http.StatusCreated || len(token) != 16 || rr.Header().Get("Location") != "/tokens/"+token {
+		t.Errorf("Expected the default key, got %d %s: %s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+	rr, response = post("/tokens", "application/json", `[{"label": "first"}, {"label": "second"}]`)
+	if ids := response["ids"].([]interface{}); rr.Code != http.StatusCreated || len(ids) != 2 || ids[0] == nil || ids[1] == nil || ids[0] == ids[1] {
+		t.Errorf("Expected the default keys, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	invalid := []struct {
+		path, contentType, body string
+	}{
+		{"/cats", "application/json", `[]`},
+		{"/cats", "application/json", `[{"name": "Mezcal"}, 1]`},
+		{"/cats", "application/json", `[{"name": "Mezcal"}`},
+		{"/cats", "application/x-ndjson", ``},
+		{"/cats?on_error=skip", "application/json", `[{"name": "Mezcal"}]`},
+		{"/cats?on_error=continue", "application/json", `[{"name": "Mezcal"}, "oops"]`},
+	}
+	for _, tc := range invalid {
+		rr, _ := post(tc.path, tc.contentType, tc.body)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got status %d, want %d", tc.path, tc.body, rr.Code, http.StatusBadRequest)
+		}
+	}
+	if count() != 6 {
+		t.Errorf("Expected invalid payloads to insert nothing, got %d rows", count())
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Conflict resolutions accepted in the Prefer header of inserts
+const (
+	mergeDuplicates  = "merge-duplicates"
+	ignoreDuplicates = "ignore-duplicates"
+)
+
+// preferences parses the Prefer request headers into their preferences,
+// e.g. "resolution=merge-duplicates". Names are lowercased and preferences
+// without a value map to an empty string.
+func preferences(r *http.Request) map[string]string {
+	prefs := make(map[string]string)
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			// Parameters after ';' are not used
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(pref, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			prefs[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return prefs
+}
+
+// conflictClause is the ON CONFLICT handling of an insert: the quoted
+// conflict target columns and what to do with conflicting rows
+type conflictClause struct {
+	target     []string
+	resolution string
+	// update lists the quoted columns replaced on merge. When nil, the
+	// inserted columns are.
+	update []string
+	// version is the quoted version column bumped on merge, if any
+	version string
+}
+
+// Conflict reads the on_conflict parameter and the resolution preference of
+// an insert. It returns nil when conflicting rows should fail the insert.
+// The target defaults to the primary key.
+func (t *tableSchema) Conflict(r *http.Request) (*conflictClause, error) {
+	onConflict := r.URL.Query().Get("on_conflict")
+	resolution, ok := preferences(r)["resolution"]
+	if !ok {
+		if strings.TrimSpace(onConflict) != "" {
+			return nil, &requestError{message: fmt.Sprintf("on_conflict requires the Prefer: resolution=%s or resolution=%s header", mergeDuplicates, ignoreDuplicates)}
+		}
+		return nil, nil
+	}
+	resolution = strings.ToLower(resolution)
+	if resolution != mergeDuplicates && resolution != ignoreDuplicates {
+		return nil, &identifierError{kind: "resolution", name: resolution, valid: []string{mergeDuplicates, ignoreDuplicates}}
+	}
+
+	clause := &conflictClause{resolution: resolution, version: t.quotedVersion()}
+	if strings.TrimSpace(onConflict) == "" {
+		clause.target = t.quotedKey()
+		return clause, nil
+	}
+	for _, name := range strings.Split(onConflict, ",") {
+		column, err := t.QuotedColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		clause.target = append(clause.target, column)
+	}
+	return clause, nil
+}
+
+// quotedKey returns the quoted primary key columns, rowid left unquoted
+func (t *tableSchema) quotedKey() []string {
+	pk := t.PrimaryKey()
+	quoted := make([]string, len(pk))
+	for i, column := range pk {
+		quoted[i] = orderTerm{Column: column}.Quoted()
+	}
+	return quoted
+}
+
+// SQL returns the ON CONFLICT clause of an insert of the given quoted
+// columns. Merging a row whose only columns are the target ones leaves it
+// untouched.
+func (c *conflictClause) SQL(columns []string) string {
+	target := "(" + strings.Join(c.target, ", ") + ")"
+	if c.resolution == ignoreDuplicates {
+		return "ON CONFLICT" + target + " DO NOTHING"
+	}
+
+	update := c.update
+	if update == nil {
+		update = columns
+	}
+	var set []string
+	for _, column := range update {
+		if !containsString(c.target, column) && column != c.version {
+			set = append(set, fmt.Sprintf("%s = excluded.%s", column, column))
+		}
+	}
+	if len(set) == 0 {
+		return "ON CONFLICT" + target + " DO NOTHING"
+	}
+	if c.version != "" {
+		set = append(set, bumpClause(c.version))
+	}
+	return "ON CONFLICT" + target + " DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// isConflictTargetError reports whether SQLite rejected an on_conflict
+// target that is not a primary key or unique constraint
+func isConflictTargetError(err error) bool {
+	return strings.Contains(err.Error(), "ON CONFLICT clause does not match")
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
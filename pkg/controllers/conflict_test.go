@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestReplace(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, paw INTEGER DEFAULT 4);
+		CREATE TABLE notes (body TEXT, rank INTEGER);
+		CREATE TABLE stock (shop TEXT, sku TEXT, qty INTEGER, PRIMARY KEY (shop, sku));
+		INSERT INTO cats VALUES (1, 'Tequila', 3);
+		INSERT INTO notes (rowid, body, rank) VALUES (1, 'first', 1);
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.PUT("/:table/:id", Replace(pool))
+
+	put := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Existing rows are replaced, missing columns reset to their default
+	rr := put("/cats/1", `{"name": "Whisky"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var name string
+	var paw int
+	pool.Reader.QueryRow("SELECT name, paw FROM cats WHERE id = 1").Scan(&name, &paw)
+	if name != "Whisky" || paw != 4 {
+		t.Errorf("Expected the row to be replaced, got %s, %d", name, paw)
+	}
+
+	// New keys are inserted
+	rr = put("/cats/7", `{"id": 7, "name": "Rhum", "paw": 2}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["id"] != float64(7) {
+		t.Errorf("Expected id 7, got %v", response)
+	}
+
+	// Tables keyed by rowid and composite keys
+	if rr := put("/notes/1", `{"body": "updated"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for rowid table, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rank sql.NullInt64
+	pool.Reader.QueryRow("SELECT body, rank FROM notes WHERE rowid = 1").Scan(&name, &rank)
+	if name != "updated" || rank.Valid {
+		t.Errorf("Expected the note to be replaced, got %s, %v", name, rank)
+	}
+	if rr := put("/stock/paris,A1", `{"qty": 5}`); rr.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 for composite key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := put("/stock/sku=A1;shop=paris", `{"qty": 6}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for composite key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var qty int
+	pool.Reader.QueryRow("SELECT qty FROM stock WHERE shop = 'paris' AND sku = 'A1'").Scan(&qty)
+	if qty != 6 {
+		t.Errorf("Expected qty 6, got %d", qty)
+	}
+
+	invalid := []struct{ path, body string }{
+		{"/cats/1", `{"id": 2, "name": "Gin"}`},
+		{"/cats/1", `{"nope": 1}`},
+		{"/cats/1", `[{"name": "Gin"}]`},
+		{"/cats/abc", `{"name": "Gin"}`},
+	}
+	for _, tc := range invalid {
+		if rr := put(tc.path, tc.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s %s: got status %d, want %d", tc.path, tc.body, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCreateOnConflict(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, name TEXT, visits INTEGER DEFAULT 0);
+		INSERT INTO users (id, email, name, visits) VALUES (1, 'ann@example.com', 'Ann', 3);
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.POST("/:table", Create(pool))
+
+	post := func(path, prefer, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if prefer != "" {
+			req.Header.Set("Prefer", prefer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// Merging updates the sent columns and returns the existing key
+	rr, response := post("/users?on_conflict=email", "resolution=merge-duplicates", `{"email": "ann@example.com", "name": "Anna"}`)
+	if rr.Code != http.StatusCreated || response["id"] != float64(1) {
+		t.Fatalf("Expected the existing key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var name string
+	var visits int
+	pool.Reader.QueryRow("SELECT name, visits FROM users WHERE id = 1").Scan(&name, &visits)
+	if name != "Anna" || visits != 3 {
+		t.Errorf("Expected only the name to be merged, got %s, %d", name, visits)
+	}
+
+	// Ignoring keeps the existing row
+	rr, response = post("/users?on_conflict=email", "resolution=ignore-duplicates", `{"email": "ann@example.com", "name": "Nope"}`)
+	if rr.Code != http.StatusOK || response["id"] != nil {
+		t.Errorf("Expected an ignored row without key, got %d: %s", rr.Code, rr.Body.String())
+	}
+	pool.Reader.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name)
+	if name != "Anna" {
+		t.Errorf("Expected the row to be kept, got %s", name)
+	}
+
+	// The primary key is the default target
+	rr, response = post("/users", "return=minimal, resolution=merge-duplicates", `{"id": 1, "visits": 4}`)
+	if rr.Code != http.StatusCreated || response["id"] != float64(1) {
+		t.Errorf("Expected the merged key, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Bulk payloads mix inserts, merges and ignored rows
+	rr, response = post("/users?on_conflict=email", "resolution=merge-duplicates", `[{"email": "bob@example.com", "name": "Bob"}, {"email": "ann@example.com", "visits": 5}]`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ids := response["ids"].([]interface{}); len(ids) != 2 || ids[0] != float64(2) || ids[1] != float64(1) || response["inserted"] != float64(2) {
+		t.Errorf("Unexpected bulk merge response: %v", response)
+	}
+	rr, response = post("/users?on_conflict=email", "resolution=ignore-duplicates", `[{"email": "bob@example.com"}, {"email": "cat@example.com"}]`)
+	if ids := response["ids"].([]interface{}); rr.Code != http.StatusCreated || ids[0] != nil || ids[1] != float64(3) || response["inserted"] != float64(1) {
+		t.Errorf("Unexpected bulk ignore response: %s", rr.Body.String())
+	}
+	rr, response = post("/users?on_conflict=email", "resolution=ignore-duplicates", `[{"email": "bob@example.com"}, {"email": "cat@example.com"}]`)
+	if rr.Code != http.StatusOK || response["inserted"] != float64(0) {
+		t.Errorf("Expected status 200 when every row is ignored, got %d: %s", rr.Code, rr.Body.String())
+	}
+	pool.Reader.QueryRow("SELECT visits FROM users WHERE id = 1").Scan(&visits)
+	if visits != 5 {
+		t.Errorf("Expected visits to be merged, got %d", visits)
+	}
+
+	invalid := []struct{ path, prefer, body string }{
+		{"/users?on_conflict=email", "", `{"email": "ann@example.com"}`},
+		{"/users?on_conflict=nope", "resolution=merge-duplicates", `{"email": "ann@example.com"}`},
+		{"/users?on_conflict=name", "resolution=merge-duplicates", `{"email": "ann@example.com"}`},
+		{"/users?on_conflict=name", "resolution=merge-duplicates", `[{"email": "ann@example.com"}]`},
+		{"/users", "resolution=replace", `{"email": "ann@example.com"}`},
+		{"/users", "", `{"email": "ann@example.com"}`},
+	}
+	for _, tc := range invalid {
+		if rr, _ := post(tc.path, tc.prefer, tc.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got status %d, want %d: %s", tc.path, tc.prefer, rr.Code, http.StatusBadRequest, rr.Body.String())
+		}
+	}
+}
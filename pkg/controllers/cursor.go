@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// cursorColumnPrefix names the hidden columns selected to build cursors
+const cursorColumnPrefix = "__cursor_"
+
+var (
+	cursorSecretOnce sync.Once
+	cursorSecret     []byte
+)
+
+// getCursorSecret returns the key cursors are signed with. It is read from
+// SQLITE_REST_CURSOR_SECRET, or generated at startup in which case cursors
+// do not survive a restart.
+func getCursorSecret() []byte {
+	cursorSecretOnce.Do(func() {
+		if secret := os.Getenv("SQLITE_REST_CURSOR_SECRET"); secret != "" {
+			cursorSecret = []byte(secret)
+			return
+		}
+		cursorSecret = make([]byte, 32)
+		if _, err := rand.Read(cursorSecret); err != nil {
+			panic(fmt.Sprintf("cannot generate cursor secret: %s", err.Error()))
+		}
+	})
+	return cursorSecret
+}
+
+// cursorPayload is the signed content of a cursor: the ordering it was built
+// for and the key values of the last row of the page
+type cursorPayload struct {
+	Table  string        `json:"t"`
+	Order  []string      `json:"o"`
+	Values []interface{} `json:"v"`
+}
+
+// KeysetTerms returns the order terms followed by the primary key columns
+// that are not already ordered on, so every row has a unique position.
+// Views have no key and cannot be paginated with cursors.
+func (t *tableSchema) KeysetTerms(terms []orderTerm) ([]orderTerm, bool) {
+	if t.View {
+		return nil, false
+	}
+
+	keyset := append([]orderTerm{}, terms...)
+	for _, column := range t.PrimaryKey() {
+		found := false
+		for _, term := range terms {
+			if term.Column == column {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keyset = append(keyset, orderTerm{Column: column, Direction: "ASC"})
+		}
+	}
+	return keyset, true
+}
+
+// cursorSelect returns the hidden columns holding the keyset values. The
+// unary + drops the declared type so values scan as stored.
+func cursorSelect(terms []orderTerm) string {
+	columns := make([]string, len(terms))
+	for i, term := range terms {
+		columns[i] = fmt.Sprintf("+%s AS %s", term.Quoted(), quoteIdent(fmt.Sprintf("%s%d", cursorColumnPrefix, i)))
+	}
+	return strings.Join(columns, ", ")
+}
+
+// cursorOrder describes the ordering a cursor belongs to
+func cursorOrder(terms []orderTerm) []string {
+	order := make([]string, len(terms))
+	for i, term := range terms {
+		direction := "asc"
+		if term.Desc() {
+			direction = "desc"
+		}
+		order[i] = term.Column + ":" + direction
+	}
+	return order
+}
+
+// encodeCursor signs the keyset values of a row as an opaque token
+func encodeCursor(table *tableSchema, terms []orderTerm, values []interface{}) (string, error) {
+	encoded := make([]interface{}, len(values))
+	for i, value := range values {
+		if blob, ok := value.([]byte); ok {
+			encoded[i] = map[string]interface{}{blobKey: base64.StdEncoding.EncodeToString(blob)}
+		} else {
+			encoded[i] = value
+		}
+	}
+
+	payload, err := json.Marshal(cursorPayload{Table: table.Name, Order: cursorOrder(terms), Values: encoded})
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, getCursorSecret())
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeCursor checks the signature of a cursor and that it was issued for
+// the same table and ordering, and returns its keyset values
+func decodeCursor(token string, table *tableSchema, terms []orderTerm) ([]interface{}, error) {
+	invalid := &requestError{message: "Invalid cursor parameter"}
+
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, invalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, invalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, invalid
+	}
+
+	mac := hmac.New(sha256.New, getCursorSecret())
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, invalid
+	}
+
+	var cursor cursorPayload
+	if err := decodeJSON(bytes.NewReader(payload), &cursor); err != nil {
+		return nil, invalid
+	}
+
+	order := cursorOrder(terms)
+	if cursor.Table != table.Name || strings.Join(cursor.Order, ",") != strings.Join(order, ",") || len(cursor.Values) != len(terms) {
+		return nil, &requestError{message: "The cursor was issued for a different table or ordering, use the same order_by and order_dir as the first page"}
+	}
+
+	values := make([]interface{}, len(cursor.Values))
+	for i, value := range cursor.Values {
+		if values[i], err = bindValue(value); err != nil {
+			return nil, invalid
+		}
+	}
+	return values, nil
+}
+
+// seekCondition selects the rows sorted after the given keyset values. It
+// uses a row value comparison when every column goes the same way and no
+// value is NULL, otherwise it expands to
+//
+//	c1 > v1 OR (c1 = v1 AND c2 > v2) OR ...
+//
+// where each comparison follows its column direction and SQLite's ordering
+// of NULLs before any other value.
+func seekCondition(terms []orderTerm, values []interface{}) (string, []interface{}) {
+	uniform := true
+	for i, term := range terms {
+		if values[i] == nil || term.Desc() != terms[0].Desc() {
+			uniform = false
+			break
+		}
+	}
+
+	if uniform {
+		operator := ">"
+		if terms[0].Desc() {
+			operator = "<"
+		}
+		columns := make([]string, len(terms))
+		for i, term := range terms {
+			columns[i] = term.Quoted()
+		}
+		if len(terms) == 1 {
+			return fmt.Sprintf("%s %s ?", columns[0], operator), values
+		}
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), operator, placeholders(len(terms))), values
+	}
+
+	var branches []string
+	var args []interface{}
+	for i, term := range terms {
+		var conditions []string
+		var branchArgs []interface{}
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, terms[j].Quoted()+" IS ?")
+			branchArgs = append(branchArgs, values[j])
+		}
+
+		column := term.Quoted()
+		switch {
+		case values[i] == nil && term.Desc():
+			// NULLs sort last in descending order, nothing comes after them
+			continue
+		case values[i] == nil:
+			conditions = append(conditions, column+" IS NOT NULL")
+		case term.Desc():
+			conditions = append(conditions, fmt.Sprintf("(%s < ? OR %s IS NULL)", column, column))
+			branchArgs = append(branchArgs, values[i])
+		default:
+			conditions = append(conditions, column+" > ?")
+			branchArgs = append(branchArgs, values[i])
+		}
+
+		branches = append(branches, "("+strings.Join(conditions, " AND ")+")")
+		args = append(args, branchArgs...)
+	}
+
+	if len(branches) == 0 {
+		return "0", nil
+	}
+	return "(" + strings.Join(branches, " OR ") + ")", args
+}
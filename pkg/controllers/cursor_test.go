@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGetAllCursorPagination(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, paw INTEGER);
+		CREATE TABLE tags (owner TEXT, label TEXT, PRIMARY KEY (owner, label)) WITHOUT ROWID;
+		CREATE VIEW four_paws AS SELECT * FROM cats WHERE paw = 4;
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	// Duplicate and NULL names and paws exercise the tie breaking and NULL ordering
+	for i := 1; i <= 23; i++ {
+		var name, paw interface{}
+		if i%5 != 0 {
+			name = fmt.Sprintf("cat %02d", i%7)
+		}
+		if i%6 != 0 {
+			paw = i % 3
+		}
+		conn.Exec("INSERT INTO cats (name, paw) VALUES (?, ?)", name, paw)
+	}
+	for _, owner := range []string{"ann", "bob", "carl"} {
+		for _, label := range []string{"a", "b", "c", "d"} {
+			conn.Exec("INSERT INTO tags VALUES (?, ?)", owner, label)
+		}
+	}
+	conn.Close()
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table", GetAll(pool))
+
+	get := func(path string, query url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", path+"?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// walk pages through every cursor and returns the keys in order
+	walk := func(path string, query url.Values, key func(map[string]interface{}) string) []string {
+		var keys []string
+		for page := 0; page < 50; page++ {
+			rr, response := get(path, query)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("%s?%s: got status %d: %s", path, query.Encode(), rr.Code, rr.Body.String())
+			}
+			rows, _ := response["data"].([]interface{})
+			for _, row := range rows {
+				keys = append(keys, key(row.(map[string]interface{})))
+			}
+			next, _ := response["next_cursor"].(string)
+			if next == "" {
+				return keys
+			}
+			query.Set("cursor", next)
+		}
+		t.Fatalf("%s: cursor pagination did not end", path)
+		return nil
+	}
+
+	// expected lists the keys of one request without pagination
+	expected := func(path string, query url.Values, key func(map[string]interface{}) string) []string {
+		query.Del("limit")
+		_, response := get(path, query)
+		var keys []string
+		for _, row := range response["data"].([]interface{}) {
+			keys = append(keys, key(row.(map[string]interface{})))
+		}
+		return keys
+	}
+
+	catKey := func(row map[string]interface{}) string { return fmt.Sprint(row["id"]) }
+	tagKey := func(row map[string]interface{}) string { return fmt.Sprint(row["owner"], "/", row["label"]) }
+
+	tests := []struct {
+		path  string
+		query string
+		key   func(map[string]interface{}) string
+	}{
+		{"/cats", "limit=4", catKey},
+		{"/cats", "limit=5&order_by=name", catKey},
+		{"/cats", "limit=3&order_by=name&order_dir=desc", catKey},
+		{"/cats", "limit=4&order_by=paw,name&order_dir=desc,asc", catKey},
+		{"/cats", "limit=4&order_by=name,paw&order_dir=asc,desc&paw=not.eq.1", catKey},
+		{"/cats", "limit=2&order_by=paw&cols=name", func(row map[string]interface{}) string { return fmt.Sprint(row["name"]) }},
+		{"/tags", "limit=5&order_by=label&order_dir=desc", tagKey},
+	}
+
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		got := walk(test.path, query, test.key)
+		query, _ = url.ParseQuery(test.query)
+		want := expected(test.path, query, test.key)
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s?%s:\n got  %v\n want %v", test.path, test.query, got, want)
+		}
+	}
+
+	// Deleting rows before the cursor position does not shift the next page
+	_, response := get("/cats", url.Values{"limit": {"10"}, "order_by": {"id"}})
+	cursor := response["next_cursor"].(string)
+	pool.Writer.Exec("DELETE FROM cats WHERE id <= 5")
+	rr, response := get("/cats", url.Values{"limit": {"1"}, "order_by": {"id"}, "cursor": {cursor}})
+	if rows, _ := response["data"].([]interface{}); len(rows) != 1 || rows[0].(map[string]interface{})["id"] != float64(11) {
+		t.Errorf("Expected the page after the cursor to start at id 11, got %v", response["data"])
+	}
+	if !strings.Contains(rr.Header().Get("Link"), `rel="next"`) {
+		t.Errorf("Expected a next link, got %s", rr.Header().Get("Link"))
+	}
+
+	// Cursors only resume the ordering they were issued for
+	invalid := []url.Values{
+		{"limit": {"10"}, "order_by": {"name"}, "cursor": {cursor}},
+		{"limit": {"10"}, "order_by": {"id"}, "order_dir": {"desc"}, "cursor": {cursor}},
+		{"limit": {"10"}, "order_by": {"id"}, "cursor": {cursor}, "offset": {"10"}},
+		{"limit": {"10"}, "order_by": {"id"}, "cursor": {cursor[:len(cursor)-2] + "AA"}},
+		{"limit": {"10"}, "order_by": {"id"}, "cursor": {"garbage"}},
+	}
+	for _, query := range invalid {
+		rr, _ := get("/cats", query)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query.Encode(), rr.Code, http.StatusBadRequest)
+		}
+	}
+	rr, _ = get("/tags", url.Values{"limit": {"10"}, "order_by": {"id"}, "cursor": {cursor}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a cursor of another table to be rejected, got %d", rr.Code)
+	}
+
+	// Views have no key to resume from
+	_, response = get("/four_paws", url.Values{"limit": {"1"}})
+	if _, ok := response["next_cursor"]; ok {
+		t.Errorf("Expected no next_cursor on a view, got %v", response["next_cursor"])
+	}
+	rr, _ = get("/four_paws", url.Values{"limit": {"1"}, "cursor": {cursor}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected cursor on a view to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestSeekCondition(t *testing.T) {
+	asc := orderTerm{Column: "a", Direction: "ASC"}
+	desc := orderTerm{Column: "b", Direction: "DESC"}
+	id := orderTerm{Column: "id", Direction: "ASC"}
+
+	tests := []struct {
+		terms    []orderTerm
+		values   []interface{}
+		expected string
+		args     int
+	}{
+		{[]orderTerm{id}, []interface{}{int64(1)}, `"id" > ?`, 1},
+		{[]orderTerm{asc, id}, []interface{}{"x", int64(1)}, `("a", "id") > (?,?)`, 2},
+		{[]orderTerm{asc, id}, []interface{}{nil, int64(1)}, `(("a" IS NOT NULL) OR ("a" IS ? AND "id" > ?))`, 2},
+		{[]orderTerm{desc, id}, []interface{}{"x", int64(1)}, `((("b" < ? OR "b" IS NULL)) OR ("b" IS ? AND "id" > ?))`, 3},
+		{[]orderTerm{desc, id}, []interface{}{nil, int64(1)}, `(("b" IS ? AND "id" > ?))`, 2},
+	}
+
+	for _, test := range tests {
+		condition, args := seekCondition(test.terms, test.values)
+		if condition != test.expected || len(args) != test.args {
+			t.Errorf("seekCondition(%v, %v) = %s %v, want %s with %d args", test.terms, test.values, condition, args, test.expected, test.args)
+		}
+	}
+}
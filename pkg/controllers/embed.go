@@ -0,0 +1,564 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// embedBatchSize bounds the number of parent keys bound in one query
+const embedBatchSize = 500
+
+// embedColumnPrefix names the hidden columns holding relationship keys
+const embedColumnPrefix = "__embed_"
+
+// foreignKey is a foreign key constraint read from PRAGMA foreign_key_list.
+// To is empty when the constraint references the parent primary key.
+type foreignKey struct {
+	Table string
+	From  []string
+	To    []string
+}
+
+// readForeignKeys reads the foreign keys of a table, grouping the columns of
+// composite keys
+func readForeignKeys(db querier, tableName string) ([]foreignKey, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", quoteIdent(tableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []foreignKey
+	index := make(map[int]int)
+	for rows.Next() {
+		var id, seq int
+		var table, from string
+		var to, onUpdate, onDelete, match sql.NullString
+
+		if err := rows.Scan(&id, &seq, &table, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[id]
+		if !ok {
+			i = len(keys)
+			index[id] = i
+			keys = append(keys, foreignKey{Table: table})
+		}
+		keys[i].From = append(keys[i].From, from)
+		if to.Valid && to.String != "" {
+			keys[i].To = append(keys[i].To, to.String)
+		}
+	}
+
+	return keys, rows.Err()
+}
+
+// referencedColumns returns the parent columns of a foreign key
+func (fk foreignKey) referencedColumns(parent *tableSchema) []string {
+	if len(fk.To) == len(fk.From) {
+		return fk.To
+	}
+	return parent.PrimaryKey()
+}
+
+// relationshipKind tells how many embedded rows match a row
+type relationshipKind int
+
+const (
+	manyToOne relationshipKind = iota
+	oneToMany
+	manyToMany
+)
+
+// relationship joins an embedding table to an embedded one. Source columns
+// belong to the embedding table and match target columns of the embedded
+// table, or junction columns for many-to-many relationships.
+type relationship struct {
+	kind           relationshipKind
+	target         *tableSchema
+	source         []string
+	targetKey      []string
+	junction       *tableSchema
+	junctionTarget []string
+	targetJoin     []string
+}
+
+// embedSelect is a resource embedded with name(columns) in the select
+// parameter. Name is the key of the embedded rows in the response, Target
+// the table or foreign key column it refers to and Hint disambiguates
+// between several relationships, as in alias:target!hint(columns).
+type embedSelect struct {
+	selection
+	Name   string
+	Target string
+	Hint   string
+
+	filters url.Values
+	rel     *relationship
+	where   string
+	args    []interface{}
+}
+
+// parseSelect parses the select parameter into plain columns, embedded
+// resources and aggregates, e.g. "id,total,customer(name,email),items(sku,qty)"
+// or "status,count(),sum(total)"
+func parseSelect(list string) (*selection, error) {
+	items, err := splitTopLevel(list)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &selection{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, &requestError{message: fmt.Sprintf("Empty item in select: %s", list)}
+		}
+
+		open := strings.Index(item, "(")
+		if open < 0 {
+			parsed.Columns = append(parsed.Columns, item)
+			continue
+		}
+		if !strings.HasSuffix(item, ")") {
+			return nil, &requestError{message: fmt.Sprintf("Invalid embedded resource in select: %s", item)}
+		}
+
+		var alias string
+		head := strings.TrimSpace(item[:open])
+		inner := item[open+1 : len(item)-1]
+		if name, target, ok := strings.Cut(head, ":"); ok {
+			alias = strings.TrimSpace(name)
+			head = strings.TrimSpace(target)
+		}
+
+		if aggregateFunctions[strings.ToLower(head)] {
+			parsed.Aggregates = append(parsed.Aggregates, newAggregate(alias, strings.ToLower(head), strings.TrimSpace(inner)))
+			continue
+		}
+
+		embed := &embedSelect{Name: alias, filters: url.Values{}}
+		embed.Target, embed.Hint, _ = strings.Cut(head, "!")
+		if embed.Name == "" {
+			embed.Name = embed.Target
+		}
+		if embed.Target == "" {
+			return nil, &requestError{message: fmt.Sprintf("Invalid embedded resource in select: %s", item)}
+		}
+
+		inside, err := parseSelect(inner)
+		if err != nil {
+			return nil, err
+		}
+		if len(inside.Aggregates) > 0 {
+			return nil, &requestError{message: fmt.Sprintf("Aggregates are not supported inside embedded resource %s", embed.Name)}
+		}
+		embed.selection = *inside
+		parsed.Embeds = append(parsed.Embeds, embed)
+	}
+
+	return parsed, nil
+}
+
+// selectColumns returns the select list of the columns named in a select
+// parameter. Everything is selected when no column or only * is named,
+// unless the select only embeds resources.
+func selectColumns(table *tableSchema, columns []string, embeds []*embedSelect) (string, error) {
+	if len(columns) == 0 && len(embeds) > 0 {
+		return "", nil
+	}
+	for _, column := range columns {
+		if column == "*" {
+			return "*", nil
+		}
+	}
+	return table.SelectList(strings.Join(columns, ","))
+}
+
+// routeEmbedParams moves parameters prefixed with the name of an embedded
+// resource, such as items.qty=gt.5, to that resource and returns the others
+func routeEmbedParams(query url.Values, embeds []*embedSelect) url.Values {
+	if len(embeds) == 0 {
+		return query
+	}
+
+	own := url.Values{}
+	for key, values := range query {
+		routed := false
+		if prefix, rest, ok := strings.Cut(key, "."); ok {
+			for _, embed := range embeds {
+				if embed.Name == prefix {
+					embed.filters[rest] = append(embed.filters[rest], values...)
+					routed = true
+					break
+				}
+			}
+		}
+		if !routed {
+			own[key] = values
+		}
+	}
+	return own
+}
+
+// resolveEmbeds finds the relationship of every embedded resource, checks
+// its columns and compiles its filters
+func resolveEmbeds(db querier, table *tableSchema, embeds []*embedSelect) error {
+	seen := make(map[string]bool)
+	for _, embed := range embeds {
+		if seen[embed.Name] {
+			return &requestError{message: fmt.Sprintf("Embedded resource %s is selected twice, use an alias such as other:%s(...)", embed.Name, embed.Target)}
+		}
+		seen[embed.Name] = true
+
+		rel, err := resolveRelationship(db, table, embed)
+		if err != nil {
+			return err
+		}
+		embed.rel = rel
+
+		if _, err := selectColumns(rel.target, embed.Columns, embed.Embeds); err != nil {
+			return err
+		}
+
+		own := routeEmbedParams(embed.filters, embed.Embeds)
+		embed.where, embed.args, err = compileColumnFilters(own, rel.target)
+		if err != nil {
+			return err
+		}
+
+		if err := resolveEmbeds(db, rel.target, embed.Embeds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRelationship looks for the foreign keys joining table to the
+// embedded resource: a foreign key of table (many-to-one), a foreign key of
+// the embedded table (one-to-many) or a junction table with foreign keys to
+// both (many-to-many). The target may name the embedded table or, for
+// many-to-one relationships, the foreign key column with or without its _id
+// suffix. A hint names the foreign key column or junction table to use.
+// Self references embed children by table name and parents by column.
+func resolveRelationship(db querier, table *tableSchema, embed *embedSelect) (*relationship, error) {
+	names, err := listRelations(db)
+	if err != nil {
+		return nil, err
+	}
+	targetName, isRelation := matchIdent(names, embed.Target)
+
+	matchesHint := func(candidates ...string) bool {
+		if embed.Hint == "" {
+			return true
+		}
+		for _, candidate := range candidates {
+			if strings.EqualFold(candidate, embed.Hint) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var found []*relationship
+
+	// Many-to-one through a foreign key of table
+	sourceKeys, err := readForeignKeys(db, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range sourceKeys {
+		// A table embedding itself by name gets its children, parents are
+		// embedded by foreign key column
+		byTable := isRelation && strings.EqualFold(fk.Table, targetName) && !strings.EqualFold(fk.Table, table.Name)
+		byColumn := len(fk.From) == 1 && (strings.EqualFold(fk.From[0], embed.Target) || strings.EqualFold(fk.From[0], embed.Target+"_id"))
+		if !(byTable || byColumn) || !matchesHint(fk.From...) {
+			continue
+		}
+		parent, err := resolveTable(db, fk.Table)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, &relationship{kind: manyToOne, target: parent, source: fk.From, targetKey: fk.referencedColumns(parent)})
+	}
+
+	if isRelation {
+		target, err := resolveTable(db, targetName)
+		if err != nil {
+			return nil, err
+		}
+
+		// One-to-many through a foreign key of the embedded table
+		targetKeys, err := readForeignKeys(db, target.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, fk := range targetKeys {
+			if strings.EqualFold(fk.Table, table.Name) && matchesHint(fk.From...) {
+				found = append(found, &relationship{kind: oneToMany, target: target, source: fk.referencedColumns(table), targetKey: fk.From})
+			}
+		}
+
+		// Many-to-many through a junction table referencing both
+		for _, name := range names {
+			if name == table.Name || name == target.Name || !matchesHint(name) {
+				continue
+			}
+			junctionKeys, err := readForeignKeys(db, name)
+			if err != nil {
+				return nil, err
+			}
+			for i, toSource := range junctionKeys {
+				if !strings.EqualFold(toSource.Table, table.Name) {
+					continue
+				}
+				for j, toTarget := range junctionKeys {
+					if i == j || !strings.EqualFold(toTarget.Table, target.Name) {
+						continue
+					}
+					junction, err := resolveTable(db, name)
+					if err != nil {
+						return nil, err
+					}
+					found = append(found, &relationship{
+						kind:           manyToMany,
+						target:         target,
+						source:         toSource.referencedColumns(table),
+						targetKey:      toSource.From,
+						junction:       junction,
+						junctionTarget: toTarget.From,
+						targetJoin:     toTarget.referencedColumns(target),
+					})
+				}
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, &requestError{message: fmt.Sprintf("No relationship found between %s and %s", table.Name, embed.Target)}
+	case 1:
+		return found[0], nil
+	}
+	return nil, &requestError{message: fmt.Sprintf("More than one relationship found between %s and %s, add a hint naming the foreign key column or junction table, e.g. %s!column(...)", table.Name, embed.Target, embed.Target)}
+}
+
+// embedKeySelect returns the hidden columns holding the source keys of every
+// embedded resource and their number
+func embedKeySelect(embeds []*embedSelect) ([]string, int) {
+	var columns []string
+	for _, embed := range embeds {
+		for _, column := range embed.rel.source {
+			columns = append(columns, fmt.Sprintf("+%s AS %s", quoteIdent(column), quoteIdent(fmt.Sprintf("%s%d", embedColumnPrefix, len(columns)))))
+		}
+	}
+	return columns, len(columns)
+}
+
+// from returns the FROM expression of the embedded rows and the columns on it
+// matching the source keys
+func (rel *relationship) from() (string, []string) {
+	if rel.kind != manyToMany {
+		return rel.target.Quoted(), rel.targetKey
+	}
+
+	var via, on []string
+	var keys []string
+	for i, column := range rel.targetKey {
+		key := fmt.Sprintf("__via_%d", i)
+		via = append(via, fmt.Sprintf("junction.%s AS %s", quoteIdent(column), quoteIdent(key)))
+		keys = append(keys, key)
+	}
+	for i, column := range rel.junctionTarget {
+		on = append(on, fmt.Sprintf("junction.%s = target.%s", quoteIdent(column), quoteIdent(rel.targetJoin[i])))
+	}
+
+	return fmt.Sprintf("(SELECT target.*, %s FROM %s AS target JOIN %s AS junction ON %s)",
+		strings.Join(via, ", "), rel.target.Quoted(), rel.junction.Quoted(), strings.Join(on, " AND ")), keys
+}
+
+// orderBy keeps embedded arrays in primary key order
+func (rel *relationship) orderBy() string {
+	if rel.kind == manyToOne || rel.target.View {
+		return ""
+	}
+	terms, _ := rel.target.KeysetTerms(nil)
+	if rel.kind == manyToMany && terms[0].Column == rowidColumn {
+		// The junction subquery does not expose the rowid
+		return ""
+	}
+	return orderByClause(terms)
+}
+
+// keyString identifies a key so parent and embedded rows can be matched.
+// Integral numbers are compared by value as SQLite does.
+func keyString(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		switch value := v.(type) {
+		case int64:
+			parts[i] = "n" + strconv.FormatInt(value, 10)
+		case float64:
+			if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+				parts[i] = "n" + strconv.FormatInt(int64(value), 10)
+			} else {
+				parts[i] = "f" + strconv.FormatFloat(value, 'g', -1, 64)
+			}
+		case []byte:
+			parts[i] = "b" + string(value)
+		default:
+			parts[i] = fmt.Sprintf("s%v", value)
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// keyIn builds the condition matching any of the given keys
+func keyIn(columns []string, keys [][]interface{}) (string, []interface{}) {
+	var args []interface{}
+	for _, key := range keys {
+		args = append(args, key...)
+	}
+
+	if len(columns) == 1 {
+		return fmt.Sprintf("%s IN (%s)", quoteIdent(columns[0]), placeholders(len(keys))), args
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdent(column)
+	}
+	tuples := make([]string, len(keys))
+	for i := range keys {
+		tuples[i] = "(" + placeholders(len(columns)) + ")"
+	}
+	return fmt.Sprintf("(%s) IN (VALUES %s)", strings.Join(quoted, ", "), strings.Join(tuples, ", ")), args
+}
+
+// attachEmbeds loads the embedded resources of rows and adds them to each
+// row. keys holds the hidden source key values of each row, in embed order.
+// Every resource is loaded with one query per batch of distinct keys.
+func attachEmbeds(db querier, rows []map[string]interface{}, keys [][]interface{}, embeds []*embedSelect) error {
+	offset := 0
+	for _, embed := range embeds {
+		width := len(embed.rel.source)
+
+		// Collect the distinct keys, NULL keys never match
+		var distinct [][]interface{}
+		seen := make(map[string]bool)
+		for _, rowKeys := range keys {
+			key := rowKeys[offset : offset+width]
+			if hasNull(key) {
+				continue
+			}
+			if s := keyString(key); !seen[s] {
+				seen[s] = true
+				distinct = append(distinct, key)
+			}
+		}
+
+		matched, err := embed.fetch(db, distinct)
+		if err != nil {
+			return err
+		}
+
+		for i, row := range rows {
+			children := matched[keyString(keys[i][offset:offset+width])]
+			if embed.rel.kind == manyToOne {
+				if len(children) > 0 {
+					row[embed.Name] = children[0]
+				} else {
+					row[embed.Name] = nil
+				}
+				continue
+			}
+			if children == nil {
+				children = []map[string]interface{}{}
+			}
+			row[embed.Name] = children
+		}
+
+		offset += width
+	}
+	return nil
+}
+
+// fetch loads the embedded rows matching keys, grouped by key
+func (embed *embedSelect) fetch(db querier, keys [][]interface{}) (map[string][]map[string]interface{}, error) {
+	matched := make(map[string][]map[string]interface{})
+	if len(keys) == 0 {
+		return matched, nil
+	}
+
+	source, keyColumns := embed.rel.from()
+	columns, _ := selectColumns(embed.rel.target, embed.Columns, embed.Embeds)
+	if columns == "*" {
+		// Name the columns so junction keys of many-to-many rows stay hidden
+		columns, _ = embed.rel.target.SelectList(strings.Join(embed.rel.target.ColumnNames(), ","))
+	}
+
+	// The matched key comes first among the hidden columns, followed by
+	// the keys of nested resources
+	var selected []string
+	if columns != "" {
+		selected = append(selected, columns)
+	}
+	for i, column := range keyColumns {
+		selected = append(selected, fmt.Sprintf("+%s AS %s", quoteIdent(column), quoteIdent(fmt.Sprintf("__key_%d", i))))
+	}
+	nestedSelect, nestedWidth := embedKeySelect(embed.Embeds)
+	selected = append(selected, nestedSelect...)
+
+	var children []map[string]interface{}
+	var nestedKeys [][]interface{}
+	for start := 0; start < len(keys); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		condition, args := keyIn(keyColumns, keys[start:end])
+		if embed.where != "" {
+			condition = fmt.Sprintf("(%s) AND %s", embed.where, condition)
+			args = append(append([]interface{}{}, embed.args...), args...)
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s %s", strings.Join(selected, ", "), source, condition, embed.rel.orderBy())
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		data, hidden, err := scanAll(rows, len(keyColumns)+nestedWidth)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, row := range data {
+			key := keyString(hidden[i][:len(keyColumns)])
+			matched[key] = append(matched[key], row)
+			children = append(children, row)
+			nestedKeys = append(nestedKeys, hidden[i][len(keyColumns):])
+		}
+	}
+
+	if err := attachEmbeds(db, children, nestedKeys, embed.Embeds); err != nil {
+		return nil, err
+	}
+	return matched, nil
+}
+
+// hasNull reports whether a key has a NULL value
+func hasNull(key []interface{}) bool {
+	for _, value := range key {
+		if value == nil {
+			return true
+		}
+	}
+	return false
+}
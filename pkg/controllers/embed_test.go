@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGetAllEmbedding(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+		CREATE TABLE products (sku TEXT PRIMARY KEY, name TEXT);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, total REAL, customer_id INTEGER REFERENCES customers);
+		CREATE TABLE items (id INTEGER PRIMARY KEY, order_id INTEGER REFERENCES orders(id), sku TEXT REFERENCES products(sku), qty INTEGER);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, label TEXT);
+		CREATE TABLE order_tags (order_id INTEGER REFERENCES orders(id), tag_id INTEGER REFERENCES tags(id), PRIMARY KEY (order_id, tag_id));
+		CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, mother_id INTEGER REFERENCES people(id), father_id INTEGER REFERENCES people(id));
+
+		INSERT INTO customers VALUES (1, 'Ann', 'ann@example.com'), (2, 'Bob', 'bob@example.com');
+		INSERT INTO products VALUES ('A1', 'Apple'), ('B2', 'Banana');
+		INSERT INTO orders VALUES (10, 9.5, 1), (11, 20, 2), (12, 3.25, NULL);
+		INSERT INTO items (order_id, sku, qty) VALUES (10, 'A1', 2), (10, 'B2', 7), (11, 'A1', 12);
+		INSERT INTO tags VALUES (1, 'gift'), (2, 'express');
+		INSERT INTO order_tags VALUES (10, 1), (10, 2), (11, 2);
+		INSERT INTO people VALUES (1, 'Eve', NULL, NULL), (2, 'Adam', NULL, NULL), (3, 'Cain', 1, 2);
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, tmpFile.Name())))
+
+	get := func(path string, query url.Values) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		req, _ := http.NewRequest("GET", path+"?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response.Data
+	}
+
+	rr, data := get("/orders", url.Values{"select": {"id,total,customer(name,email),items(sku,qty)"}, "order_by": {"id"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(data) != 3 {
+		t.Fatalf("Expected 3 orders, got %d", len(data))
+	}
+	if _, ok := data[0]["customer_id"]; ok {
+		t.Errorf("Expected only the selected columns, got %v", data[0])
+	}
+	customer := data[0]["customer"].(map[string]interface{})
+	if customer["name"] != "Ann" || customer["email"] != "ann@example.com" || len(customer) != 2 {
+		t.Errorf("Expected the customer of order 10 to be embedded, got %v", customer)
+	}
+	if data[2]["customer"] != nil {
+		t.Errorf("Expected a null customer for order 12, got %v", data[2]["customer"])
+	}
+	items := data[0]["items"].([]interface{})
+	if len(items) != 2 || items[1].(map[string]interface{})["qty"] != float64(7) {
+		t.Errorf("Expected the items of order 10, got %v", items)
+	}
+	if items := data[2]["items"].([]interface{}); len(items) != 0 {
+		t.Errorf("Expected no items for order 12, got %v", items)
+	}
+
+	// Filters and nested embedding inside embedded resources
+	_, data = get("/orders", url.Values{"select": {"id,items(qty,product:products(name))"}, "items.qty": {"gt.5"}, "order_by": {"id"}})
+	items = data[0]["items"].([]interface{})
+	if len(items) != 1 {
+		t.Fatalf("Expected the filtered items of order 10, got %v", items)
+	}
+	product := items[0].(map[string]interface{})["product"].(map[string]interface{})
+	if product["name"] != "Banana" {
+		t.Errorf("Expected the nested product, got %v", product)
+	}
+
+	// Embedded filters do not filter the parent rows
+	if len(data) != 3 {
+		t.Errorf("Expected 3 orders, got %d", len(data))
+	}
+
+	// Many-to-many through the junction table, keeping column types
+	_, data = get("/orders", url.Values{"select": {"id,tags(*)"}, "id": {"eq.10"}})
+	tags := data[0]["tags"].([]interface{})
+	if len(tags) != 2 {
+		t.Fatalf("Expected 2 tags, got %v", tags)
+	}
+	tag := tags[0].(map[string]interface{})
+	if tag["id"] != float64(1) || tag["label"] != "gift" || len(tag) != 2 {
+		t.Errorf("Expected the gift tag, got %v", tag)
+	}
+
+	// One-to-many and many-to-many from the other side
+	_, data = get("/tags", url.Values{"select": {"label,orders(id,customer_id(name))"}, "order_by": {"id"}})
+	orders := data[1]["orders"].([]interface{})
+	if len(orders) != 2 || orders[1].(map[string]interface{})["customer_id"].(map[string]interface{})["name"] != "Bob" {
+		t.Errorf("Expected both express orders with their customers, got %v", orders)
+	}
+	_, data = get("/customers", url.Values{"select": {"name,orders(id)"}, "order_by": {"id"}})
+	if orders := data[1]["orders"].([]interface{}); len(orders) != 1 {
+		t.Errorf("Expected one order for Bob, got %v", orders)
+	}
+
+	// Self references are told apart by their foreign key column
+	_, data = get("/people", url.Values{"select": {"name,mother(name),father_id(name)"}, "id": {"eq.3"}})
+	if data[0]["mother"].(map[string]interface{})["name"] != "Eve" || data[0]["father_id"].(map[string]interface{})["name"] != "Adam" {
+		t.Errorf("Expected both parents, got %v", data[0])
+	}
+	_, data = get("/people", url.Values{"select": {"name,children:people!mother_id(name)"}, "id": {"eq.1"}})
+	if children := data[0]["children"].([]interface{}); len(children) != 1 {
+		t.Errorf("Expected Eve's child, got %v", data[0]["children"])
+	}
+
+	invalid := []url.Values{
+		{"select": {"id,nope(name)"}},
+		{"select": {"id,customer(nope)"}},
+		{"select": {"id,items(sku"}},
+		{"select": {"id,items(sku)"}, "items.nope": {"eq.1"}},
+		{"select": {"id,items(sku)"}, "cols": {"id"}},
+		{"select": {"name,people(name)"}},
+		{"select": {"id,items(sku),items(qty)"}},
+	}
+	for _, query := range invalid {
+		path := "/orders"
+		if query.Get("select") == "name,people(name)" {
+			path = "/people"
+		}
+		rr, _ := get(path, query)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query.Encode(), rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetAllEmbeddingBatches(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	conn.Exec(`
+		CREATE TABLE parents (id INTEGER PRIMARY KEY);
+		CREATE TABLE children (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES parents);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1200)
+		INSERT INTO parents SELECT i FROM n;
+		INSERT INTO children (parent_id) SELECT id FROM parents;
+		INSERT INTO children (parent_id) SELECT id FROM parents WHERE id % 2 = 0;
+	`)
+	conn.Close()
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, tmpFile.Name())))
+
+	req, _ := http.NewRequest("GET", "/parents?select=id,children(id)", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var response struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Data) != 1200 {
+		t.Fatalf("Expected 1200 parents, got %d", len(response.Data))
+	}
+	for _, parent := range response.Data {
+		expected := 1
+		if int(parent["id"].(float64))%2 == 0 {
+			expected = 2
+		}
+		if children := parent["children"].([]interface{}); len(children) != expected {
+			t.Fatalf("Expected %d children for parent %v, got %d", expected, parent["id"], len(children))
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultVersionColumn is the name of the managed version column unless
+// SQLITE_REST_VERSION_COLUMN names another one
+const defaultVersionColumn = "_version"
+
+// versionColumnName returns the configured name of version columns
+func versionColumnName() string {
+	if name := os.Getenv("SQLITE_REST_VERSION_COLUMN"); name != "" {
+		return name
+	}
+	return defaultVersionColumn
+}
+
+// VersionColumn returns the canonical name of the table's version column,
+// or an empty string when the table has none. Version columns are bumped on
+// every update and are the source of ETags.
+func (t *tableSchema) VersionColumn() string {
+	name, ok := matchIdent(t.ColumnNames(), versionColumnName())
+	if !ok || t.View {
+		return ""
+	}
+	return name
+}
+
+// quotedVersion returns the quoted version column, or an empty string when
+// the table has none
+func (t *tableSchema) quotedVersion() string {
+	version := t.VersionColumn()
+	if version == "" {
+		return ""
+	}
+	return quoteIdent(version)
+}
+
+// setClauses returns the SET clauses of an update of quoted columns,
+// bumping the version column when the table has one
+func (t *tableSchema) setClauses(columns []string) []string {
+	clauses := make([]string, 0, len(columns)+1)
+	for _, column := range columns {
+		clauses = append(clauses, column+" = ?")
+	}
+	if version := t.quotedVersion(); version != "" {
+		clauses = append(clauses, bumpClause(version))
+	}
+	return clauses
+}
+
+// bumpClause returns the SET clause incrementing a quoted version column
+func bumpClause(quoted string) string {
+	return fmt.Sprintf("%s = COALESCE(%s, 0) + 1", quoted, quoted)
+}
+
+// checkManaged rejects writes that set the version column themselves
+func (t *tableSchema) checkManaged(quotedColumns []string) error {
+	if version := t.quotedVersion(); version != "" && containsString(quotedColumns, version) {
+		return &requestError{message: fmt.Sprintf("Column %s is managed by the server and cannot be written", t.VersionColumn())}
+	}
+	return nil
+}
+
+// rowETag computes the strong ETag of a record from its version column, or
+// from every column when the table has none. It returns an empty string
+// when the record does not exist, and for views which have no key.
+func (t *tableSchema) rowETag(q querier, key *recordKey) (string, error) {
+	if t.View {
+		return "", nil
+	}
+
+	selected := "*"
+	if version := t.VersionColumn(); version != "" {
+		selected = quoteIdent(version)
+	}
+
+	keyWhere, keyArgs := key.Where()
+	rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s", selected, t.Quoted(), keyWhere), keyArgs...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return "", err
+	}
+
+	// The table and key are part of the tag so records never share one
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", t.Name, key)
+	for i, value := range values {
+		fmt.Fprintf(h, "\x00%s\x00%T\x00%v", columns[i], value, value)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+// formatETag returns the tag of a record in a response format. Each format
+// is a representation of its own, so other formats than JSON add their name
+// to the tag.
+func formatETag(etag, format string) string {
+	if format == formatJSON {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + format + `"`
+}
+
+// checkIfMatch evaluates the If-Match header of a write on a record. It
+// returns false when the precondition failed, including when the record
+// does not exist.
+func (t *tableSchema) checkIfMatch(q querier, r *http.Request, key *recordKey) (bool, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true, nil
+	}
+	etag, err := t.rowETag(q, key)
+	if err != nil {
+		return false, err
+	}
+	return matchETag(header, etag, false), nil
+}
+
+// matchETag reports whether an If-Match or If-None-Match header matches
+// the ETag of a record, "*" matching any existing record. If-Match uses
+// the strong comparison where weak tags never match, If-None-Match the
+// weak comparison.
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
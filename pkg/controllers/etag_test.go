@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, paw INTEGER);
+		CREATE TABLE dogs (id INTEGER PRIMARY KEY, name TEXT, _version INTEGER NOT NULL DEFAULT 1);
+		INSERT INTO cats VALUES (1, 'Tequila', 4), (2, 'Whisky', 4);
+		INSERT INTO dogs (id, name) VALUES (1, 'Rex');
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table/:id", Get(pool))
+	router.PATCH("/:table", UpdateAll(pool))
+	router.PATCH("/:table/:id", Update(pool))
+	router.PUT("/:table/:id", Replace(pool))
+	router.DELETE("/:table/:id", Delete(pool))
+
+	send := func(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// ETags are strong, stable and distinct between records
+	rr := send("GET", "/cats/1", nil, "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("Expected a strong ETag, got %d %q", rr.Code, etag)
+	}
+	if again := send("GET", "/cats/1?columns=name", nil, "").Header().Get("ETag"); again != etag {
+		t.Errorf("Expected the ETag not to depend on selected columns, got %q and %q", etag, again)
+	}
+	if other := send("GET", "/cats/2", nil, "").Header().Get("ETag"); other == etag {
+		t.Errorf("Expected records with the same contents to have different ETags")
+	}
+
+	// Each format has its own ETag
+	csv := send("GET", "/cats/1", map[string]string{"Accept": "text/csv"}, "")
+	csvETag := csv.Header().Get("ETag")
+	if csvETag == "" || csvETag == etag || csv.Header().Get("Vary") != "Accept" {
+		t.Errorf("Expected a CSV ETag varying on Accept, got %q and %q", csvETag, csv.Header().Get("Vary"))
+	}
+	if rr := send("GET", "/cats/1", map[string]string{"Accept": "text/csv", "If-None-Match": etag}, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the JSON ETag not to match the CSV record, got %d", rr.Code)
+	}
+	if rr := send("GET", "/cats/1", map[string]string{"Accept": "text/csv", "If-None-Match": csvETag}, ""); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for the CSV ETag, got %d", rr.Code)
+	}
+
+	// If-None-Match
+	rr = send("GET", "/cats/1", map[string]string{"If-None-Match": `"other", W/` + etag}, "")
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected status 304 without body, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := send("GET", "/cats/1", map[string]string{"If-None-Match": `"other"`}, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for another ETag, got %d", rr.Code)
+	}
+
+	// If-Match on updates
+	rr = send("PATCH", "/cats/1", map[string]string{"If-Match": etag}, `{"paw": 3}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	newETag := rr.Header().Get("ETag")
+	if newETag == "" || newETag == etag || newETag != send("GET", "/cats/1", nil, "").Header().Get("ETag") {
+		t.Errorf("Expected the update to return the new ETag, got %q", newETag)
+	}
+	rr = send("PATCH", "/cats/1", map[string]string{"If-Match": etag}, `{"paw": 2}`)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for a stale ETag, got %d", rr.Code)
+	}
+	if rr := send("PATCH", "/cats/1", map[string]string{"If-Match": "W/" + newETag}, `{"paw": 2}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected weak ETags not to match If-Match, got %d", rr.Code)
+	}
+	if rr := send("PATCH", "/cats/9", map[string]string{"If-Match": "*"}, `{"paw": 2}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for a missing record, got %d", rr.Code)
+	}
+	if rr := send("PUT", "/cats/1", map[string]string{"If-Match": etag}, `{"name": "Gin"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for a stale replace, got %d", rr.Code)
+	}
+
+	// If-Match on deletes
+	if rr := send("DELETE", "/cats/1", map[string]string{"If-Match": etag}, ""); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for a stale delete, got %d", rr.Code)
+	}
+	if rr := send("DELETE", "/cats/1", map[string]string{"If-Match": `"other", ` + newETag}, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the delete to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Version columns are bumped on every update and cannot be written
+	version := func() int {
+		var v int
+		pool.Reader.QueryRow("SELECT _version FROM dogs WHERE id = 1").Scan(&v)
+		return v
+	}
+	etag = send("GET", "/dogs/1", nil, "").Header().Get("ETag")
+	send("PATCH", "/dogs/1", nil, `{"name": "Max"}`)
+	send("PUT", "/dogs/1", nil, `{"name": "Rex"}`)
+	send("PATCH", "/dogs?name=eq.Rex", nil, `{"name": "Rex"}`)
+	if version() != 4 {
+		t.Errorf("Expected version 4, got %d", version())
+	}
+	if rr := send("PATCH", "/dogs/1", map[string]string{"If-Match": etag}, `{"name": "Max"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for an old version, got %d", rr.Code)
+	}
+	if rr := send("PATCH", "/dogs/1", nil, `{"_version": 1}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when writing the version, got %d", rr.Code)
+	}
+
+	rr = send("PUT", "/dogs/2", nil, `{"name": "Fido"}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("ETag") == "" {
+		t.Errorf("Expected a created record with an ETag, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
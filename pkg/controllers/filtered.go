@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// readOnlyParams are GetAll parameters that have no meaning for writes by
+// filter. They are rejected rather than ignored, so a limit never turns
+// into a write on every matching row.
+var readOnlyParams = []string{"cols", "columns", "count", "cursor", "group_by", "having", "limit", "offset", "order_by", "order_dir"}
+
+// filteredScope is the validated scope of a write on the rows matching the
+// filters of a request
+type filteredScope struct {
+	where       string
+	args        []interface{}
+	maxAffected int64
+}
+
+// parseFilteredScope builds the WHERE clause of a write by filter with the
+// GetAll filter grammar. Writing every row needs all=true, and max_affected
+// caps the number of rows written, -1 when not set.
+func parseFilteredScope(query url.Values, table *tableSchema) (*filteredScope, error) {
+	for _, name := range readOnlyParams {
+		if _, ok := query[name]; ok {
+			return nil, &requestError{message: fmt.Sprintf("The %s parameter is not supported when writing by filter", name)}
+		}
+	}
+
+	// Raw filters are formatted into the statement, which would let a
+	// request run any SQL on the writer
+	if _, ok := query["filters_raw"]; ok {
+		return nil, &requestError{message: "The filters_raw parameter is not supported when writing by filter"}
+	}
+
+	all := false
+	if allParam := query.Get("all"); allParam != "" {
+		var err error
+		all, err = strconv.ParseBool(allParam)
+		if err != nil {
+			return nil, &requestError{message: fmt.Sprintf("Invalid all parameter: %s", allParam)}
+		}
+	}
+
+	scope := &filteredScope{maxAffected: -1}
+	if maxParam := query.Get("max_affected"); maxParam != "" {
+		max, err := strconv.ParseInt(maxParam, 10, 64)
+		if err != nil || max < 0 {
+			return nil, &requestError{message: fmt.Sprintf("Invalid max_affected parameter: %s", maxParam)}
+		}
+		scope.maxAffected = max
+	}
+
+	where, args, err := buildWhere(query, table)
+	if err != nil {
+		return nil, err
+	}
+	if where == "" && !all {
+		return nil, &requestError{message: "Writing by filter requires at least one filter, use all=true to write every row"}
+	}
+	scope.args = args
+	if where != "" {
+		scope.where = "WHERE " + where
+	}
+	return scope, nil
+}
+
+// exec runs a write statement ending with the scope's WHERE clause in a
+// transaction, rolled back when it affects more rows than max_affected.
+// It returns the number of affected rows, and their representation when
+// repr is set.
+func (s *filteredScope) exec(db database, table *tableSchema, statement string, args []interface{}, repr *representation) (int64, []map[string]interface{}, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	affected, rows, err := s.run(tx, table, statement, args, repr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return affected, rows, tx.Commit()
+}
+
+// run runs a write statement ending with the scope's WHERE clause in an
+// open transaction. An error is returned when it affects more rows than
+// max_affected, the caller must then roll the transaction back.
+func (s *filteredScope) run(tx execer, table *tableSchema, statement string, args []interface{}, repr *representation) (int64, []map[string]interface{}, error) {
+	affected, rows, err := table.execReturning(tx, statement+" "+s.where, append(args, s.args...), repr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if s.maxAffected >= 0 && affected > s.maxAffected {
+		return 0, nil, &requestError{message: fmt.Sprintf("The write would affect %d rows, more than max_affected %d. Nothing was changed", affected, s.maxAffected)}
+	}
+	return affected, rows, nil
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestWriteByFilter(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE events (id INTEGER PRIMARY KEY, kind TEXT, created TEXT, archived INTEGER DEFAULT 0);
+		INSERT INTO events (kind, created) VALUES
+			('click', '2024-01-01'), ('click', '2024-02-01'), ('view', '2024-03-01'),
+			('view', '2025-01-01'), ('click', '2025-02-01');
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.PATCH("/:table", UpdateAll(pool))
+	router.DELETE("/:table", DeleteAll(pool))
+
+	send := func(method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	count := func(where string) int {
+		var n int
+		pool.Reader.QueryRow("SELECT COUNT(*) FROM events WHERE " + where).Scan(&n)
+		return n
+	}
+
+	// Update the rows matching column filters
+	rr, response := send("PATCH", "/events?created=lt.2025-01-01", `{"archived": 1}`)
+	if rr.Code != http.StatusOK || response["affected"] != float64(3) {
+		t.Fatalf("Expected 3 updated rows, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if count("archived = 1") != 3 {
+		t.Errorf("Expected 3 archived rows, got %d", count("archived = 1"))
+	}
+
+	// Exceeding max_affected rolls back
+	rr, _ = send("DELETE", "/events?archived=eq.1&max_affected=2", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 over max_affected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if count("1") != 5 {
+		t.Errorf("Expected the delete to be rolled back, got %d rows", count("1"))
+	}
+
+	// Delete with nested groups within the cap
+	rr, response = send("DELETE", "/events?or=(kind.eq.view,created.gte.2025-02-01)&archived=eq.0&max_affected=2", "")
+	if rr.Code != http.StatusOK || response["affected"] != float64(2) {
+		t.Fatalf("Expected 2 deleted rows, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// No matching rows is not an error
+	rr, response = send("DELETE", "/events?kind=eq.scroll", "")
+	if rr.Code != http.StatusOK || response["affected"] != float64(0) {
+		t.Errorf("Expected no deleted rows, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Every row needs all=true
+	rr, _ = send("PATCH", "/events", `{"kind": "other"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without filters, got %d", rr.Code)
+	}
+	rr, response = send("DELETE", "/events?all=true", "")
+	if rr.Code != http.StatusOK || response["affected"] != float64(3) || count("1") != 0 {
+		t.Errorf("Expected every row to be deleted, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	invalid := []struct{ method, path, body string }{
+		{"DELETE", "/events?all=false", ""},
+		{"DELETE", "/events?all=maybe", ""},
+		{"DELETE", "/events?kind=eq.click&limit=1", ""},
+		{"DELETE", "/events?kind=eq.click&max_affected=-1", ""},
+		{"DELETE", "/events?nope=eq.1", ""},
+		{"PATCH", "/events?kind=eq.click", `{}`},
+		{"PATCH", "/events?kind=eq.click", `{"nope": 1}`},
+		{"DELETE", "/events?filters_raw=0)%3B%20DROP%20TABLE%20events%3B%20SELECT%20(1", ""},
+		{"PATCH", "/events?filters_raw=1", `{"kind": "view"}`},
+	}
+	for _, tc := range invalid {
+		if rr, _ := send(tc.method, tc.path, tc.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got status %d, want %d", tc.method, tc.path, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	var tables int
+	pool.Reader.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'events'").Scan(&tables)
+	if tables != 1 {
+		t.Errorf("Expected raw filters not to reach the writer")
+	}
+}
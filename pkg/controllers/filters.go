@@ -0,0 +1,427 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/types"
+)
+
+type Filter struct {
+	Column   string      `json:"column"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+}
+
+// filterOperators lists the comparison operators accepted in the filters
+// parameter. Operators are part of the SQL text, so anything else is rejected.
+var filterOperators = map[string]bool{
+	"=":        true,
+	"==":       true,
+	"!=":       true,
+	"<>":       true,
+	"<":        true,
+	"<=":       true,
+	">":        true,
+	">=":       true,
+	"LIKE":     true,
+	"NOT LIKE": true,
+	"GLOB":     true,
+	"IS":       true,
+	"IS NOT":   true,
+	"IN":       true,
+	"NOT IN":   true,
+}
+
+// reservedParams are query parameters that are not column filters
+var reservedParams = map[string]bool{
+	"all":          true,
+	"cols":         true,
+	"columns":      true,
+	"count":        true,
+	"cursor":       true,
+	"filters":      true,
+	"filters_raw":  true,
+	"format":       true,
+	"group_by":     true,
+	"having":       true,
+	"limit":        true,
+	"max_affected": true,
+	"offset":       true,
+	"order_by":     true,
+	"order_dir":    true,
+	"select":       true,
+}
+
+// logicalParams combine column filters in nested groups
+var logicalParams = map[string]bool{
+	"or":      true,
+	"and":     true,
+	"not.or":  true,
+	"not.and": true,
+}
+
+// comparisonOperators maps the filter grammar operators to SQL
+var comparisonOperators = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// isValues maps the values accepted by the is operator to SQL
+var isValues = map[string]string{
+	"null":    "NULL",
+	"true":    "TRUE",
+	"false":   "FALSE",
+	"unknown": "NULL",
+}
+
+// filtersRawDisabled reports whether the filters_raw parameter is turned off
+// with the SQLITE_REST_DISABLE_FILTERS_RAW environment variable
+func filtersRawDisabled() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv("SQLITE_REST_DISABLE_FILTERS_RAW"))
+	return disabled
+}
+
+// buildWhere combines filters_raw, filters and the column filter grammar of
+// a query string into one condition. It returns an empty condition when the
+// query has no filters.
+func buildWhere(query url.Values, table *tableSchema) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	filtersParam := query.Get("filters_raw")
+	if filtersParam != "" {
+		if filtersRawDisabled() {
+			return "", nil, &requestError{message: "The filters_raw parameter is disabled, use filters or column filters instead"}
+		}
+		unescapedFilters, err := url.QueryUnescape(filtersParam)
+		if err != nil {
+			return "", nil, &requestError{message: fmt.Sprintf("Error unescaping filters_raw: %s", err.Error())}
+		}
+		conditions = append(conditions, "("+unescapedFilters+")")
+	}
+
+	filtersStruct := query.Get("filters")
+	if filtersStruct != "" {
+		if filtersParam != "" {
+			return "", nil, &requestError{message: "Cannot use both filters and filters_raw parameters"}
+		}
+
+		filterArr := []Filter{}
+
+		unescapedFilters, err := url.QueryUnescape(filtersStruct)
+		if err != nil {
+			return "", nil, &requestError{message: fmt.Sprintf("Error unescaping filters: %s", err.Error())}
+		}
+		err = decodeJSON(strings.NewReader(unescapedFilters), &filterArr)
+		if err != nil {
+			return "", nil, &requestError{message: fmt.Sprintf("Invalid filters format: %s", err.Error())}
+		}
+
+		if len(filterArr) > 0 {
+			condition, filterArgs, err := compileFilters(table, filterArr)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, condition)
+			args = append(args, filterArgs...)
+		}
+	}
+
+	condition, grammarArgs, err := compileColumnFilters(query, table)
+	if err != nil {
+		return "", nil, err
+	}
+	if condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, grammarArgs...)
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// compileFilters turns JSON filters into a WHERE condition with bound values
+func compileFilters(table *tableSchema, filters []Filter) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	for _, filter := range filters {
+		column, err := table.QuotedColumn(filter.Column)
+		if err != nil {
+			return "", nil, err
+		}
+
+		operator := strings.ToUpper(strings.Join(strings.Fields(filter.Operator), " "))
+		if !filterOperators[operator] {
+			return "", nil, &requestError{message: fmt.Sprintf("unsupported filter operator: %s", filter.Operator)}
+		}
+
+		if operator == "IN" || operator == "NOT IN" {
+			values, ok := filter.Value.([]interface{})
+			if !ok || len(values) == 0 {
+				return "", nil, &requestError{message: fmt.Sprintf("operator %s requires a non-empty array value", operator)}
+			}
+			for _, v := range values {
+				value, err := bindValue(v)
+				if err != nil {
+					return "", nil, &requestError{message: fmt.Sprintf("filter on %s: %s", filter.Column, err.Error())}
+				}
+				args = append(args, value)
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s (%s)", column, operator, placeholders(len(values))))
+			continue
+		}
+
+		value, err := bindValue(filter.Value)
+		if err != nil {
+			return "", nil, &requestError{message: fmt.Sprintf("filter on %s: %s", filter.Column, err.Error())}
+		}
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s %s ?", column, operator))
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// filterCompiler compiles the column filter grammar, resolving names with
+// column and collecting bound values as it goes
+type filterCompiler struct {
+	column func(name string) (string, error)
+	// affinity returns the affinity of a column that values are bound for,
+	// nil for expressions, which have none
+	affinity func(name string) types.Affinity
+	args     []interface{}
+}
+
+// compileColumnFilters compiles every non reserved query parameter using the
+// column filter grammar:
+//
+//	?age=gte.18&status=in.(active,pending)&name=ilike.*smith*
+//	?deleted_at=is.null&or=(age.lt.18,and(role.eq.admin,active.is.true))
+//
+// Repeated parameters and top level filters are joined with AND.
+func compileColumnFilters(query url.Values, table *tableSchema) (string, []interface{}, error) {
+	compiler := &filterCompiler{column: table.QuotedColumn, affinity: table.columnAffinity}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if !reservedParams[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var conditions []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			var condition string
+			var err error
+			if logicalParams[key] {
+				condition, err = compiler.group(key, value)
+			} else {
+				condition, err = compiler.condition(key, value)
+			}
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), compiler.args, nil
+}
+
+// group compiles "(item,item,...)" joined with the operator of key, which is
+// one of or, and, not.or and not.and
+func (c *filterCompiler) group(key, value string) (string, error) {
+	negate := strings.HasPrefix(key, "not.")
+	joiner := " AND "
+	if strings.TrimPrefix(key, "not.") == "or" {
+		joiner = " OR "
+	}
+
+	if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+		return "", &requestError{message: fmt.Sprintf("Invalid %s filter, expected a parenthesized list: %s", key, value)}
+	}
+
+	items, err := splitTopLevel(value[1 : len(value)-1])
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", &requestError{message: fmt.Sprintf("Empty %s filter", key)}
+	}
+
+	conditions := make([]string, len(items))
+	for i, item := range items {
+		// Nested groups look like and(...), or(...), not.and(...), not.or(...)
+		if open := strings.Index(item, "("); open > 0 && logicalParams[item[:open]] {
+			conditions[i], err = c.group(item[:open], item[open:])
+		} else {
+			column, expr, ok := strings.Cut(item, ".")
+			if !ok {
+				return "", &requestError{message: fmt.Sprintf("Invalid filter in %s: %s", key, item)}
+			}
+			conditions[i], err = c.condition(column, expr)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	condition := "(" + strings.Join(conditions, joiner) + ")"
+	if negate {
+		condition = "NOT " + condition
+	}
+	return condition, nil
+}
+
+// condition compiles one "op.value" or "not.op.value" expression on a column
+func (c *filterCompiler) condition(columnName, expr string) (string, error) {
+	column, err := c.column(columnName)
+	if err != nil {
+		return "", err
+	}
+
+	negate := false
+	if strings.HasPrefix(expr, "not.") {
+		negate = true
+		expr = strings.TrimPrefix(expr, "not.")
+	}
+
+	operator, value, ok := strings.Cut(expr, ".")
+	if !ok {
+		return "", &requestError{message: fmt.Sprintf("Invalid filter on %s, expected operator.value: %s", columnName, expr)}
+	}
+
+	var condition string
+	switch operator {
+	case "eq", "neq", "gt", "gte", "lt", "lte":
+		condition = fmt.Sprintf("%s %s ?", column, comparisonOperators[operator])
+		c.args = append(c.args, c.bind(columnName, value))
+	case "like":
+		// Case sensitive match where * is the only wildcard
+		condition = fmt.Sprintf("%s GLOB ?", column)
+		c.args = append(c.args, likeToGlob(unquoteFilterValue(value)))
+	case "ilike":
+		// Case insensitive match where * is the only wildcard
+		condition = fmt.Sprintf(`%s LIKE ? ESCAPE '\'`, column)
+		c.args = append(c.args, ilikeToLike(unquoteFilterValue(value)))
+	case "in":
+		if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+			return "", &requestError{message: fmt.Sprintf("Invalid in filter on %s, expected a parenthesized list: %s", columnName, value)}
+		}
+		items, err := splitTopLevel(value[1 : len(value)-1])
+		if err != nil {
+			return "", err
+		}
+		if len(items) == 0 {
+			return "", &requestError{message: fmt.Sprintf("Empty in filter on %s", columnName)}
+		}
+		for _, item := range items {
+			c.args = append(c.args, c.bind(columnName, item))
+		}
+		condition = fmt.Sprintf("%s IN (%s)", column, placeholders(len(items)))
+	case "is":
+		keyword, ok := isValues[strings.ToLower(value)]
+		if !ok {
+			return "", &requestError{message: fmt.Sprintf("Invalid is filter on %s: %s. Valid choices: null, true, false, unknown", columnName, value)}
+		}
+		condition = fmt.Sprintf("%s IS %s", column, keyword)
+	default:
+		return "", &requestError{message: fmt.Sprintf("Unknown filter operator on %s: %s. Valid choices: eq, neq, gt, gte, lt, lte, like, ilike, in, is, not", columnName, operator)}
+	}
+
+	if negate {
+		condition = "NOT (" + condition + ")"
+	}
+	return condition, nil
+}
+
+// splitTopLevel splits a comma separated list, ignoring commas inside
+// parentheses and double quoted values
+func splitTopLevel(list string) ([]string, error) {
+	var items []string
+	var current strings.Builder
+	depth := 0
+	quoted := false
+
+	for i := 0; i < len(list); i++ {
+		ch := list[i]
+		switch {
+		case quoted && ch == '\\' && i+1 < len(list):
+			current.WriteByte(ch)
+			i++
+			current.WriteByte(list[i])
+			continue
+		case ch == '"':
+			quoted = !quoted
+		case !quoted && ch == '(':
+			depth++
+		case !quoted && ch == ')':
+			depth--
+			if depth < 0 {
+				return nil, &requestError{message: fmt.Sprintf("Unbalanced parentheses in filter: %s", list)}
+			}
+		case !quoted && depth == 0 && ch == ',':
+			items = append(items, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteByte(ch)
+	}
+
+	if quoted || depth != 0 {
+		return nil, &requestError{message: fmt.Sprintf("Unbalanced quotes or parentheses in filter: %s", list)}
+	}
+	if current.Len() > 0 || len(items) > 0 {
+		items = append(items, current.String())
+	}
+	return items, nil
+}
+
+// bind returns the value bound for a filter value on a column, converted by
+// the column's affinity. Quoted values are always bound as text.
+func (c *filterCompiler) bind(columnName, value string) interface{} {
+	if isQuotedFilterValue(value) {
+		return unquoteFilterValue(value)
+	}
+	affinity := types.AffinityBlob
+	if c.affinity != nil {
+		affinity = c.affinity(columnName)
+	}
+	return affinity.Bind(value)
+}
+
+// isQuotedFilterValue reports whether a filter value is double quoted
+func isQuotedFilterValue(value string) bool {
+	return len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"'
+}
+
+// unquoteFilterValue strips the double quotes used to protect commas and
+// parentheses in list values
+func unquoteFilterValue(value string) string {
+	if isQuotedFilterValue(value) {
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+	}
+	return value
+}
+
+// likeToGlob turns a * wildcard pattern into a GLOB pattern, escaping the
+// other GLOB wildcards
+func likeToGlob(pattern string) string {
+	return strings.NewReplacer("?", "[?]", "[", "[[]").Replace(pattern)
+}
+
+// ilikeToLike turns a * wildcard pattern into a LIKE pattern escaped with \
+func ilikeToLike(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%").Replace(pattern)
+}
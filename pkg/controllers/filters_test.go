@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestColumnFilterGrammar(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, age INTEGER, status TEXT, role TEXT, deleted_at TEXT, score);
+		INSERT INTO people (name, age, status, role, deleted_at, score) VALUES
+			('Ann Smith', 17, 'active', 'user', NULL, 5),
+			('Bob SMITHSON', 34, 'pending', 'admin', NULL, '5'),
+			('Carl Jones', 52, 'banned', 'user', '2024-01-01', 5.5),
+			('Dana, Smith', 41, 'active', 'user', NULL, NULL),
+			('100% Eve', 29, 'active', 'admin', NULL, NULL);
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, tmpFile.Name())))
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"age=gte.41", []string{"Carl Jones", "Dana, Smith"}},
+		{"age=gt.18&age=lt.40", []string{"Bob SMITHSON", "100% Eve"}},
+		{"status=in.(active,pending)&role=eq.admin", []string{"Bob SMITHSON", "100% Eve"}},
+		{"name=in.(\"Dana, Smith\",nobody)", []string{"Dana, Smith"}},
+		{"name=ilike.*smith*", []string{"Ann Smith", "Bob SMITHSON", "Dana, Smith"}},
+		{"name=like.*Smith*", []string{"Ann Smith", "Dana, Smith"}},
+		{"name=ilike.100%25*", []string{"100% Eve"}},
+		{"name=ilike.1_0*", []string{}},
+		{"deleted_at=not.is.null", []string{"Carl Jones"}},
+		{"status=not.eq.active&deleted_at=is.null", []string{"Bob SMITHSON"}},
+		{"or=(age.lt.18,and(role.eq.admin,age.gt.30))", []string{"Ann Smith", "Bob SMITHSON"}},
+		{"not.or=(status.eq.active,status.eq.banned)", []string{"Bob SMITHSON"}},
+		{"or=(name.eq.\"Dana, Smith\",age.eq.52)&status=neq.banned", []string{"Dana, Smith"}},
+		// Columns without a declared type compare numbers as numbers
+		{"score=eq.5", []string{"Ann Smith"}},
+		{"score=eq.\"5\"", []string{"Bob SMITHSON"}},
+		{"score=in.(5.5,7)", []string{"Carl Jones"}},
+		{"age=eq.17&name=eq.\"Ann Smith\"", []string{"Ann Smith"}},
+	}
+
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		req, _ := http.NewRequest("GET", "/people?order_by=id&"+values.Encode(), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", test.query, rr.Code, rr.Body.String())
+			continue
+		}
+
+		var response struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+
+		var names []string
+		for _, row := range response.Data {
+			names = append(names, row["name"].(string))
+		}
+		if len(names) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.query, test.expected, names)
+				break
+			}
+		}
+	}
+
+	invalid := []string{
+		"nope=eq.1",
+		"age=between.1",
+		"age=18",
+		"status=is.maybe",
+		"or=(age.eq.1",
+		"or=age.eq.1",
+		"or=(nope.eq.1)",
+		"status=in.active",
+	}
+
+	for _, query := range invalid {
+		values, _ := url.ParseQuery(query)
+		req, _ := http.NewRequest("GET", "/people?"+values.Encode(), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestFiltersRawCanBeDisabled(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	conn.Exec("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)")
+	conn.Close()
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, tmpFile.Name())))
+
+	t.Setenv("SQLITE_REST_DISABLE_FILTERS_RAW", "true")
+
+	req, _ := http.NewRequest("GET", "/cats?filters_raw="+url.QueryEscape("1=1"), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected filters_raw to be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response formats for reads, chosen with the format parameter or the
+// Accept header
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatTSV    = "tsv"
+	formatNDJSON = "ndjson"
+)
+
+// formatTypes are the media types of the response formats
+var formatTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatCSV:    "text/csv",
+	formatTSV:    "text/tab-separated-values",
+	formatNDJSON: "application/x-ndjson",
+}
+
+// negotiateFormat returns the response format of a read. The format
+// parameter wins over the Accept header, and JSON is used when neither
+// names a supported format.
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		format = strings.ToLower(format)
+		if _, ok := formatTypes[format]; !ok {
+			return "", &identifierError{kind: "format", name: format, valid: []string{formatJSON, formatCSV, formatTSV, formatNDJSON}}
+		}
+		return format, nil
+	}
+
+	// Pick the supported media type with the highest quality, the first
+	// one listed on ties
+	best, bestQuality := formatJSON, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		format := ""
+		for name, formatType := range formatTypes {
+			if mediaType == formatType {
+				format = name
+			}
+		}
+		if mediaType == "application/ndjson" {
+			format = formatNDJSON
+		}
+		if format == "" {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best, nil
+}
+
+// rowWriter writes rows in a streamed format
+type rowWriter interface {
+	Write(row map[string]interface{}) error
+	Flush() error
+}
+
+// csvRowWriter writes CSV or TSV records, columns in result order
+type csvRowWriter struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func (c *csvRowWriter) Write(row map[string]interface{}) error {
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		record[i] = formatCell(row[column])
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvRowWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// ndjsonRowWriter writes one JSON object per line
+type ndjsonRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonRowWriter) Write(row map[string]interface{}) error {
+	return n.encoder.Encode(row)
+}
+
+func (n *ndjsonRowWriter) Flush() error {
+	return nil
+}
+
+// newRowWriter returns the writer of a format. CSV and TSV output starts
+// with a header row of the column names.
+func newRowWriter(w io.Writer, format string, columns []string) (rowWriter, error) {
+	if format == formatNDJSON {
+		return &ndjsonRowWriter{encoder: json.NewEncoder(w)}, nil
+	}
+
+	writer := csv.NewWriter(w)
+	if format == formatTSV {
+		writer.Comma = '\t'
+	} else {
+		// RFC 4180 lines end with CRLF
+		writer.UseCRLF = true
+	}
+	return &csvRowWriter{writer: writer, columns: columns}, writer.Write(columns)
+}
+
+// formatCell formats a decoded value as a CSV or TSV field. Nulls are empty
+// fields and blobs are base64 encoded, as in JSON responses.
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case json.RawMessage:
+		return string(v)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// streamHeaders sets the headers of a response in a streamed format. name
+// is the file name given in Content-Disposition, without extension.
+func streamHeaders(w http.ResponseWriter, format, name string) {
+	contentType := formatTypes[format]
+	if format != formatNDJSON {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+}
+
+// beginStream sends the headers of a streamed response and returns the
+// writer of its rows
+func beginStream(w http.ResponseWriter, format, name string, columns []string) (rowWriter, error) {
+	streamHeaders(w, format, name)
+	w.WriteHeader(http.StatusOK)
+	return newRowWriter(w, format, columns)
+}
+
+// streamFailure is an error met once a streamed response has started, when
+// it can no longer be sent with an error status
+type streamFailure struct {
+	err error
+}
+
+func (f *streamFailure) Error() string {
+	return f.err.Error()
+}
+
+func (f *streamFailure) Unwrap() error {
+	return f.err
+}
+
+// abortStream ends a streamed response that failed. The connection is closed
+// before the final chunk is sent, so that clients see the response cut short
+// rather than complete.
+func abortStream(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	if conn, _, err := hijacker.Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+// streamRows writes the rows of a scanner as they are read, in CSV or TSV
+// with a header row or as one JSON object per line. The first row is read
+// before anything is written so that its errors can still be reported.
+// Failures on later rows are returned as a *streamFailure, for the caller to
+// abort the response.
+func streamRows(w http.ResponseWriter, format, name string, scanner *rowScanner) error {
+	var row map[string]interface{}
+	if scanner.rows.Next() {
+		var err error
+		if row, _, err = scanner.Scan(); err != nil {
+			return err
+		}
+	} else if err := scanner.rows.Err(); err != nil {
+		return err
+	}
+
+	writer, err := beginStream(w, format, name, scanner.Columns())
+	if err != nil {
+		return nil
+	}
+	for row != nil {
+		if writer.Write(row) != nil {
+			return nil
+		}
+		row = nil
+		if scanner.rows.Next() {
+			if row, _, err = scanner.Scan(); err != nil {
+				writer.Flush()
+				return &streamFailure{err: err}
+			}
+		}
+	}
+	writer.Flush()
+	if err := scanner.rows.Err(); err != nil {
+		return &streamFailure{err: err}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		accept string
+		want   string
+	}{
+		{"/cats", "", formatJSON},
+		{"/cats", "*/*", formatJSON},
+		{"/cats", "text/csv", formatCSV},
+		{"/cats", "text/tab-separated-values; charset=utf-8", formatTSV},
+		{"/cats", "application/x-ndjson", formatNDJSON},
+		{"/cats", "application/json;q=0.5, text/csv;q=0.9", formatCSV},
+		{"/cats", "text/html, application/xml", formatJSON},
+		{"/cats?format=TSV", "text/csv", formatTSV},
+	} {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept", tc.accept)
+		if got, err := negotiateFormat(req); err != nil || got != tc.want {
+			t.Errorf("Expected %s for %s with Accept %q, got %s (%v)", tc.want, tc.path, tc.accept, got, err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/cats?format=xml", nil)
+	if _, err := negotiateFormat(req); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestStreamedFormats(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, note TEXT, active BOOLEAN);
+		INSERT INTO cats VALUES (1, 'Tequila', 'says "hi", often', 1), (2, 'Mezcal', NULL, 0)`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table", GetAll(pool))
+	router.GET("/:table/:id", Get(pool))
+	router.OPTIONS("/__/exec", Exec(pool))
+
+	send := func(method, path, accept, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, tc := range []struct {
+		method, path, accept, body string
+		contentType, filename      string
+		want                       string
+	}{
+		{
+			"GET", "/cats?order_by=id", "text/csv", "",
+			"text/csv; charset=utf-8", "cats.csv",
+			"id,name,note,active\r\n1,Tequila,\"says \"\"hi\"\", often\",true\r\n2,Mezcal,,false\r\n",
+		},
+		{
+			"GET", "/cats?order_by=id&limit=1&format=tsv", "", "",
+			"text/tab-separated-values; charset=utf-8", "cats.tsv",
+			"id\tname\tnote\tactive\n1\tTequila\t\"says \"\"hi\"\", often\"\ttrue\n",
+		},
+		{
+			"GET", "/cats?name=eq.Mezcal&cols=id,name", "application/x-ndjson", "",
+			"application/x-ndjson", "cats.ndjson",
+			"{\"id\":2,\"name\":\"Mezcal\"}\n",
+		},
+		{
+			"GET", "/cats/2?columns=name,note", "text/csv", "",
+			"text/csv; charset=utf-8", "cats.csv",
+			"name,note\r\nMezcal,\r\n",
+		},
+		{
+			"OPTIONS", "/__/exec?format=csv", "", `{"query": "SELECT name, active FROM cats WHERE id > ?", "params": [5]}`,
+			"text/csv; charset=utf-8", "query.csv",
+			"name,active\r\n",
+		},
+		{
+			"OPTIONS", "/__/exec", "application/x-ndjson", `{"query": "VALUES (1, 'one')"}`,
+			"application/x-ndjson", "query.ndjson",
+			"{\"column1\":1,\"column2\":\"one\"}\n",
+		},
+	} {
+		rr := send(tc.method, tc.path, tc.accept, tc.body)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s %s: expected status 200, got %d: %s", tc.method, tc.path, rr.Code, rr.Body.String())
+			continue
+		}
+		if got := rr.Header().Get("Content-Type"); got != tc.contentType {
+			t.Errorf("%s %s: expected Content-Type %s, got %s", tc.method, tc.path, tc.contentType, got)
+		}
+		if got := rr.Header().Get("Content-Disposition"); got != "attachment; filename="+tc.filename {
+			t.Errorf("%s %s: expected the %s file name, got %s", tc.method, tc.path, tc.filename, got)
+		}
+		if rr.Body.String() != tc.want {
+			t.Errorf("%s %s: expected body %q, got %q", tc.method, tc.path, tc.want, rr.Body.String())
+		}
+	}
+
+	// Statements returning no rows keep their JSON result
+	rr := send("OPTIONS", "/__/exec", "text/csv", `{"query": "UPDATE cats SET active = 1"}`)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON result for an update, got %d %s: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	// Failures before the first row are still reported as JSON errors
+	for _, tc := range []struct{ method, path, body string }{
+		{"GET", "/cats?format=xml", ""},
+		{"GET", "/cats?format=csv&count=exact", ""},
+		{"GET", "/cats/3?format=csv", ""},
+		{"OPTIONS", "/__/exec?format=csv", `{"query": "SELECT * FROM dogs"}`},
+		{"OPTIONS", "/__/exec?format=csv", `{"query": "SELECT 1; SELECT 2"}`},
+	} {
+		rr := send(tc.method, tc.path, "", tc.body)
+		if rr.Code == http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: expected a JSON error, got %d: %s", tc.method, tc.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Failures after the first row abort the response
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, _ := http.NewRequest("OPTIONS", server.URL+"/__/exec?format=csv", bytes.NewBufferString(`{"query": "SELECT CASE WHEN id = 2 THEN abs(-9223372036854775807 - 1) ELSE id END FROM cats ORDER BY id"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		t.Errorf("Expected the stream to be cut short, got %d: %q", resp.StatusCode, body)
+	}
+}
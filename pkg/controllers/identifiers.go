@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/types"
+)
+
+// columnInfo is a column as reported by PRAGMA table_info
+type columnInfo struct {
+	Name    string
+	Type    string
+	NotNull bool
+	Default interface{}
+	PK      int
+}
+
+// tableSchema holds the validated name and columns of a table or view
+type tableSchema struct {
+	Name    string
+	View    bool
+	Columns []columnInfo
+}
+
+// identifierError is returned when a table or column name does not exist
+type identifierError struct {
+	kind  string
+	name  string
+	valid []string
+}
+
+func (e *identifierError) Error() string {
+	return fmt.Sprintf("Unknown %s: %s. Valid choices: %s", e.kind, e.name, strings.Join(e.valid, ", "))
+}
+
+// requestError is a client error found while building a query
+type requestError struct {
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// quoteIdent quotes an identifier so names with spaces, quotes or reserved
+// words can be used safely in SQL
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// querier runs queries on a database or in a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// listRelations returns the names of all user tables and views
+func listRelations(db querier) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// readTableInfo reads the columns of a table with PRAGMA table_info
+func readTableInfo(db querier, tableName string) ([]columnInfo, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(tableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []columnInfo
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name string
+		var dataType sql.NullString
+		var dfltValue interface{}
+
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, columnInfo{
+			Name:    name,
+			Type:    dataType.String,
+			NotNull: notNull == 1,
+			Default: dfltValue,
+			PK:      pk,
+		})
+	}
+
+	return columns, rows.Err()
+}
+
+// resolveTable checks a table name against sqlite_master and loads its columns
+func resolveTable(db querier, name string) (*tableSchema, error) {
+	names, err := listRelations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	canonical, ok := matchIdent(names, name)
+	if !ok {
+		return nil, &identifierError{kind: "table", name: name, valid: names}
+	}
+
+	var relationType string
+	if err := db.QueryRow("SELECT type FROM sqlite_master WHERE name = ?", canonical).Scan(&relationType); err != nil {
+		return nil, err
+	}
+
+	columns, err := readTableInfo(db, canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableSchema{Name: canonical, View: relationType == "view", Columns: columns}, nil
+}
+
+// matchIdent finds name in names, falling back to a case-insensitive match
+// as SQLite identifiers are case-insensitive
+func matchIdent(names []string, name string) (string, bool) {
+	for _, n := range names {
+		if n == name {
+			return n, true
+		}
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// Quoted returns the quoted table name
+func (t *tableSchema) Quoted() string {
+	return quoteIdent(t.Name)
+}
+
+// ColumnNames returns the names of all columns in declaration order
+func (t *tableSchema) ColumnNames() []string {
+	names := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Column returns the canonical name of a column
+func (t *tableSchema) Column(name string) (string, error) {
+	canonical, ok := matchIdent(t.ColumnNames(), strings.TrimSpace(name))
+	if !ok {
+		return "", &identifierError{kind: "column", name: name, valid: t.ColumnNames()}
+	}
+	return canonical, nil
+}
+
+// columnAffinity returns the affinity of a column, BLOB for unknown names
+func (t *tableSchema) columnAffinity(name string) types.Affinity {
+	canonical, err := t.Column(name)
+	if err != nil {
+		return types.AffinityBlob
+	}
+	return types.AffinityOf(t.columnType(canonical))
+}
+
+// QuotedColumn validates a column name and returns it quoted
+func (t *tableSchema) QuotedColumn(name string) (string, error) {
+	canonical, err := t.Column(name)
+	if err != nil {
+		return "", err
+	}
+	return quoteIdent(canonical), nil
+}
+
+// SelectList validates a comma separated list of columns and returns the
+// quoted select list. An empty list or "*" selects every column.
+func (t *tableSchema) SelectList(list string) (string, error) {
+	list = strings.TrimSpace(list)
+	if list == "" || list == "*" {
+		return "*", nil
+	}
+
+	var quoted []string
+	for _, name := range strings.Split(list, ",") {
+		column, err := t.QuotedColumn(name)
+		if err != nil {
+			return "", err
+		}
+		quoted = append(quoted, column)
+	}
+
+	return strings.Join(quoted, ", "), nil
+}
+
+// orderTerm is a validated ORDER BY column and its direction, "ASC", "DESC"
+// or empty for the default
+type orderTerm struct {
+	Column    string
+	Direction string
+}
+
+// Quoted returns the quoted column, rowid is a keyword and left as is
+func (o orderTerm) Quoted() string {
+	if o.Column == rowidColumn {
+		return rowidColumn
+	}
+	return quoteIdent(o.Column)
+}
+
+// Desc reports whether the column is sorted in descending order
+func (o orderTerm) Desc() bool {
+	return o.Direction == "DESC"
+}
+
+// OrderTerms validates a comma separated list of order_by columns and their
+// order_dir directions. A single direction applies to every column.
+func (t *tableSchema) OrderTerms(orderBy, orderDir string) ([]orderTerm, error) {
+	return parseOrderTerms(orderBy, orderDir, t.Column)
+}
+
+// parseOrderTerms parses order_by and order_dir, resolving names with column
+func parseOrderTerms(orderBy, orderDir string, column func(name string) (string, error)) ([]orderTerm, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+
+	columns := strings.Split(orderBy, ",")
+	var directions []string
+	if strings.TrimSpace(orderDir) != "" {
+		directions = strings.Split(orderDir, ",")
+	}
+	if len(directions) > 1 && len(directions) != len(columns) {
+		return nil, &requestError{message: "order_dir must have one direction or one per order_by column"}
+	}
+
+	terms := make([]orderTerm, len(columns))
+	for i, name := range columns {
+		name, err := column(name)
+		if err != nil {
+			return nil, err
+		}
+
+		direction := ""
+		if len(directions) == 1 {
+			direction = directions[0]
+		} else if len(directions) > 1 {
+			direction = directions[i]
+		}
+		direction = strings.ToUpper(strings.TrimSpace(direction))
+
+		switch direction {
+		case "", "ASC", "DESC":
+			terms[i] = orderTerm{Column: name, Direction: direction}
+		default:
+			return nil, &identifierError{kind: "order_dir", name: direction, valid: []string{"asc", "desc"}}
+		}
+	}
+
+	return terms, nil
+}
+
+// OrderBy builds the ORDER BY clause for order_by and order_dir
+func (t *tableSchema) OrderBy(orderBy, orderDir string) (string, error) {
+	terms, err := t.OrderTerms(orderBy, orderDir)
+	if err != nil {
+		return "", err
+	}
+	return orderByClause(terms), nil
+}
+
+// orderByClause renders order terms, or nothing when there are none
+func orderByClause(terms []orderTerm) string {
+	if len(terms) == 0 {
+		return ""
+	}
+
+	rendered := make([]string, len(terms))
+	for i, term := range terms {
+		rendered[i] = term.Quoted()
+		if term.Direction != "" {
+			rendered[i] += " " + term.Direction
+		}
+	}
+	return "ORDER BY " + strings.Join(rendered, ", ")
+}
+
+// sendResolveError reports a failed identifier lookup, unknown names are
+// client errors while anything else is a database failure
+func sendResolveError(w http.ResponseWriter, err error) {
+	var identErr *identifierError
+	var reqErr *requestError
+	if errors.As(err, &identErr) || errors.As(err, &reqErr) {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendJSONError(w, fmt.Sprintf("Database error: %s", err.Error()), http.StatusInternalServerError)
+}
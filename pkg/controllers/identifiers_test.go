@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	tests := map[string]string{
+		"cats":        `"cats"`,
+		"order items": `"order items"`,
+		`we"ird`:      `"we""ird"`,
+	}
+
+	for input, expected := range tests {
+		if got := quoteIdent(input); got != expected {
+			t.Errorf("quoteIdent(%q) = %s, want %s", input, got, expected)
+		}
+	}
+}
+
+func TestIdentifierResolution(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`CREATE TABLE "order items" (id INTEGER PRIMARY KEY, "order" INTEGER, "group" TEXT)`)
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	conn.Close()
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, tmpFile.Name())))
+	router.POST("/:table", Create(openTestPool(t, tmpFile.Name())))
+
+	// Reserved words and spaces work once quoted
+	for _, body := range []string{`{"order": 2, "group": "b"}`, `{"order": 1, "group": "a"}`} {
+		req, _ := http.NewRequest("POST", "/"+url.PathEscape("order items"), bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Create returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/"+url.PathEscape("order items")+"?cols=group,order&order_by=order&order_dir=desc", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GetAll returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	data := response["data"].([]interface{})
+	if first := data[0].(map[string]interface{}); first["group"] != "b" {
+		t.Errorf("Expected rows ordered by order desc, got %v", data)
+	}
+
+	// Unknown identifiers are rejected with the valid choices
+	tests := []struct {
+		path     string
+		contains string
+	}{
+		{"/cats", "order items"},
+		{"/" + url.PathEscape("order items") + "?cols=" + url.QueryEscape("id;DROP"), "group"},
+		{"/" + url.PathEscape("order items") + "?order_by=" + url.QueryEscape("id; DROP TABLE x"), "order"},
+		{"/" + url.PathEscape("order items") + "?order_by=id&order_dir=sideways", "asc"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", test.path, rr.Code, http.StatusBadRequest)
+		}
+		if !strings.Contains(rr.Body.String(), test.contains) {
+			t.Errorf("%s: expected error to list %q, got %s", test.path, test.contains, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/types"
+)
+
+// rowidColumn is used as the key of tables without a declared primary key
+const rowidColumn = "rowid"
+
+// recordKey is a parsed primary key value from the URL
+type recordKey struct {
+	Columns []string
+	Values  []interface{}
+}
+
+// PrimaryKey returns the primary key columns in key order, or rowid when the
+// table does not declare one
+func (t *tableSchema) PrimaryKey() []string {
+	var pk []columnInfo
+	for _, c := range t.Columns {
+		if c.PK > 0 {
+			pk = append(pk, c)
+		}
+	}
+	if len(pk) == 0 {
+		return []string{rowidColumn}
+	}
+
+	sort.Slice(pk, func(i, j int) bool { return pk[i].PK < pk[j].PK })
+
+	names := make([]string, len(pk))
+	for i, c := range pk {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// HasRowidKey reports whether the primary key is the rowid, either
+// implicitly or through an INTEGER PRIMARY KEY alias
+func (t *tableSchema) HasRowidKey() bool {
+	pk := t.PrimaryKey()
+	if len(pk) != 1 {
+		return false
+	}
+	if pk[0] == rowidColumn {
+		return true
+	}
+	return strings.EqualFold(t.columnType(pk[0]), "INTEGER")
+}
+
+// columnType returns the declared type of a column
+func (t *tableSchema) columnType(name string) string {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c.Type
+		}
+	}
+	return ""
+}
+
+// keyParam returns the :id route parameter as it was sent, still percent
+// encoded, so that an escaped "%2C" in a key part is not taken for the comma
+// separating composite key parts. id is the decoded parameter.
+func keyParam(r *http.Request, id string) string {
+	raw := r.URL.EscapedPath()
+	raw = raw[strings.LastIndex(raw, "/")+1:]
+	if decoded, err := url.PathUnescape(raw); err == nil && decoded == id {
+		return raw
+	}
+	return strings.ReplaceAll(id, "%", "%25")
+}
+
+// ParseKey parses the :id route parameter, as returned by keyParam, into
+// primary key values. Composite keys are given in key order separated by
+// commas ("k1,k2") or as matrix parameters naming the key columns
+// ("col1=k1;col2=k2"). Each part is percent-decoded after splitting, so parts
+// containing a separator are written with it escaped ("a%2Cb,k2"). Values of
+// integer columns are bound as integers, everything else is bound as text.
+func (t *tableSchema) ParseKey(param string) (*recordKey, error) {
+	pk := t.PrimaryKey()
+	key := &recordKey{Columns: pk, Values: make([]interface{}, len(pk))}
+
+	var parts []string
+	if found, ok := matrixKey(param, pk); ok {
+		// Matrix parameters name each key column
+		for _, column := range pk {
+			value, ok := found[column]
+			if !ok {
+				return nil, &requestError{message: fmt.Sprintf("Missing key column %s. Key columns: %s", column, strings.Join(pk, ", "))}
+			}
+			parts = append(parts, value)
+		}
+	} else if len(pk) == 1 {
+		// A single column key is taken as is so text keys may contain commas
+		parts = []string{param}
+	} else {
+		parts = strings.Split(param, ",")
+		if len(parts) != len(pk) {
+			return nil, &requestError{message: fmt.Sprintf("Expected %d key values for columns %s, got %d", len(pk), strings.Join(pk, ", "), len(parts))}
+		}
+	}
+
+	for i, part := range parts {
+		decoded, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, &requestError{message: fmt.Sprintf("Invalid key encoding: %s", part)}
+		}
+		parts[i] = decoded
+	}
+
+	for i, column := range pk {
+		if column == rowidColumn || types.AffinityOf(t.columnType(column)) == types.AffinityInteger {
+			if n, err := strconv.ParseInt(parts[i], 10, 64); err == nil {
+				key.Values[i] = n
+				continue
+			}
+			if column == rowidColumn {
+				return nil, &requestError{message: fmt.Sprintf("Invalid ID format: %s", parts[i])}
+			}
+		}
+		key.Values[i] = parts[i]
+	}
+
+	return key, nil
+}
+
+// matrixKey reads a key given as matrix parameters ("col1=k1;col2=k2"). It
+// is only taken as such when every parameter names a key column, so that
+// text keys containing "=" can be addressed as they are.
+func matrixKey(param string, pk []string) (map[string]string, bool) {
+	found := make(map[string]string)
+	for _, pair := range strings.Split(param, ";") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, false
+		}
+		if decoded, err := url.PathUnescape(name); err == nil {
+			name = decoded
+		}
+		column, ok := matchIdent(pk, name)
+		if !ok {
+			return nil, false
+		}
+		if _, dup := found[column]; dup {
+			return nil, false
+		}
+		found[column] = value
+	}
+	return found, true
+}
+
+// Where returns the condition matching the key and its bound values
+func (k *recordKey) Where() (string, []interface{}) {
+	conditions := make([]string, len(k.Columns))
+	for i, column := range k.Columns {
+		if column == rowidColumn {
+			conditions[i] = rowidColumn + " = ?"
+		} else {
+			conditions[i] = quoteIdent(column) + " = ?"
+		}
+	}
+	return strings.Join(conditions, " AND "), k.Values
+}
+
+// Value returns the key as returned in responses: the value itself for
+// single column keys, or an object of column to value for composite keys.
+// An unknown key is nil.
+func (k *recordKey) Value() interface{} {
+	if k == nil {
+		return nil
+	}
+	if len(k.Columns) == 1 {
+		return k.Values[0]
+	}
+	value := make(map[string]interface{}, len(k.Columns))
+	for i, column := range k.Columns {
+		value[column] = k.Values[i]
+	}
+	return value
+}
+
+// Path formats the key as the :id route parameter. Each part is escaped,
+// separators included, so that ParseKey reads it back.
+func (k *recordKey) Path() string {
+	parts := make([]string, len(k.Values))
+	for i, v := range k.Values {
+		// "=" is escaped as well so a key is not read as matrix parameters
+		parts[i] = strings.ReplaceAll(url.PathEscape(fmt.Sprint(v)), "=", "%3D")
+	}
+	return strings.Join(parts, ",")
+}
+
+// Complete reports whether every key column has a value. SQLite allows NULL
+// in the key columns of most tables, and such rows cannot be addressed.
+func (k *recordKey) Complete() bool {
+	if k == nil {
+		return false
+	}
+	for _, v := range k.Values {
+		if v == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the key for error messages
+func (k *recordKey) String() string {
+	parts := make([]string, len(k.Values))
+	for i, v := range k.Values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// insertedKey returns the rowid key of a row from the last inserted rowid.
+// Other keys are read back with a RETURNING clause.
+func (t *tableSchema) insertedKey(lastInsertID int64) *recordKey {
+	return &recordKey{Columns: t.PrimaryKey(), Values: []interface{}{lastInsertID}}
+}
+
+// keyFromData returns the key held by the values of a row, or nil when a
+// key column is missing
+func (t *tableSchema) keyFromData(data map[string]interface{}) *recordKey {
+	pk := t.PrimaryKey()
+	key := &recordKey{Columns: pk, Values: make([]interface{}, len(pk))}
+
+	for i, column := range pk {
+		found := false
+		for name, value := range data {
+			if strings.EqualFold(name, column) {
+				key.Values[i], _ = bindValue(value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	return key
+}
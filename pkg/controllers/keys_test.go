@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPrimaryKeyRoutes(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE users (uuid TEXT PRIMARY KEY, name TEXT);
+		CREATE TABLE products (sku TEXT PRIMARY KEY, name TEXT) WITHOUT ROWID;
+		CREATE TABLE stock (region TEXT, sku TEXT, qty INTEGER, PRIMARY KEY (region, sku));
+		CREATE TABLE notes (body TEXT);
+		CREATE TABLE settings (name TEXT PRIMARY KEY, value TEXT);
+		INSERT INTO settings VALUES ('a=b', 'equals key'), ('name=c', 'column equals key');
+		INSERT INTO users VALUES ('007', 'bond'), ('7', 'seven');
+		INSERT INTO products VALUES ('A,1', 'comma key');
+		INSERT INTO stock VALUES ('eu', 'A1', 5), ('us', 'A1', 7), ('eu,west', 'A1', 3), ('100%', 'A1', 1);
+		INSERT INTO notes VALUES ('first'), ('second');
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table/:id", Get(pool))
+	router.PATCH("/:table/:id", Update(pool))
+	router.DELETE("/:table/:id", Delete(pool))
+
+	gets := []struct {
+		path     string
+		column   string
+		expected interface{}
+	}{
+		{"/users/007", "name", "bond"},
+		{"/users/7", "name", "seven"},
+		{"/products/A,1", "name", "comma key"},
+		{"/stock/us,A1", "qty", float64(7)},
+		{"/stock/sku=A1;region=eu", "qty", float64(5)},
+		{"/notes/2", "body", "second"},
+		{"/settings/a=b", "value", "equals key"},
+		{"/settings/name=a=b", "value", "equals key"},
+		{"/settings/name%3Dc", "value", "column equals key"},
+		{"/stock/eu%2Cwest,A1", "qty", float64(3)},
+		{"/stock/region=eu,west;sku=A1", "qty", float64(3)},
+		{"/stock/100%25,A1", "qty", float64(1)},
+	}
+
+	for _, test := range gets {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", test.path, rr.Code, rr.Body.String())
+			continue
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		data := response["data"].(map[string]interface{})
+		if data[test.column] != test.expected {
+			t.Errorf("%s: expected %s = %v, got %v", test.path, test.column, test.expected, data[test.column])
+		}
+	}
+
+	// Composite keys are echoed back as objects
+	req, _ := http.NewRequest("PATCH", "/stock/eu,A1", bytes.NewBufferString(`{"qty": 6}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Update returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if id, ok := response["id"].(map[string]interface{}); !ok || id["region"] != "eu" || id["sku"] != "A1" {
+		t.Errorf("Expected composite key in response, got %v", response["id"])
+	}
+
+	// Text keys are never parsed as integers
+	req, _ = http.NewRequest("DELETE", "/users/007", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Delete returned %d: %s", rr.Code, rr.Body.String())
+	}
+	req, _ = http.NewRequest("GET", "/users/7", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Deleting '007' removed '7' as well")
+	}
+
+	// Malformed keys are client errors
+	for _, path := range []string{"/stock/eu", "/stock/region=eu", "/notes/abc", "/stock/region=eu;color=red", "/stock/eu,west,A1"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", path, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Count methods accepted by the count parameter
+const (
+	countExact     = "exact"
+	countEstimated = "estimated"
+)
+
+// parseCountMethod validates the count parameter. An empty method means the
+// total is not requested.
+func parseCountMethod(method string) (string, error) {
+	switch strings.ToLower(method) {
+	case "":
+		return "", nil
+	case countExact:
+		return countExact, nil
+	case countEstimated:
+		return countEstimated, nil
+	}
+	return "", &identifierError{kind: "count", name: method, valid: []string{countExact, countEstimated}}
+}
+
+// countRows returns the total number of rows matching conditions. Estimated
+// counts are read from sqlite_stat1 for unfiltered queries and fall back to an
+// exact count when the statistics are missing or a filter is applied. The
+// returned method is the one actually used.
+func countRows(db querier, table *tableSchema, method, conditions string, args []interface{}) (int64, string, error) {
+	if method == countEstimated && conditions == "" {
+		if estimate, ok := estimateRows(db, table.Name); ok {
+			return estimate, countEstimated, nil
+		}
+	}
+
+	query := "SELECT COUNT(*) FROM " + table.Quoted()
+	if conditions != "" {
+		query += " WHERE " + conditions
+	}
+
+	var count int64
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		return 0, "", err
+	}
+	return count, countExact, nil
+}
+
+// estimateRows reads the row count of a table gathered by ANALYZE. The first
+// number of each sqlite_stat1 entry is the number of rows in the table or
+// index, partial indexes may cover fewer rows so the largest is used.
+func estimateRows(db querier, table string) (int64, bool) {
+	rows, err := db.Query("SELECT stat FROM sqlite_stat1 WHERE tbl = ?", table)
+	if err != nil {
+		// sqlite_stat1 only exists once ANALYZE has been run
+		return 0, false
+	}
+	defer rows.Close()
+
+	var estimate int64
+	found := false
+	for rows.Next() {
+		var stat string
+		if err := rows.Scan(&stat); err != nil {
+			return 0, false
+		}
+		fields := strings.Fields(stat)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if !found || n > estimate {
+			estimate = n
+		}
+		found = true
+	}
+	if rows.Err() != nil {
+		return 0, false
+	}
+
+	return estimate, found
+}
+
+// contentRange formats the Content-Range header of a page of rows starting
+// at offset. The total is * when it was not counted.
+func contentRange(offset int64, rows int, total *int64) string {
+	size := "*"
+	if total != nil {
+		size = strconv.FormatInt(*total, 10)
+	}
+	if rows == 0 {
+		return "*/" + size
+	}
+	return fmt.Sprintf("%d-%d/%s", offset, offset+int64(rows)-1, size)
+}
+
+// paginationLinks builds the RFC 8288 Link header values pointing to the
+// first, previous, next and last pages. The last page is only linked when
+// the total is known, otherwise a next page is assumed while pages are full.
+func paginationLinks(r *http.Request, offset, limit int64, rows int, total *int64) []string {
+	if limit <= 0 {
+		return nil
+	}
+
+	link := func(rel string, offset int64) string {
+		query := r.URL.Query()
+		query.Set("offset", strconv.FormatInt(offset, 10))
+		query.Set("limit", strconv.FormatInt(limit, 10))
+		target := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", target.String(), rel)
+	}
+
+	links := []string{link("first", 0)}
+
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", prev))
+	}
+
+	next := offset + int64(rows)
+	if total != nil {
+		if next < *total {
+			links = append(links, link("next", offset+limit))
+		}
+		last := int64(0)
+		if *total > 0 {
+			last = (*total - 1) / limit * limit
+		}
+		links = append(links, link("last", last))
+	} else if int64(rows) == limit {
+		links = append(links, link("next", offset+limit))
+	}
+
+	return links
+}
+
+// cursorLinks builds the Link header values of a page fetched with a cursor,
+// pointing back to the first page and on to the next one
+func cursorLinks(r *http.Request, nextCursor string) []string {
+	link := func(rel, cursor string) string {
+		query := r.URL.Query()
+		query.Del("cursor")
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		target := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", target.String(), rel)
+	}
+
+	links := []string{link("first", "")}
+	if nextCursor != "" {
+		links = append(links, link("next", nextCursor))
+	}
+	return links
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGetAllCount(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	conn.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, paw INTEGER)")
+	for i := 1; i <= 53; i++ {
+		conn.Exec("INSERT INTO items (paw) VALUES (?)", i%4)
+	}
+	conn.Close()
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table", GetAll(pool))
+
+	get := func(query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/items?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// Without count the total is unknown
+	rr, response := get("limit=10&offset=20")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := response["total_count"]; ok {
+		t.Errorf("Expected no total_count without count parameter")
+	}
+	if got := rr.Header().Get("Content-Range"); got != "20-29/*" {
+		t.Errorf("Expected Content-Range 20-29/*, got %s", got)
+	}
+	links := rr.Header().Get("Link")
+	for _, expected := range []string{
+		`</items?limit=10&offset=0>; rel="first"`,
+		`</items?limit=10&offset=10>; rel="prev"`,
+		`</items?limit=10&offset=30>; rel="next"`,
+	} {
+		if !strings.Contains(links, expected) {
+			t.Errorf("Expected Link header to contain %s, got %s", expected, links)
+		}
+	}
+	if strings.Contains(links, `rel="last"`) {
+		t.Errorf("Expected no last link without a total, got %s", links)
+	}
+
+	// Exact count
+	rr, response = get("count=exact&limit=25&offset=25")
+	if response["total_count"] != float64(53) || response["count_method"] != "exact" {
+		t.Errorf("Expected exact total_count 53, got %v (%v)", response["total_count"], response["count_method"])
+	}
+	if response["total_rows"] != float64(25) {
+		t.Errorf("Expected total_rows 25, got %v", response["total_rows"])
+	}
+	if got := rr.Header().Get("Content-Range"); got != "25-49/53" {
+		t.Errorf("Expected Content-Range 25-49/53, got %s", got)
+	}
+	links = rr.Header().Get("Link")
+	for _, expected := range []string{
+		`</items?count=exact&limit=25&offset=50>; rel="next"`,
+		`</items?count=exact&limit=25&offset=50>; rel="last"`,
+	} {
+		if !strings.Contains(links, expected) {
+			t.Errorf("Expected Link header to contain %s, got %s", expected, links)
+		}
+	}
+
+	// The count uses the same filters as the page
+	rr, response = get("count=exact&paw=eq.0&limit=5")
+	if response["total_count"] != float64(13) {
+		t.Errorf("Expected filtered total_count 13, got %v", response["total_count"])
+	}
+	if got := rr.Header().Get("Content-Range"); got != "0-4/13" {
+		t.Errorf("Expected Content-Range 0-4/13, got %s", got)
+	}
+
+	// The last page has no next link
+	rr, _ = get("count=exact&limit=25&offset=50")
+	if strings.Contains(rr.Header().Get("Link"), `rel="next"`) {
+		t.Errorf("Expected no next link on the last page, got %s", rr.Header().Get("Link"))
+	}
+
+	// Empty pages
+	rr, _ = get("count=exact&paw=eq.9")
+	if got := rr.Header().Get("Content-Range"); got != "*/0" {
+		t.Errorf("Expected Content-Range */0, got %s", got)
+	}
+
+	// Estimated counts fall back to exact before ANALYZE
+	_, response = get("count=estimated")
+	if response["total_count"] != float64(53) || response["count_method"] != "exact" {
+		t.Errorf("Expected exact fallback, got %v (%v)", response["total_count"], response["count_method"])
+	}
+
+	// Estimated counts read sqlite_stat1 once ANALYZE has been run
+	if _, err := pool.Writer.Exec("CREATE INDEX items_paw ON items (paw); ANALYZE; UPDATE sqlite_stat1 SET stat = '1000 250' WHERE tbl = 'items'"); err != nil {
+		t.Fatalf("Failed to analyze: %v", err)
+	}
+	_, response = get("count=estimated")
+	if response["total_count"] != float64(1000) || response["count_method"] != "estimated" {
+		t.Errorf("Expected estimated total_count 1000, got %v (%v)", response["total_count"], response["count_method"])
+	}
+
+	// Filtered estimates are counted exactly
+	_, response = get("count=estimated&paw=eq.0")
+	if response["total_count"] != float64(13) {
+		t.Errorf("Expected filtered total_count 13, got %v", response["total_count"])
+	}
+
+	rr, _ = get("count=planned")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown count method, got %d", rr.Code)
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	total := int64(1130)
+	tests := []struct {
+		offset   int64
+		rows     int
+		total    *int64
+		expected string
+	}{
+		{0, 25, &total, "0-24/1130"},
+		{1125, 5, &total, "1125-1129/1130"},
+		{0, 0, &total, "*/1130"},
+		{10, 10, nil, "10-19/*"},
+	}
+
+	for _, test := range tests {
+		got := contentRange(test.offset, test.rows, test.total)
+		if got != test.expected {
+			t.Errorf("contentRange(%d, %d): expected %s, got %s", test.offset, test.rows, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// defaultExecActions are the actions allowed to /__/exec statements when no
+// policy is configured. Deleting rows and altering or dropping tables are
+// denied, as is attaching other databases.
+var defaultExecActions = []string{db.ActionRead, db.ActionInsert, db.ActionUpdate, db.ActionCreate, db.ActionPragma, db.ActionFunction}
+
+// actionAliases name groups of actions in policies
+var actionAliases = map[string][]string{
+	"all":    db.Actions,
+	"schema": {db.ActionCreate, db.ActionAlter, db.ActionDrop},
+}
+
+// dangerousOpActions maps the first word of the SQLITE_REST_DANGEROUS_OPS
+// entries to the action they deny
+var dangerousOpActions = map[string]string{
+	"SELECT":   db.ActionRead,
+	"INSERT":   db.ActionInsert,
+	"UPDATE":   db.ActionUpdate,
+	"DELETE":   db.ActionDelete,
+	"TRUNCATE": db.ActionDelete,
+	"CREATE":   db.ActionCreate,
+	"ALTER":    db.ActionAlter,
+	"DROP":     db.ActionDrop,
+	"ATTACH":   db.ActionAttach,
+	"DETACH":   db.ActionAttach,
+	"PRAGMA":   db.ActionPragma,
+}
+
+// execPolicy is the set of actions the statements of /__/exec and batches
+// may perform, per table. Tables without their own entry, and actions on
+// the whole database, use the default entry.
+type execPolicy struct {
+	defaults map[string]bool
+	tables   map[string]map[string]bool
+}
+
+// allows reports whether the policy allows an action
+func (p *execPolicy) allows(a db.Authorization) bool {
+	actions := p.defaults
+	if table, ok := p.tables[strings.ToLower(a.Table)]; ok && a.Table != "" {
+		actions = table
+	}
+	return actions[a.Action]
+}
+
+// actionSet validates a list of action names and aliases
+func actionSet(names []string) (map[string]bool, error) {
+	actions := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := actionAliases[name]; ok {
+			for _, action := range alias {
+				actions[action] = true
+			}
+			continue
+		}
+		if !containsString(db.Actions, name) {
+			return nil, fmt.Errorf("unknown action %q, must be one of: %s, all, schema", name, strings.Join(db.Actions, ", "))
+		}
+		actions[name] = true
+	}
+	return actions, nil
+}
+
+// loadExecPolicy reads the policy from SQLITE_REST_EXEC_POLICY, a JSON
+// object of table names to allowed actions with "*" for the default entry.
+// Without it, the operations listed in SQLITE_REST_DANGEROUS_OPS are denied,
+// and else the default actions are allowed.
+func loadExecPolicy() (*execPolicy, error) {
+	policy := &execPolicy{tables: make(map[string]map[string]bool)}
+
+	if raw := os.Getenv("SQLITE_REST_EXEC_POLICY"); raw != "" {
+		entries := make(map[string][]string)
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			return nil, fmt.Errorf("invalid SQLITE_REST_EXEC_POLICY: %s", err.Error())
+		}
+		if _, ok := entries["*"]; !ok {
+			entries["*"] = defaultExecActions
+		}
+		for table, names := range entries {
+			actions, err := actionSet(names)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SQLITE_REST_EXEC_POLICY entry %q: %s", table, err.Error())
+			}
+			if table == "*" {
+				policy.defaults = actions
+			} else {
+				policy.tables[strings.ToLower(table)] = actions
+			}
+		}
+		return policy, nil
+	}
+
+	if ops, ok := os.LookupEnv("SQLITE_REST_DANGEROUS_OPS"); ok {
+		policy.defaults, _ = actionSet(db.Actions)
+		for _, op := range strings.Split(ops, ",") {
+			fields := strings.Fields(strings.ToUpper(op))
+			if len(fields) > 0 {
+				delete(policy.defaults, dangerousOpActions[fields[0]])
+			}
+		}
+		return policy, nil
+	}
+
+	policy.defaults, _ = actionSet(defaultExecActions)
+	return policy, nil
+}
+
+// schemaPragmas are the pragmas reads run to learn the schema
+var schemaPragmas = map[string]bool{"table_info": true, "table_xinfo": true, "foreign_key_list": true, "index_list": true, "index_info": true}
+
+// readOnly allows the actions of statements that only read. It guards reads
+// running on a writable connection, where a raw filter could otherwise
+// write.
+func readOnly(a db.Authorization) bool {
+	switch a.Action {
+	case db.ActionRead, db.ActionFunction:
+		return true
+	case db.ActionPragma:
+		return schemaPragmas[strings.ToLower(a.Object)]
+	}
+	return false
+}
+
+// runAuthorized runs fn with the policy enforced on the statements it
+// prepares on the connection of target. A statement denied by the policy fails
+// with a 403 error naming the denied action.
+func runAuthorized(target database, policy *execPolicy, fn func() error) error {
+	conn := target.conn()
+	if conn == nil {
+		return errors.New("statements must run on a single connection to be authorized")
+	}
+
+	var denied *db.Authorization
+	err := db.SetAuthorizer(conn, func(a db.Authorization) bool {
+		if policy.allows(a) {
+			return true
+		}
+		if denied == nil {
+			denied = &a
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	defer db.SetAuthorizer(conn, nil)
+
+	err = fn()
+	if err != nil && denied != nil {
+		return &statusError{status: http.StatusForbidden, message: fmt.Sprintf("Query not allowed: %s", denied)}
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// representation is the select list of the rows sent back by a write
+type representation struct {
+	columns string
+}
+
+// Representation reads the Prefer: return=representation header and the
+// select parameter of a write. It returns nil when the write only returns
+// keys. Without select, every column is returned.
+func (t *tableSchema) Representation(r *http.Request) (*representation, error) {
+	selectParam := r.URL.Query().Get("select")
+	if selectParam == "" && preferences(r)["return"] != "representation" {
+		return nil, nil
+	}
+
+	columns, err := t.SelectList(selectParam)
+	if err != nil {
+		return nil, err
+	}
+	return &representation{columns: columns}, nil
+}
+
+// returning builds the RETURNING clause of a write: the representation
+// columns, when set, followed by the key columns hidden from the response
+func (t *tableSchema) returning(repr *representation) string {
+	var selected []string
+	if repr != nil {
+		selected = append(selected, repr.columns)
+	}
+	for i, column := range t.quotedKey() {
+		selected = append(selected, fmt.Sprintf(`+%s AS "__key_%d"`, column, i))
+	}
+	return "RETURNING " + strings.Join(selected, ", ")
+}
+
+// scanReturning reads the rows sent back by a write with a returning
+// clause and their keys
+func (t *tableSchema) scanReturning(rows *sql.Rows) ([]map[string]interface{}, []*recordKey, error) {
+	pk := t.PrimaryKey()
+	data, hidden, err := scanAll(rows, len(pk))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]*recordKey, len(hidden))
+	for i, values := range hidden {
+		keys[i] = &recordKey{Columns: pk, Values: values}
+	}
+	return data, keys, nil
+}
+
+// execer runs statements on a database or in a transaction
+type execer interface {
+	querier
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// execReturning runs a write and returns the number of rows it affected,
+// and their representation when repr is set
+func (t *tableSchema) execReturning(e execer, statement string, args []interface{}, repr *representation) (int64, []map[string]interface{}, error) {
+	if repr == nil {
+		result, err := e.Exec(statement, args...)
+		if err != nil {
+			return 0, nil, err
+		}
+		affected, err := result.RowsAffected()
+		return affected, nil, err
+	}
+
+	rows, err := e.Query(statement+" "+t.returning(repr), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	data, _, err := t.scanReturning(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+	if data == nil {
+		data = []map[string]interface{}{}
+	}
+	return int64(len(data)), data, nil
+}
+
+// location returns the path of a record, sent in the Location header of
+// created records
+func (t *tableSchema) location(key *recordKey) string {
+	return "/" + url.PathEscape(t.Name) + "/" + key.Path()
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestWriteRepresentation(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`
+		CREATE TABLE cats (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			paw INTEGER DEFAULT 4,
+			indoor BOOLEAN DEFAULT 1,
+			slug TEXT GENERATED ALWAYS AS (lower(name)) VIRTUAL,
+			updated INTEGER DEFAULT 0
+		);
+		CREATE TRIGGER cats_updated AFTER UPDATE OF name ON cats BEGIN
+			UPDATE cats SET updated = updated + 1 WHERE id = NEW.id;
+		END;
+		CREATE TABLE stock (shop TEXT, sku TEXT, qty INTEGER, PRIMARY KEY (shop, sku));
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.POST("/:table", Create(pool))
+	router.PATCH("/:table", UpdateAll(pool))
+	router.PATCH("/:table/:id", Update(pool))
+	router.PUT("/:table/:id", Replace(pool))
+	router.DELETE("/:table", DeleteAll(pool))
+	router.DELETE("/:table/:id", Delete(pool))
+
+	send := func(method, path, prefer, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if prefer != "" {
+			req.Header.Set("Prefer", prefer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// Creates return defaults and generated columns with their types
+	rr, response := send("POST", "/cats", "return=representation", `{"name": "Tequila"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if location := rr.Header().Get("Location"); location != "/cats/1" {
+		t.Errorf("Expected Location /cats/1, got %q", location)
+	}
+	row := response["data"].(map[string]interface{})
+	if row["id"] != float64(1) || row["paw"] != float64(4) || row["indoor"] != true || row["slug"] != "tequila" {
+		t.Errorf("Unexpected created row: %v", row)
+	}
+	if _, ok := row["__key_0"]; ok {
+		t.Errorf("Expected the key columns to be hidden, got %v", row)
+	}
+
+	// Without a preference only the key is returned
+	rr, response = send("POST", "/cats", "", `{"name": "Whisky"}`)
+	if _, ok := response["data"]; ok || rr.Header().Get("Location") != "/cats/2" {
+		t.Errorf("Expected no representation, got %s", rr.Body.String())
+	}
+
+	// select picks the returned columns, including for bulk payloads
+	rr, response = send("POST", "/cats?select=id,name", "", `[{"name": "Rhum"}, {"name": "Gin"}]`)
+	rows := response["data"].([]interface{})
+	if rr.Code != http.StatusCreated || len(rows) != 2 || rows[1].(map[string]interface{})["name"] != "Gin" || len(rows[1].(map[string]interface{})) != 2 {
+		t.Errorf("Unexpected bulk representation: %s", rr.Body.String())
+	}
+
+	// Updates return the selected columns
+	rr, response = send("PATCH", "/cats/1?select=name,updated", "", `{"name": "Mezcal"}`)
+	row = response["data"].(map[string]interface{})
+	if rr.Code != http.StatusOK || row["name"] != "Mezcal" || len(row) != 2 {
+		t.Errorf("Unexpected updated row: %s", rr.Body.String())
+	}
+
+	rr, response = send("PATCH", "/cats?id=gte.3", "return=representation", `{"paw": 3}`)
+	rows = response["data"].([]interface{})
+	if rr.Code != http.StatusOK || len(rows) != 2 || rows[0].(map[string]interface{})["paw"] != float64(3) {
+		t.Errorf("Unexpected filtered update representation: %s", rr.Body.String())
+	}
+
+	// Composite keys in Location
+	rr, _ = send("POST", "/stock", "", `{"shop": "paris", "sku": "A 1", "qty": 2}`)
+	if location := rr.Header().Get("Location"); location != "/stock/paris,A%201" {
+		t.Errorf("Expected a composite Location, got %q", location)
+	}
+	rr, _ = send("POST", "/stock", "", `{"shop": "lyon,2e", "sku": "A1", "qty": 3}`)
+	location := rr.Header().Get("Location")
+	if location != "/stock/lyon%2C2e,A1" {
+		t.Errorf("Expected the comma in a key part escaped, got %q", location)
+	}
+	rr, response = send("PATCH", location, "return=representation", `{"qty": 4}`)
+	if rr.Code != http.StatusOK || response["data"].(map[string]interface{})["shop"] != "lyon,2e" {
+		t.Errorf("Expected the Location to address the row, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr, response = send("PUT", "/stock/lyon,B2", "return=representation", `{"qty": 7}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/stock/lyon,B2" || response["data"].(map[string]interface{})["qty"] != float64(7) {
+		t.Errorf("Unexpected replace representation %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Deletes return the deleted rows
+	rr, response = send("DELETE", "/cats/2", "return=representation", "")
+	if rr.Code != http.StatusOK || response["data"].(map[string]interface{})["name"] != "Whisky" {
+		t.Errorf("Unexpected deleted row: %s", rr.Body.String())
+	}
+	rr, response = send("DELETE", "/cats?paw=eq.3&select=id", "", "")
+	if rows := response["data"].([]interface{}); rr.Code != http.StatusOK || len(rows) != 2 || response["affected"] != float64(2) {
+		t.Errorf("Unexpected filtered delete representation: %s", rr.Body.String())
+	}
+	rr, response = send("DELETE", "/cats?paw=eq.3&select=id", "", "")
+	if rows, ok := response["data"].([]interface{}); rr.Code != http.StatusOK || !ok || len(rows) != 0 {
+		t.Errorf("Expected an empty representation, got %s", rr.Body.String())
+	}
+
+	if rr, _ := send("POST", "/cats?select=nope", "", `{"name": "Sake"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown column, got %d", rr.Code)
+	}
+	if rr, _ := send("DELETE", "/cats/9", "return=representation", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing record, got %d", rr.Code)
+	}
+}
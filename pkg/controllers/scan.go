@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"database/sql"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/types"
+)
+
+// rowScanner reads result rows into maps typed by the codecs of the
+// declared column types. The last hidden columns carry keys that are not
+// part of the response, they are scanned as stored and returned separately.
+type rowScanner struct {
+	rows    *sql.Rows
+	columns []string
+	codecs  []types.Codec
+	visible int
+}
+
+// newRowScanner prepares a scanner for rows ending with hidden columns
+func newRowScanner(rows *sql.Rows, hidden int) (*rowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowScanner{rows: rows, columns: columns, codecs: types.Codecs(columnTypes), visible: len(columns) - hidden}, nil
+}
+
+// Columns returns the names of the visible columns
+func (s *rowScanner) Columns() []string {
+	return s.columns[:s.visible]
+}
+
+// Scan reads the current row, returning its visible columns and the values
+// of the hidden ones
+func (s *rowScanner) Scan() (map[string]interface{}, []interface{}, error) {
+	// Values are scanned with the storage class SQLite holds them in
+	values := make([]interface{}, len(s.columns))
+	columnPtrs := make([]interface{}, len(s.columns))
+	for i := range values {
+		columnPtrs[i] = &values[i]
+	}
+
+	if err := s.rows.Scan(columnPtrs...); err != nil {
+		return nil, nil, err
+	}
+
+	// Compose row data map
+	rowData := make(map[string]interface{}, s.visible)
+	for i, columnKey := range s.columns[:s.visible] {
+		rowData[columnKey] = s.codecs[i].Decode(values[i])
+	}
+
+	return rowData, values[s.visible:], nil
+}
+
+// scanAll reads every remaining row
+func scanAll(rows *sql.Rows, hidden int) ([]map[string]interface{}, [][]interface{}, error) {
+	scanner, err := newRowScanner(rows, hidden)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data []map[string]interface{}
+	var keys [][]interface{}
+	for rows.Next() {
+		rowData, rowKeys, err := scanner.Scan()
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, rowData)
+		keys = append(keys, rowKeys)
+	}
+
+	return data, keys, rows.Err()
+}
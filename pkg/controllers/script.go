@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"strings"
+)
+
+// splitScript splits a SQL script into its statements at the semicolons
+// outside string literals, quoted identifiers and comments. As with
+// sqlite3_complete, a CREATE TRIGGER statement only ends at a semicolon
+// following END. Statements holding only comments are dropped.
+func splitScript(script string) []string {
+	var statements []string
+	var words []string
+	var start int
+	hasToken := false
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		isSpace := c == ' ' || c == '\t' || c == '\n' || c == '\r'
+		isComment := i+1 < len(script) && (c == '-' && script[i+1] == '-' || c == '/' && script[i+1] == '*')
+		if !hasToken && !isSpace && !isComment && c != ';' {
+			// Leading comments are not part of the statement
+			hasToken = true
+			start = i
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(script[i+1:], closing)
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 1
+			}
+			words = append(words, "")
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+			}
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case isWordByte(c):
+			j := i
+			for j < len(script) && isWordByte(script[j]) {
+				j++
+			}
+			words = append(words, strings.ToUpper(script[i:j]))
+			i = j - 1
+		case c == ';':
+			if isTriggerStatement(words) && words[len(words)-1] != "END" {
+				continue
+			}
+			if hasToken {
+				statements = append(statements, strings.TrimSpace(script[start:i]))
+			}
+			words = words[:0]
+			hasToken = false
+		}
+	}
+
+	if hasToken {
+		statements = append(statements, strings.TrimSpace(script[start:]))
+	}
+	return statements
+}
+
+// isWordByte reports whether c is part of a keyword or identifier
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// isTriggerStatement reports whether the words of a statement begin a
+// CREATE [TEMP] TRIGGER statement
+func isTriggerStatement(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TEMP" || words[1] == "TEMPORARY" {
+		return len(words) > 2 && words[2] == "TRIGGER"
+	}
+	return words[1] == "TRIGGER"
+}
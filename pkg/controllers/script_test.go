@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitScript(t *testing.T) {
+	tests := map[string][]string{
+		"SELECT 1":                         {"SELECT 1"},
+		"SELECT 1; SELECT 2;":              {"SELECT 1", "SELECT 2"},
+		"SELECT 'a;b'; SELECT \"c;d\"":     {"SELECT 'a;b'", "SELECT \"c;d\""},
+		"SELECT 'it''s; fine'":             {"SELECT 'it''s; fine'"},
+		"SELECT [a;b], `c;d`":              {"SELECT [a;b], `c;d`"},
+		"-- first\nSELECT 1; -- a;b\n":     {"SELECT 1"},
+		"/* x; y */ SELECT 1 /* ; */; ;;":  {"SELECT 1 /* ; */"},
+		"  ;  -- only comments\n /* ; */ ": nil,
+		"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = n + 1; DELETE FROM c; END; SELECT 1": {
+			"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = n + 1; DELETE FROM c; END",
+			"SELECT 1",
+		},
+		"create temp trigger t after delete on a begin select 1; end": {
+			"create temp trigger t after delete on a begin select 1; end",
+		},
+	}
+
+	for script, expected := range tests {
+		if statements := splitScript(script); !reflect.DeepEqual(statements, expected) {
+			t.Errorf("splitScript(%q) = %q, expected %q", script, statements, expected)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// transactionHeader names the interactive transaction a request runs in
+const transactionHeader = "X-Transaction-Id"
+
+// transaction is the transaction of a handler: a database transaction, or
+// a savepoint inside an interactive transaction
+type transaction interface {
+	execer
+	Prepare(query string) (*sql.Stmt, error)
+	Commit() error
+	Rollback() error
+}
+
+// database runs the statements of a handler, on a pool or inside an
+// interactive transaction
+type database interface {
+	execer
+	Prepare(query string) (*sql.Stmt, error)
+	Begin() (transaction, error)
+	// conn returns the connection the statements run on, nil when they run
+	// on any connection of a pool
+	conn() *sql.Conn
+}
+
+// pooled runs statements on a connection pool
+type pooled struct {
+	*sql.DB
+}
+
+func (p pooled) Begin() (transaction, error) {
+	return p.DB.Begin()
+}
+
+func (p pooled) conn() *sql.Conn {
+	return nil
+}
+
+// pinned runs statements on a connection held for the whole request
+type pinned struct {
+	*sql.Conn
+}
+
+func (p pinned) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return p.Conn.QueryContext(context.Background(), query, args...)
+}
+
+func (p pinned) QueryRow(query string, args ...interface{}) *sql.Row {
+	return p.Conn.QueryRowContext(context.Background(), query, args...)
+}
+
+func (p pinned) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return p.Conn.ExecContext(context.Background(), query, args...)
+}
+
+func (p pinned) Prepare(query string) (*sql.Stmt, error) {
+	return p.Conn.PrepareContext(context.Background(), query)
+}
+
+func (p pinned) Begin() (transaction, error) {
+	return p.Conn.BeginTx(context.Background(), nil)
+}
+
+func (p pinned) conn() *sql.Conn {
+	return p.Conn
+}
+
+// inSession runs statements inside an interactive transaction, where the
+// transactions of handlers are savepoints
+type inSession struct {
+	*sql.Tx
+	session *db.Session
+}
+
+func (s inSession) conn() *sql.Conn {
+	return s.session.Conn
+}
+
+func (s inSession) Begin() (transaction, error) {
+	if _, err := s.Tx.Exec("SAVEPOINT sqlite_rest_request"); err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: s.Tx, name: "sqlite_rest_request"}, nil
+}
+
+// savepoint is a transaction nested in an interactive transaction
+type savepoint struct {
+	*sql.Tx
+	name string
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Tx.Exec("RELEASE " + s.name)
+	return err
+}
+
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	if _, err := s.Tx.Exec("ROLLBACK TO " + s.name); err != nil {
+		return err
+	}
+	_, err := s.Tx.Exec("RELEASE " + s.name)
+	return err
+}
+
+// openDatabase returns where a request runs its statements: the interactive
+// transaction named by its X-Transaction-Id header, or else the writer
+// connection or the reader pool. Reads in an interactive transaction may not
+// write. The returned function must be called once the request is done. On
+// failure the error is sent and ok is false.
+func openDatabase(w http.ResponseWriter, r *http.Request, pool *db.Pool, write bool) (database, func(), bool) {
+	id := r.Header.Get(transactionHeader)
+	if id == "" {
+		if !write {
+			return pooled{pool.Reader}, func() {}, true
+		}
+		conn, err := pool.Writer.Conn(context.Background())
+		if err != nil {
+			sendJSONError(w, fmt.Sprintf("Error opening connection: %s", err.Error()), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		return pinned{conn}, func() { conn.Close() }, true
+	}
+
+	session, err := pool.AcquireSession(id)
+	if err != nil {
+		sendSessionError(w, id, err)
+		return nil, nil, false
+	}
+	if write {
+		return inSession{Tx: session.Tx, session: session}, session.Release, true
+	}
+
+	// Reads share the writable transaction, so their statements are kept
+	// from writing until the request is done
+	if err := db.SetAuthorizer(session.Conn, readOnly); err != nil {
+		session.Release()
+		sendSessionError(w, id, err)
+		return nil, nil, false
+	}
+	release := func() {
+		db.SetAuthorizer(session.Conn, nil)
+		session.Release()
+	}
+	return inSession{Tx: session.Tx, session: session}, release, true
+}
+
+// sendSessionError sends the error of a request on an interactive transaction
+func sendSessionError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, db.ErrSessionNotFound) {
+		sendJSONError(w, fmt.Sprintf("Transaction %s not found or expired", id), http.StatusNotFound)
+		return
+	}
+	sendJSONError(w, fmt.Sprintf("Error in transaction %s: %s", id, err.Error()), http.StatusInternalServerError)
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// streamThreshold is how many bytes of a JSON response are buffered before
+// it is streamed. Responses that fit are sent whole with their headers.
+const streamThreshold = 64 << 10
+
+// jsonStream writes a JSON object holding an array of rows as the rows are
+// scanned. The array comes first and the other fields follow it, so that
+// counts and cursors known only after the last row can be written:
+//
+//	{"data":[{...},{...}],"status":"success","total_rows":2}
+//
+// Output is buffered until it outgrows streamThreshold, after which it is
+// sent with chunked transfer encoding, and headers set by Close can only be
+// sent as trailers. A failure after streaming started closes the array and
+// ends the object with the error fields in place of the others:
+//
+//	{"data":[{...}],"status":"error","message":"...","code":500}
+type jsonStream struct {
+	w         http.ResponseWriter
+	buf       bytes.Buffer
+	trailers  []string
+	rows      int
+	streaming bool
+	held      bool
+}
+
+// newJSONStream starts an object whose rows go in field. trailers lists the
+// headers that Close may set, declared as trailers when streaming.
+func newJSONStream(w http.ResponseWriter, field string, trailers ...string) *jsonStream {
+	s := &jsonStream{w: w, trailers: trailers}
+	name, _ := json.Marshal(field)
+	s.buf.WriteByte('{')
+	s.buf.Write(name)
+	s.buf.WriteString(":[")
+	return s
+}
+
+// Rows returns the number of rows written
+func (s *jsonStream) Rows() int {
+	return s.rows
+}
+
+// Hold keeps the whole response buffered, for responses with headers that
+// cannot be sent as trailers
+func (s *jsonStream) Hold() {
+	s.held = true
+}
+
+// Write adds a row to the array
+func (s *jsonStream) Write(row map[string]interface{}) error {
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if s.rows > 0 {
+		s.buf.WriteByte(',')
+	}
+	s.buf.Write(encoded)
+	s.rows++
+
+	if s.held || s.buf.Len() < streamThreshold {
+		return nil
+	}
+	if !s.streaming {
+		if len(s.trailers) > 0 {
+			s.w.Header().Set("Trailer", strings.Join(s.trailers, ", "))
+		}
+		s.w.Header().Set("Content-Type", "application/json")
+		s.w.WriteHeader(http.StatusOK)
+		s.streaming = true
+	}
+	return s.flush()
+}
+
+// flush sends the buffered output to the client
+func (s *jsonStream) flush() error {
+	_, err := s.w.Write(s.buf.Bytes())
+	s.buf.Reset()
+	if flusher, ok := s.w.(http.Flusher); ok && err == nil {
+		flusher.Flush()
+	}
+	return err
+}
+
+// Close ends the array, writes the fields after it and sends headers. When
+// streaming only the headers declared as trailers are sent, as trailers.
+// Empty header values are not sent.
+func (s *jsonStream) Close(fields map[string]interface{}, headers map[string]string) {
+	if err := s.closeArray(fields); err != nil {
+		s.Fail(fmt.Sprintf("Error encoding response: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	for name, value := range headers {
+		if value != "" && (!s.streaming || containsString(s.trailers, name)) {
+			s.w.Header().Set(name, value)
+		}
+	}
+	if !s.streaming {
+		s.w.Header().Set("Content-Type", "application/json")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	s.flush()
+}
+
+// closeArray ends the array and the object with fields
+func (s *jsonStream) closeArray(fields map[string]interface{}) error {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	s.buf.WriteByte(']')
+	if len(encoded) > 2 {
+		s.buf.WriteByte(',')
+	}
+	s.buf.Write(encoded[1:])
+	s.buf.WriteByte('\n')
+	return nil
+}
+
+// Fail reports an error, as a JSON error response when nothing was sent yet
+// and in band otherwise
+func (s *jsonStream) Fail(message string, code int) {
+	if !s.streaming {
+		sendJSONError(s.w, message, code)
+		return
+	}
+	s.closeArray(map[string]interface{}{"status": "error", "message": message, "code": code})
+	s.flush()
+}
+
+// Abort returns err when nothing was sent yet, so the caller can report it,
+// and otherwise ends the stream with it in band
+func (s *jsonStream) Abort(err error) error {
+	if !s.streaming {
+		return err
+	}
+	s.Fail(err.Error(), http.StatusInternalServerError)
+	return nil
+}
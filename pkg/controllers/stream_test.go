@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestJSONStream(t *testing.T) {
+	row := map[string]interface{}{"name": strings.Repeat("x", 1000)}
+
+	// Small responses are sent whole with their headers
+	rr := httptest.NewRecorder()
+	stream := newJSONStream(rr, "data", "Content-Range")
+	stream.Write(row)
+	stream.Close(map[string]interface{}{"status": "success"}, map[string]string{"Content-Range": "0-0/*"})
+	if rr.Header().Get("Trailer") != "" || rr.Header().Get("Content-Range") != "0-0/*" {
+		t.Errorf("Expected the headers of a whole response, got %v", rr.Header())
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response["status"] != "success" || len(response["data"].([]interface{})) != 1 {
+		t.Errorf("Expected one row, got %s (%v)", rr.Body.String(), err)
+	}
+
+	// Failures before streaming are plain error responses
+	rr = httptest.NewRecorder()
+	stream = newJSONStream(rr, "data")
+	stream.Write(row)
+	stream.Fail("Something broke", http.StatusBadRequest)
+	if rr.Code != http.StatusBadRequest || strings.Contains(rr.Body.String(), "data") {
+		t.Errorf("Expected a plain error response, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Failures while streaming end the object in band
+	rr = httptest.NewRecorder()
+	stream = newJSONStream(rr, "data")
+	for i := 0; i < 100; i++ {
+		stream.Write(row)
+	}
+	stream.Fail("Something broke", http.StatusInternalServerError)
+	response = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a valid JSON body, got %v", err)
+	}
+	if rr.Code != http.StatusOK || response["status"] != "error" || response["message"] != "Something broke" || response["code"] != float64(500) {
+		t.Errorf("Expected an in band error, got %d: %v", rr.Code, response)
+	}
+	if rows := response["data"].([]interface{}); len(rows) != 100 {
+		t.Errorf("Expected the 100 rows sent before the error, got %d", len(rows))
+	}
+}
+
+func TestGetAllStreamsLargeResults(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`CREATE TABLE logs (id INTEGER PRIMARY KEY, msg TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 5000)
+		INSERT INTO logs (msg) SELECT printf('message %d with some padding to make the rows longer', i) FROM n`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table", GetAll(pool))
+	router.OPTIONS("/__/exec", Exec(pool))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/logs?limit=4000")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected a chunked response, got %v", resp.TransferEncoding)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Expected a valid JSON body, got %v", err)
+	}
+	if response["status"] != "success" || response["total_rows"] != float64(4000) || len(response["data"].([]interface{})) != 4000 {
+		t.Errorf("Expected 4000 rows, got status %v, total_rows %v", response["status"], response["total_rows"])
+	}
+	if response["next_cursor"] == nil {
+		t.Errorf("Expected a next cursor after a full page")
+	}
+	if !strings.Contains(resp.Trailer.Get("Link"), `rel="next"`) {
+		t.Errorf("Expected a next Link trailer, got %q", resp.Trailer.Get("Link"))
+	}
+
+	// Counted pages are held so that Content-Range is sent as a header
+	resp, err = http.Get(server.URL + "/logs?limit=4000&count=exact")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	response = nil
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Expected a valid JSON body, got %v", err)
+	}
+	if resp.Header.Get("Content-Range") != "0-3999/5000" || !strings.Contains(resp.Header.Get("Link"), `rel="next"`) {
+		t.Errorf("Expected the range and links as headers, got %q and %q", resp.Header.Get("Content-Range"), resp.Header.Get("Link"))
+	}
+	if response["total_rows"] != float64(4000) || response["total_count"] != float64(5000) {
+		t.Errorf("Expected 4000 of 5000 rows, got total_rows %v, total_count %v", response["total_rows"], response["total_count"])
+	}
+
+	// Query results are streamed the same way
+	req, _ := http.NewRequest("OPTIONS", server.URL+"/__/exec", strings.NewReader(`{"query": "SELECT * FROM logs"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	response = nil
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Expected a valid JSON body, got %v", err)
+	}
+	if len(resp.TransferEncoding) == 0 || response["status"] != "success" || response["count"] != float64(5000) || response["type"] != "select" {
+		t.Errorf("Expected 5000 streamed rows, got %v %v %v", resp.TransferEncoding, response["status"], response["count"])
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
 // ErrorResponse represents a standardized error response
@@ -14,6 +15,12 @@ type ErrorResponse struct {
 
 // sendJSONError sends a JSON-formatted error response
 func sendJSONError(w http.ResponseWriter, message string, statusCode int) {
+	// A write that waited busy_timeout on the lock of another writer, such
+	// as an interactive transaction that wrote, may succeed when retried
+	if statusCode == http.StatusInternalServerError && isLockedError(message) {
+		statusCode = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "1")
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	
@@ -25,3 +32,9 @@ func sendJSONError(w http.ResponseWriter, message string, statusCode int) {
 	
 	json.NewEncoder(w).Encode(response)
 }
+
+// isLockedError reports whether an error message is SQLite's SQLITE_BUSY or
+// SQLITE_LOCKED, returned when a lock stays held past busy_timeout
+func isLockedError(message string) bool {
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
+}
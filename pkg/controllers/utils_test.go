@@ -0,0 +1,17 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/paradoxe35/sqlite-rest/pkg/db"
+)
+
+// openTestPool opens a connection pool on dbPath that is closed with the test
+func openTestPool(t testing.TB, dbPath string) *db.Pool {
+	pool, err := db.NewPool(dbPath, db.DefaultPoolConfig())
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// blobKey is the object key used in request bodies to send binary data,
+// e.g. {"avatar": {"$base64": "iVBORw0KGgo="}}
+const blobKey = "$base64"
+
+// decodeJSON decodes a request body keeping numbers as json.Number so that
+// integers and floats can be told apart when binding them
+func decodeJSON(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// bindValue converts a decoded JSON value into a value that database/sql can
+// bind as a parameter, so SQLite stores it with the right affinity
+func bindValue(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case string, bool, int64, float64:
+		return value, nil
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", value.String())
+		}
+		return f, nil
+	case map[string]interface{}:
+		// Binary data is sent as {"$base64": "..."}
+		if encoded, ok := value[blobKey]; ok && len(value) == 1 {
+			s, ok := encoded.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s value must be a string", blobKey)
+			}
+			blob, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value: %s", blobKey, err.Error())
+			}
+			return blob, nil
+		}
+		return marshalJSONValue(value)
+	case []interface{}:
+		return marshalJSONValue(value)
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// marshalJSONValue stores nested objects and arrays as JSON text
+func marshalJSONValue(v interface{}) (string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// bindRow splits a decoded JSON object into column names and bound values.
+// Columns are sorted so the generated SQL is stable between requests.
+func bindRow(data map[string]interface{}) ([]string, []interface{}, error) {
+	columnNames := make([]string, 0, len(data))
+	for k := range data {
+		columnNames = append(columnNames, k)
+	}
+	sort.Strings(columnNames)
+
+	values := make([]interface{}, len(columnNames))
+	for i, column := range columnNames {
+		value, err := bindValue(data[column])
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %s", column, err.Error())
+		}
+		values[i] = value
+	}
+
+	return columnNames, values, nil
+}
+
+// placeholders returns n comma separated bind parameters
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// setupBindingDB creates a temporary database with a table covering every
+// storage class and returns its path
+func setupBindingDB(t *testing.T) string {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpFile.Close()
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	conn, err := sql.Open("sqlite3", tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL, qty INTEGER, active BOOLEAN, data BLOB, note TEXT)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	return tmpFile.Name()
+}
+
+func TestCreateBindsHostilePayloads(t *testing.T) {
+	dbPath := setupBindingDB(t)
+
+	router := httprouter.New()
+	router.POST("/:table", Create(openTestPool(t, dbPath)))
+
+	payloads := []string{
+		`{"name": "Robert'); DROP TABLE items;--"}`,
+		`{"name": "he said \"hi\" and 'bye'"}`,
+		`{"name": "\", 1); DELETE FROM items; --"}`,
+		`{"name": "back\\slash \u0000 nul"}`,
+	}
+
+	for _, payload := range payloads {
+		req, _ := http.NewRequest("POST", "/items", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Payload %s: got status %d: %s", payload, rr.Code, rr.Body.String())
+		}
+
+		var expected map[string]interface{}
+		json.Unmarshal([]byte(payload), &expected)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+
+		conn, _ := sql.Open("sqlite3", dbPath)
+		var name string
+		err := conn.QueryRow("SELECT name FROM items WHERE id = ?", response["id"]).Scan(&name)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("Payload %s: failed to read back row: %v", payload, err)
+		}
+		if name != expected["name"] {
+			t.Errorf("Payload %s: stored %q, want %q", payload, name, expected["name"])
+		}
+	}
+
+	conn, _ := sql.Open("sqlite3", dbPath)
+	defer conn.Close()
+	var count int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil {
+		t.Fatalf("Table was damaged by payloads: %v", err)
+	}
+	if count != len(payloads) {
+		t.Errorf("Expected %d rows, got %d", len(payloads), count)
+	}
+}
+
+func TestCreateBindsTypedValues(t *testing.T) {
+	dbPath := setupBindingDB(t)
+
+	router := httprouter.New()
+	router.POST("/:table", Create(openTestPool(t, dbPath)))
+
+	payload := `{"name": "typed", "price": 3.141592653589793, "qty": 9007199254740993, "active": true, "data": {"$base64": "AAEC/w=="}, "note": null}`
+	req, _ := http.NewRequest("POST", "/items", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Handler returned wrong status code: got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	conn, _ := sql.Open("sqlite3", dbPath)
+	defer conn.Close()
+
+	var price float64
+	var qty int64
+	var data []byte
+	var priceType, qtyType, activeType, dataType, noteType string
+	err := conn.QueryRow("SELECT price, qty, data, typeof(price), typeof(qty), typeof(active), typeof(data), typeof(note) FROM items").
+		Scan(&price, &qty, &data, &priceType, &qtyType, &activeType, &dataType, &noteType)
+	if err != nil {
+		t.Fatalf("Failed to read back row: %v", err)
+	}
+
+	if price != 3.141592653589793 {
+		t.Errorf("Float lost precision: got %v", price)
+	}
+	if qty != 9007199254740993 {
+		t.Errorf("Integer lost precision: got %v", qty)
+	}
+	if !bytes.Equal(data, []byte{0x00, 0x01, 0x02, 0xff}) {
+		t.Errorf("Blob was not decoded: got %v", data)
+	}
+
+	expectedTypes := map[string]string{"price": "real", "qty": "integer", "active": "integer", "data": "blob", "note": "null"}
+	actualTypes := map[string]string{"price": priceType, "qty": qtyType, "active": activeType, "data": dataType, "note": noteType}
+	for column, expected := range expectedTypes {
+		if actualTypes[column] != expected {
+			t.Errorf("Column %s stored as %s, want %s", column, actualTypes[column], expected)
+		}
+	}
+}
+
+func TestUpdateBindsHostilePayloads(t *testing.T) {
+	dbPath := setupBindingDB(t)
+
+	conn, _ := sql.Open("sqlite3", dbPath)
+	defer conn.Close()
+	conn.Exec("INSERT INTO items (id, name) VALUES (1, 'first'), (2, 'second')")
+
+	router := httprouter.New()
+	router.PATCH("/:table/:id", Update(openTestPool(t, dbPath)))
+
+	payload := `{"name": "x\" WHERE 1=1; --"}`
+	req, _ := http.NewRequest("PATCH", "/items/1", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	var first, second string
+	conn.QueryRow("SELECT name FROM items WHERE id = 1").Scan(&first)
+	conn.QueryRow("SELECT name FROM items WHERE id = 2").Scan(&second)
+
+	if first != `x" WHERE 1=1; --` {
+		t.Errorf("Unexpected stored value: %q", first)
+	}
+	if second != "second" {
+		t.Errorf("Update leaked into other rows: %q", second)
+	}
+}
+
+func TestGetAllBindsFilterValues(t *testing.T) {
+	dbPath := setupBindingDB(t)
+
+	conn, _ := sql.Open("sqlite3", dbPath)
+	conn.Exec("INSERT INTO items (name, qty) VALUES ('first', 1), ('second', 2)")
+	conn.Close()
+
+	router := httprouter.New()
+	router.GET("/:table", GetAll(openTestPool(t, dbPath)))
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		expected int
+	}{
+		{"quoted value", `[{"column": "name", "operator": "=", "value": "x' OR '1'='1"}]`, http.StatusOK, 0},
+		{"numeric value", `[{"column": "qty", "operator": ">=", "value": 2}]`, http.StatusOK, 1},
+		{"in operator", `[{"column": "name", "operator": "in", "value": ["first", "second"]}]`, http.StatusOK, 2},
+		{"operator injection", `[{"column": "qty", "operator": "= 1 OR 1 =", "value": 1}]`, http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/items?filters="+url.QueryEscape(test.query), nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, rr.Code, test.status, rr.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if int(response["total_rows"].(float64)) != test.expected {
+			t.Errorf("%s: got %v rows, want %d", test.name, response["total_rows"], test.expected)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/items?limit="+url.QueryEscape("1; DROP TABLE items"), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid limit to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestReadPathsDecodeValuesAlike(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-db-*.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	conn, _ := sql.Open("sqlite3", tmpFile.Name())
+	_, err = conn.Exec(`CREATE TABLE things (id INTEGER PRIMARY KEY, label VARCHAR(255), amount DECIMAL(10,2), stock UNSIGNED INTEGER, active BOOLEAN, born DATE, seen DATETIME, meta JSON, data BLOB, loose INTEGER);
+		INSERT INTO things VALUES (1, 'lamp', 10.5, 3, 1, '2024-03-01', '2024-03-01 12:30:00', '{"tags": ["a"]}', x'0001ff', 'not a number')`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	pool := openTestPool(t, tmpFile.Name())
+	router := httprouter.New()
+	router.GET("/:table", GetAll(pool))
+	router.GET("/:table/:id", Get(pool))
+	router.OPTIONS("/__/exec", Exec(pool))
+
+	read := func(method, path, body string) map[string]interface{} {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s %s: got status %d: %s", method, path, rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response
+	}
+
+	want := map[string]interface{}{
+		"id":     float64(1),
+		"label":  "lamp",
+		"amount": 10.5,
+		"stock":  float64(3),
+		"active": true,
+		"born":   "2024-03-01",
+		"seen":   "2024-03-01T12:30:00Z",
+		"meta":   map[string]interface{}{"tags": []interface{}{"a"}},
+		"data":   "AAH/",
+		"loose":  "not a number",
+	}
+
+	records := map[string]interface{}{
+		"GET /things/1": read("GET", "/things/1", "")["data"],
+		"GET /things":   read("GET", "/things", "")["data"].([]interface{})[0],
+		"/__/exec":      read("OPTIONS", "/__/exec", `{"query": "SELECT * FROM things"}`)["rows"].([]interface{})[0],
+	}
+	for path, record := range records {
+		if !reflect.DeepEqual(record, want) {
+			t.Errorf("%s: expected %v, got %v", path, want, record)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Actions checked by authorizers, each covering one or more SQLite
+// authorizer action codes
+const (
+	ActionRead     = "read"
+	ActionInsert   = "insert"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionCreate   = "create"
+	ActionAlter    = "alter"
+	ActionDrop     = "drop"
+	ActionAttach   = "attach"
+	ActionPragma   = "pragma"
+	ActionFunction = "function"
+)
+
+// Actions lists every action in the order they are documented
+var Actions = []string{ActionRead, ActionInsert, ActionUpdate, ActionDelete, ActionCreate, ActionAlter, ActionDrop, ActionAttach, ActionPragma, ActionFunction}
+
+// sqliteRecursive is SQLITE_RECURSIVE, not exported by the driver
+const sqliteRecursive = 33
+
+// Authorization is an action a statement being prepared performs. Table is
+// the table it applies to, empty for actions on the whole database, and
+// Object names what the action is on, e.g. a table, pragma or function.
+type Authorization struct {
+	Action string
+	Table  string
+	Object string
+}
+
+// String describes the authorization, e.g. "drop on cats"
+func (a Authorization) String() string {
+	if a.Object == "" {
+		return a.Action
+	}
+	return a.Action + " on " + a.Object
+}
+
+// authorization classifies a SQLite authorizer callback. Transaction
+// statements and the writes SQLite makes to its schema tables are always
+// allowed, ok is false for them.
+func authorization(op int, arg1, arg2 string) (Authorization, bool) {
+	switch op {
+	case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_TRANSACTION, sqlite3.SQLITE_SAVEPOINT, sqliteRecursive:
+		return Authorization{}, false
+	case sqlite3.SQLITE_READ:
+		return Authorization{Action: ActionRead, Table: arg1, Object: arg1}, true
+	case sqlite3.SQLITE_INSERT:
+		return tableWrite(ActionInsert, arg1)
+	case sqlite3.SQLITE_UPDATE:
+		return tableWrite(ActionUpdate, arg1)
+	case sqlite3.SQLITE_DELETE:
+		return tableWrite(ActionDelete, arg1)
+	case sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TEMP_TABLE, sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_TEMP_VIEW, sqlite3.SQLITE_CREATE_VTABLE:
+		return Authorization{Action: ActionCreate, Table: arg1, Object: arg1}, true
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TEMP_INDEX, sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_CREATE_TEMP_TRIGGER:
+		return Authorization{Action: ActionCreate, Table: arg2, Object: arg1}, true
+	case sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TEMP_TABLE, sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_TEMP_VIEW, sqlite3.SQLITE_DROP_VTABLE:
+		return Authorization{Action: ActionDrop, Table: arg1, Object: arg1}, true
+	case sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TEMP_INDEX, sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_TEMP_TRIGGER:
+		return Authorization{Action: ActionDrop, Table: arg2, Object: arg1}, true
+	case sqlite3.SQLITE_ALTER_TABLE:
+		return Authorization{Action: ActionAlter, Table: arg2, Object: arg2}, true
+	case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		return Authorization{Action: ActionAttach, Object: arg1}, true
+	case sqlite3.SQLITE_PRAGMA, sqlite3.SQLITE_ANALYZE, sqlite3.SQLITE_REINDEX:
+		return Authorization{Action: ActionPragma, Object: arg1}, true
+	case sqlite3.SQLITE_FUNCTION:
+		return Authorization{Action: ActionFunction, Object: arg2}, true
+	}
+	return Authorization{Action: fmt.Sprintf("action %d", op), Object: arg1}, true
+}
+
+// tableWrite classifies a write on a table. Writes to the tables where
+// SQLite stores the schema are made by CREATE, ALTER and DROP statements,
+// which are checked on their own.
+func tableWrite(action, table string) (Authorization, bool) {
+	if isSchemaTable(table) {
+		return Authorization{}, false
+	}
+	return Authorization{Action: action, Table: table, Object: table}, true
+}
+
+// isSchemaTable reports whether table is one of the schema tables
+func isSchemaTable(table string) bool {
+	switch table {
+	case "sqlite_master", "sqlite_temp_master", "sqlite_schema", "sqlite_temp_schema":
+		return true
+	}
+	return false
+}
+
+// keepDeletes returns the default authorizer of writable connections.
+// Ignoring deletes turns off the truncate optimization, which empties a
+// table without reporting the deleted rows to the update hook. DROP
+// statements check for the right to delete from the schema table and from
+// the dropped table right after the drop itself, and would be skipped when
+// ignored, so those deletes are allowed.
+func keepDeletes() func(op int, arg1, arg2, database string) int {
+	dropping := ""
+	return func(op int, arg1, arg2, database string) int {
+		dropped := dropping
+		dropping = ""
+		switch op {
+		case sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TEMP_TABLE, sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_TEMP_VIEW, sqlite3.SQLITE_DROP_VTABLE:
+			dropping = arg1
+		case sqlite3.SQLITE_DELETE:
+			if arg1 != dropped && !strings.HasPrefix(arg1, "sqlite_") {
+				return sqlite3.SQLITE_IGNORE
+			}
+		}
+		return sqlite3.SQLITE_OK
+	}
+}
+
+// SetAuthorizer makes allow decide which actions the statements prepared on
+// conn may perform. Denied statements fail to prepare with a "not
+// authorized" error. A nil allow restores the default authorizer.
+func SetAuthorizer(conn *sql.Conn, allow func(Authorization) bool) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		keep := keepDeletes()
+		if allow == nil {
+			c.RegisterAuthorizer(keep)
+			return nil
+		}
+		c.RegisterAuthorizer(func(op int, arg1, arg2, database string) int {
+			result := keep(op, arg1, arg2, database)
+			a, checked := authorization(op, arg1, arg2)
+			if checked && !allow(a) {
+				return sqlite3.SQLITE_DENY
+			}
+			return result
+		})
+		return nil
+	})
+}
@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/paradoxe35/sqlite-rest/pkg/types"
+)
+
+// Operations of a change
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// hookOps names the operations reported by the update hook
+var hookOps = map[int]string{
+	sqlite3.SQLITE_INSERT: OpInsert,
+	sqlite3.SQLITE_UPDATE: OpUpdate,
+	sqlite3.SQLITE_DELETE: OpDelete,
+}
+
+// Change is a row inserted, updated or deleted by a committed transaction.
+// Key holds the primary key columns and Row the stored columns of the row,
+// both recorded by the preupdate hook as the change is made, so Row is the
+// row as the transaction left it. Deletes have the key of the deleted row
+// and no row. Key is nil for tables without a primary key, and both are nil
+// for tables created or altered by the same transaction.
+type Change struct {
+	ID    uint64                 `json:"id"`
+	Table string                 `json:"table"`
+	Op    string                 `json:"op"`
+	RowID int64                  `json:"rowid"`
+	Key   map[string]interface{} `json:"key"`
+	Row   map[string]interface{} `json:"row,omitempty"`
+}
+
+// rowChange is a change reported by the update hook, before it is resolved.
+// values are the stored columns of table as the change left the row, or as
+// they were before a delete, nil when they could not be read.
+type rowChange struct {
+	table  string
+	op     string
+	rowid  int64
+	schema *changedTable
+	values []interface{}
+}
+
+// Feed collects the changes committed on the writable connections of a
+// pool. The preupdate hook records the values of the changed rows of a
+// transaction, the update hook the changes themselves, the commit hook hands
+// them over and the rollback hook drops them. Committed changes are then
+// resolved in the background and kept in a bounded buffer that clients read
+// from by change ID.
+type Feed struct {
+	mu      sync.Mutex
+	events  []Change
+	size    int
+	lastID  uint64
+	notify  chan struct{}
+	batches [][]rowChange
+
+	// tables reads the columns of changed tables, on a connection of its own
+	// as the hooks run while the writing connection is busy
+	tables  *sql.DB
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// newFeed returns a feed keeping the last size changes
+func newFeed(size int) *Feed {
+	return &Feed{
+		size:    size,
+		notify:  make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+}
+
+// enqueue hands the changes of a committing transaction to the resolver
+func (f *Feed) enqueue(batch []rowChange) {
+	f.mu.Lock()
+	f.batches = append(f.batches, batch)
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start resolves committed changes until stop is called. The columns of
+// changed tables are read on tables, which is closed by stop.
+func (f *Feed) start(tables *sql.DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.tables = tables
+	f.cancel = cancel
+	go func() {
+		defer close(f.stopped)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-f.wake:
+			}
+
+			f.mu.Lock()
+			batches := f.batches
+			f.batches = nil
+			f.mu.Unlock()
+
+			f.publish(resolveChanges(batches))
+		}
+	}()
+}
+
+// stop ends the resolver
+func (f *Feed) stop() {
+	f.cancel()
+	<-f.stopped
+	f.tables.Close()
+}
+
+// publish numbers resolved changes, buffers them and wakes the readers
+func (f *Feed) publish(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, change := range changes {
+		f.lastID++
+		change.ID = f.lastID
+		f.events = append(f.events, change)
+	}
+	if len(f.events) > f.size {
+		f.events = f.events[len(f.events)-f.size:]
+	}
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// LastID returns the ID of the latest change, 0 before the first one
+func (f *Feed) LastID() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastID
+}
+
+// Since returns the buffered changes following the change with ID id and a
+// channel closed when more are published. complete is false when changes
+// after id were dropped from the buffer, or id was not issued by this feed,
+// in which case every buffered change is returned.
+func (f *Feed) Since(id uint64) (changes []Change, complete bool, wait <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	oldest := f.lastID - uint64(len(f.events)) + 1
+	start, complete := 0, true
+	if id > f.lastID || id+1 < oldest {
+		complete = false
+	} else {
+		start = int(id + 1 - oldest)
+	}
+	changes = make([]Change, len(f.events)-start)
+	copy(changes, f.events[start:])
+	return changes, complete, f.notify
+}
+
+// coalesce merges the changes of one transaction to the same row into their
+// net effect, in the order the rows were first changed, with the values of
+// the last change. A row inserted and then deleted is left out.
+func coalesce(batch []rowChange) []rowChange {
+	type rowRef struct {
+		table string
+		rowid int64
+	}
+	index := make(map[rowRef]int)
+	var merged []rowChange
+	dropped := make(map[int]bool)
+
+	for _, change := range batch {
+		ref := rowRef{change.table, change.rowid}
+		i, seen := index[ref]
+		if !seen {
+			index[ref] = len(merged)
+			merged = append(merged, change)
+			continue
+		}
+
+		previous := merged[i].op
+		if dropped[i] {
+			previous = ""
+		}
+		dropped[i] = false
+		switch {
+		case previous == OpInsert && change.op == OpDelete:
+			dropped[i] = true
+		case previous == OpInsert:
+			// Updates of an inserted row are part of the insert
+		case previous == OpDelete && change.op == OpInsert:
+			merged[i].op = OpUpdate
+		default:
+			merged[i].op = change.op
+		}
+		merged[i].schema, merged[i].values = change.schema, change.values
+	}
+
+	result := merged[:0]
+	for i, change := range merged {
+		if !dropped[i] {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// changedTable is what recording changes needs to know about a table
+type changedTable struct {
+	// columns are the stored columns, in the order of the values given by
+	// the preupdate hook, which leaves out virtual generated columns
+	columns []string
+	codecs  []types.Codec
+	// primaryKey holds the indexes in columns of the primary key columns
+	primaryKey []int
+	virtual    int
+	shadow     bool
+}
+
+// resolveChanges turns the changes of committed transactions into the
+// changes sent to clients
+func resolveChanges(batches [][]rowChange) []Change {
+	var changes []Change
+	for _, batch := range batches {
+		for _, change := range coalesce(batch) {
+			resolved := Change{Table: change.table, Op: change.op, RowID: change.rowid}
+			if change.values != nil {
+				row := make(map[string]interface{}, len(change.values))
+				for i, column := range change.schema.columns {
+					row[column] = change.schema.codecs[i].DecodeStored(change.values[i])
+				}
+				if len(change.schema.primaryKey) > 0 {
+					resolved.Key = make(map[string]interface{}, len(change.schema.primaryKey))
+					for _, i := range change.schema.primaryKey {
+						column := change.schema.columns[i]
+						resolved.Key[column] = row[column]
+					}
+				}
+				if change.op != OpDelete {
+					resolved.Row = row
+				}
+			}
+			changes = append(changes, resolved)
+		}
+	}
+	return changes
+}
+
+// readTable reads the columns of a table as committed, and whether it is a
+// shadow table holding the data of a virtual table such as a search index.
+// A table created by the transaction being recorded has no columns yet.
+func (f *Feed) readTable(name string) *changedTable {
+	table := &changedTable{}
+
+	var shadow int
+	err := f.tables.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND sql LIKE 'CREATE VIRTUAL TABLE%' AND ? LIKE replace(replace(name, '_', '\\_'), '%', '\\%') || '\\_%' ESCAPE '\\'", name).Scan(&shadow)
+	if err == nil && shadow > 0 {
+		table.shadow = true
+		return table
+	}
+
+	rows, err := f.tables.Query(fmt.Sprintf("PRAGMA table_xinfo(%s)", quoteName(name)))
+	if err != nil {
+		return table
+	}
+	defer rows.Close()
+
+	keys := make(map[int]int)
+	for rows.Next() {
+		var cid, notNull, pk, hidden int
+		var column string
+		var dataType sql.NullString
+		var dfltValue interface{}
+		if err := rows.Scan(&cid, &column, &dataType, &notNull, &dfltValue, &pk, &hidden); err != nil {
+			return &changedTable{}
+		}
+		// Hidden columns of kind 2 are virtual generated columns
+		if hidden == 2 {
+			table.virtual++
+			continue
+		}
+		if pk > 0 {
+			keys[pk] = len(table.columns)
+		}
+		table.columns = append(table.columns, column)
+		table.codecs = append(table.codecs, types.NewCodec(dataType.String))
+	}
+	for i := 1; i <= len(keys); i++ {
+		table.primaryKey = append(table.primaryKey, keys[i])
+	}
+	return table
+}
+
+// quoteName quotes a table name
+func quoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
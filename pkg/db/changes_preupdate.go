@@ -0,0 +1,92 @@
+//go:build sqlite_preupdate_hook
+
+package db
+
+import (
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ChangesSupported reports whether the change feed is compiled in. It needs
+// SQLite's preupdate hook, compiled in with the sqlite_preupdate_hook build
+// tag.
+const ChangesSupported = true
+
+// watch registers the hooks recording the changes made on conn. The hooks
+// run on the goroutine using the connection, which is never shared, so the
+// pending changes need no lock.
+func (f *Feed) watch(conn *sqlite3.SQLiteConn) {
+	conn.RegisterAuthorizer(keepDeletes())
+
+	var pending []rowChange
+	// tables holds the tables changed by the transaction, read once each
+	tables := make(map[string]*changedTable)
+	// captured is the row the preupdate hook read for the change being made,
+	// which the update hook reports right after unless the change fails
+	var captured *rowChange
+	conn.RegisterPreUpdateHook(func(data sqlite3.SQLitePreUpdateData) {
+		captured = nil
+		if data.DatabaseName != "main" || strings.HasPrefix(data.TableName, "sqlite_") {
+			return
+		}
+		table, ok := tables[data.TableName]
+		if !ok {
+			table = f.readTable(data.TableName)
+			tables[data.TableName] = table
+		}
+		captured = table.capture(data)
+	})
+	conn.RegisterUpdateHook(func(op int, database, table string, rowid int64) {
+		row := captured
+		captured = nil
+		if database != "main" || strings.HasPrefix(table, "sqlite_") {
+			return
+		}
+		if schema := tables[table]; schema != nil && schema.shadow {
+			return
+		}
+		change := rowChange{table: table, op: hookOps[op], rowid: rowid}
+		if row != nil && row.table == table && row.rowid == rowid {
+			change.schema, change.values = row.schema, row.values
+		}
+		pending = append(pending, change)
+	})
+	conn.RegisterCommitHook(func() int {
+		if len(pending) > 0 {
+			f.enqueue(pending)
+			pending = nil
+		}
+		tables = make(map[string]*changedTable)
+		return 0
+	})
+	conn.RegisterRollbackHook(func() {
+		pending = nil
+		tables = make(map[string]*changedTable)
+	})
+}
+
+// capture reads the values of the row being changed: the new row of inserts
+// and updates, the old one of deletes. Nothing is read when the columns of
+// the table differ from those read before the transaction, as when it was
+// created or altered since, since the hook cannot tell which values belong
+// to which columns then.
+func (t *changedTable) capture(data sqlite3.SQLitePreUpdateData) *rowChange {
+	if t.shadow || len(t.columns) == 0 || data.Count() != len(t.columns)+t.virtual {
+		return nil
+	}
+
+	row := &rowChange{table: data.TableName, schema: t, values: make([]interface{}, len(t.columns))}
+	var err error
+	if data.Op == sqlite3.SQLITE_DELETE {
+		row.rowid = data.OldRowID
+		err = data.Old(row.values...)
+	} else {
+		row.rowid = data.NewRowID
+		err = data.New(row.values...)
+	}
+	if err != nil {
+		return nil
+	}
+	return row
+}
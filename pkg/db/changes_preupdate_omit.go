@@ -0,0 +1,14 @@
+//go:build !sqlite_preupdate_hook
+
+package db
+
+import "github.com/mattn/go-sqlite3"
+
+// ChangesSupported reports whether the change feed is compiled in. It needs
+// SQLite's preupdate hook, compiled in with the sqlite_preupdate_hook build
+// tag.
+const ChangesSupported = false
+
+// watch is never called, pools open no change feed without the preupdate
+// hook
+func (f *Feed) watch(conn *sqlite3.SQLiteConn) {}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitChanges waits for the changes following id until there are n of them
+func waitChanges(t *testing.T, feed *Feed, id uint64, n int) []Change {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		changes, _, wait := feed.Since(id)
+		if len(changes) >= n {
+			return changes
+		}
+		select {
+		case <-wait:
+		case <-timeout:
+			t.Fatalf("Expected %d changes, got %v", n, changes)
+		}
+	}
+}
+
+// skipWithoutChanges skips tests of the change feed when it is not compiled in
+func skipWithoutChanges(t *testing.T) {
+	if !ChangesSupported {
+		t.Skip("SQLite was built without the preupdate hook, run the tests with -tags sqlite_preupdate_hook")
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	skipWithoutChanges(t)
+	config := DefaultPoolConfig()
+	config.ChangeBuffer = 5
+
+	pool, err := NewPool(filepath.Join(t.TempDir(), "test.sqlite"), config)
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	defer pool.Close()
+
+	_, err = pool.Writer.Exec(`
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, paid BOOLEAN);
+		CREATE TABLE items (sku TEXT, order_id INTEGER, PRIMARY KEY (sku, order_id));
+		CREATE TABLE notes (body TEXT);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	// Rolled back changes are not reported
+	tx, _ := pool.Writer.Begin()
+	tx.Exec("INSERT INTO orders (status) VALUES ('lost')")
+	tx.Rollback()
+
+	// Changes to the same row in one transaction are merged
+	tx, _ = pool.Writer.Begin()
+	tx.Exec("INSERT INTO orders (id, status, paid) VALUES (7, 'new', 0)")
+	tx.Exec("UPDATE orders SET paid = 1 WHERE id = 7")
+	tx.Exec("INSERT INTO notes (body) VALUES ('temporary')")
+	tx.Exec("DELETE FROM notes")
+	tx.Commit()
+
+	if _, err := pool.Writer.Exec("INSERT INTO items VALUES ('A-1', 7)"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	changes := waitChanges(t, pool.Changes, 0, 2)
+
+	// Changes of interactive transactions are reported once committed
+	session, err := pool.BeginSession()
+	if err != nil {
+		t.Fatalf("Failed to begin session: %v", err)
+	}
+	session.Tx.Exec("DELETE FROM orders WHERE id = 7")
+	// Deletes of whole tables report every row
+	session.Tx.Exec("DELETE FROM items")
+	session.Commit()
+
+	changes = append(changes[:2], waitChanges(t, pool.Changes, 2, 2)...)
+	if len(changes) != 4 {
+		t.Fatalf("Expected 4 changes, got %v", changes)
+	}
+
+	insert := changes[0]
+	if insert.ID != 1 || insert.Table != "orders" || insert.Op != OpInsert || insert.RowID != 7 || insert.Key["id"] != int64(7) || insert.Row["paid"] != true {
+		t.Errorf("Expected the merged insert of order 7, got %+v", insert)
+	}
+	if changes[1].Table != "items" || changes[1].Key["sku"] != "A-1" || changes[1].Key["order_id"] != int64(7) {
+		t.Errorf("Expected the composite key of the item, got %+v", changes[1])
+	}
+
+	// Deleted rows keep their key
+	if changes[2].Op != OpDelete || changes[2].Key["id"] != int64(7) || changes[2].Row != nil {
+		t.Errorf("Expected the delete of order 7, got %+v", changes[2])
+	}
+	if changes[3].Op != OpDelete || changes[3].Key["sku"] != "A-1" || changes[3].Key["order_id"] != int64(7) {
+		t.Errorf("Expected the delete of the item with its key, got %+v", changes[3])
+	}
+
+	if more, complete, _ := pool.Changes.Since(2); !complete || len(more) != 2 || more[0].ID != 3 {
+		t.Errorf("Expected the changes after 2, got %v", more)
+	}
+
+	// Changes dropped from the buffer are reported as missed
+	if _, err := pool.Writer.Exec("INSERT INTO notes (body) VALUES ('a'), ('b'), ('c')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	waitChanges(t, pool.Changes, 4, 3)
+	if buffered, complete, _ := pool.Changes.Since(1); complete || len(buffered) != 5 || buffered[0].ID != 3 {
+		t.Errorf("Expected the 5 buffered changes as incomplete, got %v", buffered)
+	}
+	if _, complete, _ := pool.Changes.Since(100); complete {
+		t.Errorf("Expected an unknown ID to be incomplete")
+	}
+	if pool.Changes.LastID() != 7 {
+		t.Errorf("Expected last ID 7, got %d", pool.Changes.LastID())
+	}
+
+	// Keeping deletes reported does not get in the way of dropping tables
+	if _, err := pool.Writer.Exec("DROP TABLE notes"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	var tables int
+	pool.Reader.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'notes'").Scan(&tables)
+	if tables != 0 {
+		t.Errorf("Expected the notes table to be dropped")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	change := func(op string, rowid int64, value string) rowChange {
+		return rowChange{table: "t", op: op, rowid: rowid, values: []interface{}{value}}
+	}
+	merged := coalesce([]rowChange{
+		change(OpUpdate, 1, "a"),
+		change(OpInsert, 2, "b"),
+		change(OpDelete, 2, "b"),
+		change(OpDelete, 1, "c"),
+		change(OpDelete, 3, "d"),
+		change(OpInsert, 3, "e"),
+		change(OpInsert, 2, "f"),
+	})
+	want := []rowChange{change(OpDelete, 1, "c"), change(OpInsert, 2, "f"), change(OpUpdate, 3, "e")}
+	if len(merged) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, merged)
+	}
+	for i := range want {
+		if merged[i].op != want[i].op || merged[i].rowid != want[i].rowid || merged[i].values[0] != want[i].values[0] {
+			t.Errorf("Expected %v, got %v", want, merged)
+		}
+	}
+}
+
+func TestChangeFeedRows(t *testing.T) {
+	skipWithoutChanges(t)
+	pool, err := NewPool(filepath.Join(t.TempDir(), "test.sqlite"), DefaultPoolConfig())
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	defer pool.Close()
+
+	_, err = pool.Writer.Exec(`
+		CREATE TABLE users (email TEXT PRIMARY KEY, name TEXT, shout TEXT GENERATED ALWAYS AS (upper(name)) VIRTUAL, born DATE, avatar BLOB, note);
+		CREATE TABLE logs (id INTEGER PRIMARY KEY, msg TEXT);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	// Rows are recorded as each commit left them, and deletes keep text keys
+	statements := []string{
+		"INSERT INTO users (email, name, born, avatar, note) VALUES ('ann@example.com', 'Ann', '1990-04-01', x'00ff', 'hi')",
+		"UPDATE users SET name = 'Anna'",
+		"DELETE FROM users",
+		// The rowid of the deleted user is reused by another one
+		"INSERT INTO users (email, name) VALUES ('bob@example.com', 'Bob')",
+	}
+	for _, statement := range statements {
+		if _, err := pool.Writer.Exec(statement); err != nil {
+			t.Fatalf("Failed to run %s: %v", statement, err)
+		}
+	}
+	changes := waitChanges(t, pool.Changes, 0, 4)
+
+	insert := changes[0]
+	if insert.Key["email"] != "ann@example.com" || insert.Row["name"] != "Ann" || insert.Row["born"] != "1990-04-01" || insert.Row["note"] != "hi" {
+		t.Errorf("Expected the inserted row, got %+v", insert)
+	}
+	if avatar, ok := insert.Row["avatar"].([]byte); !ok || len(avatar) != 2 {
+		t.Errorf("Expected the avatar as bytes, got %#v", insert.Row["avatar"])
+	}
+	if _, ok := insert.Row["shout"]; ok {
+		t.Errorf("Expected no virtual generated column, got %+v", insert.Row)
+	}
+	if changes[1].Op != OpUpdate || changes[1].Row["name"] != "Anna" {
+		t.Errorf("Expected the updated row, got %+v", changes[1])
+	}
+	if changes[2].Op != OpDelete || changes[2].Key["email"] != "ann@example.com" || changes[2].Row != nil {
+		t.Errorf("Expected the delete of ann with its key, got %+v", changes[2])
+	}
+	if changes[3].RowID != changes[0].RowID || changes[3].Key["email"] != "bob@example.com" {
+		t.Errorf("Expected bob under the reused rowid, got %+v", changes[3])
+	}
+
+	// Tables created in the transaction writing to them have no key or row
+	tx, _ := pool.Writer.Begin()
+	tx.Exec("CREATE TABLE tags (name TEXT PRIMARY KEY)")
+	tx.Exec("INSERT INTO tags VALUES ('new')")
+	tx.Exec("INSERT INTO logs (msg) VALUES ('created tags')")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	changes = waitChanges(t, pool.Changes, 4, 2)
+	if changes[0].Table != "tags" || changes[0].Key != nil || changes[0].Row != nil {
+		t.Errorf("Expected the new tag without key and row, got %+v", changes[0])
+	}
+	if changes[1].Key["id"] != int64(1) || changes[1].Row["msg"] != "created tags" {
+		t.Errorf("Expected the log row, got %+v", changes[1])
+	}
+}
@@ -1,25 +1,54 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-func Open(dbPath string) (*sql.DB, error) {
-	main, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
+// connector opens SQLite connections through a driver carrying a connect hook
+type connector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Open returns a sql.DB whose connections are configured with the given
+// pragmas as soon as they are created
+func Open(dsn string, pragmas Pragmas, readOnly bool) (*sql.DB, error) {
+	return open(dsn, pragmas, readOnly, nil)
+}
+
+// open is Open recording the changes committed on the connections in feed,
+// when not nil
+func open(dsn string, pragmas Pragmas, readOnly bool, feed *Feed) (*sql.DB, error) {
+	if err := pragmas.Validate(); err != nil {
 		return nil, err
 	}
 
-	// if os.Getenv("WAL_MODE") == "true" {
-	// 	_, err = main.Exec("PRAGMA journal_mode=wal")
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-
-	// 	print("WAL mode enabled")
-	// }
+	connectHook := pragmas.connectHook(readOnly)
+	if feed != nil {
+		applyPragmas := connectHook
+		connectHook = func(conn *sqlite3.SQLiteConn) error {
+			if err := applyPragmas(conn); err != nil {
+				return err
+			}
+			feed.watch(conn)
+			return nil
+		}
+	}
 
-	return main, nil
+	return sql.OpenDB(&connector{
+		driver: &sqlite3.SQLiteDriver{ConnectHook: connectHook},
+		dsn:    dsn,
+	}), nil
 }
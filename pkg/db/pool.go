@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// PoolConfig controls the size and lifetime of pooled connections and the
+// pragmas they are opened with
+type PoolConfig struct {
+	// MaxOpenConns is the maximum number of open reader connections
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle reader connections
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections older than this, 0 keeps them forever
+	ConnMaxLifetime time.Duration
+	// MaxTransactions is the maximum number of interactive transactions open
+	// at once, 0 disables them
+	MaxTransactions int
+	// TxIdleTimeout rolls back interactive transactions left unused this long
+	TxIdleTimeout time.Duration
+	// ChangeBuffer is the number of committed changes kept for the change
+	// feed, 0 disables it. The feed is always disabled when built without
+	// the sqlite_preupdate_hook tag.
+	ChangeBuffer int
+	// Pragmas are applied to every connection of both pools
+	Pragmas Pragmas
+}
+
+// DefaultPoolConfig returns a pool configuration sized for the current machine
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    runtime.NumCPU(),
+		MaxIdleConns:    runtime.NumCPU(),
+		ConnMaxLifetime: 0,
+		MaxTransactions: 4,
+		TxIdleTimeout:   30 * time.Second,
+		ChangeBuffer:    1000,
+		Pragmas:         DefaultPragmas(),
+	}
+}
+
+// Pool is the process wide set of connections to a database. Reads go
+// through Reader so they never queue behind writes, while Writer holds a
+// single connection as SQLite only allows one writer at a time. Sessions
+// holds the dedicated connections of interactive transactions. Changes is
+// the feed of the changes committed through Writer and Sessions, nil when
+// disabled.
+type Pool struct {
+	Reader   *sql.DB
+	Writer   *sql.DB
+	Sessions *sql.DB
+	Changes  *Feed
+	path     string
+	sessions sessions
+}
+
+// NewPool opens the reader and writer pools for the database at dbPath
+func NewPool(dbPath string, config PoolConfig) (*Pool, error) {
+	var feed *Feed
+	if config.ChangeBuffer > 0 && ChangesSupported {
+		feed = newFeed(config.ChangeBuffer)
+	}
+
+	writer, err := open(dbPath, config.Pragmas, false, feed)
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	writer.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	// Open the writer first so the database file exists for read-only connections
+	if err := writer.Ping(); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	reader, err := Open(readOnlyDSN(dbPath), config.Pragmas, true)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	reader.SetMaxOpenConns(config.MaxOpenConns)
+	reader.SetMaxIdleConns(config.MaxIdleConns)
+	reader.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	// Connections of interactive transactions are closed when they end
+	sessionConns, err := open(dbPath, config.Pragmas, false, feed)
+	if err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, err
+	}
+	if config.MaxTransactions > 0 {
+		sessionConns.SetMaxOpenConns(config.MaxTransactions)
+	}
+	sessionConns.SetMaxIdleConns(0)
+
+	if feed != nil {
+		tables, err := Open(readOnlyDSN(dbPath), config.Pragmas, true)
+		if err != nil {
+			writer.Close()
+			reader.Close()
+			sessionConns.Close()
+			return nil, err
+		}
+		tables.SetMaxOpenConns(1)
+		feed.start(tables)
+	}
+
+	return &Pool{
+		Reader:   reader,
+		Writer:   writer,
+		Sessions: sessionConns,
+		Changes:  feed,
+		path:     dbPath,
+		sessions: sessions{
+			open:        make(map[string]*Session),
+			max:         config.MaxTransactions,
+			idleTimeout: config.TxIdleTimeout,
+		},
+	}, nil
+}
+
+// Path returns the database file path the pool was opened with
+func (p *Pool) Path() string {
+	return p.path
+}
+
+// Close rolls back the open interactive transactions, stops the change feed
+// and closes the pools
+func (p *Pool) Close() error {
+	p.closeSessions()
+	if p.Changes != nil {
+		p.Changes.stop()
+	}
+	sessionsErr := p.Sessions.Close()
+	readerErr := p.Reader.Close()
+	writerErr := p.Writer.Close()
+	if readerErr != nil {
+		return readerErr
+	}
+	if writerErr != nil {
+		return writerErr
+	}
+	return sessionsErr
+}
+
+// readOnlyDSN turns a file path into a read-only SQLite URI
+func readOnlyDSN(dbPath string) string {
+	escaper := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+	return "file:" + escaper.Replace(dbPath) + "?mode=ro"
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPoolAppliesPragmas(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.Pragmas.BusyTimeout = 1234
+	config.Pragmas.TempStore = "memory"
+
+	pool, err := NewPool(filepath.Join(t.TempDir(), "test.sqlite"), config)
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	defer pool.Close()
+
+	effective, err := pool.EffectivePragmas()
+	if err != nil {
+		t.Fatalf("Failed to read pragmas: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"journal_mode": "wal",
+		"busy_timeout": int64(1234),
+		"foreign_keys": true,
+		"synchronous":  "normal",
+		"temp_store":   "memory",
+	}
+	for name, value := range expected {
+		if effective[name] != value {
+			t.Errorf("Expected %s = %v, got %v", name, value, effective[name])
+		}
+	}
+
+	// Foreign keys are enforced on the writer
+	_, err = pool.Writer.Exec(`
+		CREATE TABLE owners (id INTEGER PRIMARY KEY);
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, owner_id INTEGER REFERENCES owners(id));
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	if _, err := pool.Writer.Exec("INSERT INTO cats (owner_id) VALUES (42)"); err == nil {
+		t.Errorf("Expected foreign key violation to be rejected")
+	}
+
+	// Readers cannot write
+	if _, err := pool.Reader.Exec("INSERT INTO owners (id) VALUES (1)"); err == nil {
+		t.Errorf("Expected reader pool to be read-only")
+	}
+}
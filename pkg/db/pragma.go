@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Pragmas are the SQLite settings applied to every pooled connection
+type Pragmas struct {
+	// JournalMode is one of delete, truncate, persist, memory, wal or off
+	JournalMode string
+	// BusyTimeout is how long a connection waits on a lock, in milliseconds
+	BusyTimeout int
+	// ForeignKeys enables foreign key enforcement
+	ForeignKeys bool
+	// Synchronous is one of off, normal, full or extra
+	Synchronous string
+	// CacheSize is the page cache size, negative values are in KiB
+	CacheSize int
+	// MmapSize is the maximum number of bytes to memory map, 0 disables it
+	MmapSize int64
+	// TempStore is one of default, file or memory
+	TempStore string
+}
+
+// DefaultPragmas returns the settings used when nothing is configured
+func DefaultPragmas() Pragmas {
+	return Pragmas{
+		JournalMode: "wal",
+		BusyTimeout: 5000,
+		ForeignKeys: true,
+		Synchronous: "normal",
+		CacheSize:   -2000,
+		MmapSize:    0,
+		TempStore:   "default",
+	}
+}
+
+// pragmaChoices lists the accepted values of the keyword pragmas
+var pragmaChoices = map[string][]string{
+	"journal_mode": {"delete", "truncate", "persist", "memory", "wal", "off"},
+	"synchronous":  {"off", "normal", "full", "extra"},
+	"temp_store":   {"default", "file", "memory"},
+}
+
+// Validate checks the keyword pragmas against the values SQLite accepts
+func (p Pragmas) Validate() error {
+	values := map[string]string{
+		"journal_mode": p.JournalMode,
+		"synchronous":  p.Synchronous,
+		"temp_store":   p.TempStore,
+	}
+
+	for name, value := range values {
+		valid := false
+		for _, choice := range pragmaChoices[name] {
+			if strings.EqualFold(value, choice) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid %s %q, must be one of: %s", name, value, strings.Join(pragmaChoices[name], ", "))
+		}
+	}
+
+	if p.BusyTimeout < 0 {
+		return fmt.Errorf("invalid busy_timeout %d, must not be negative", p.BusyTimeout)
+	}
+	if p.MmapSize < 0 {
+		return fmt.Errorf("invalid mmap_size %d, must not be negative", p.MmapSize)
+	}
+
+	return nil
+}
+
+// statements returns the PRAGMA statements to run on a new connection.
+// The journal mode is a property of the database file, so it is only set
+// by connections that can write.
+func (p Pragmas) statements(readOnly bool) []string {
+	var statements []string
+	if !readOnly {
+		statements = append(statements, fmt.Sprintf("PRAGMA journal_mode = %s", strings.ToLower(p.JournalMode)))
+	}
+
+	foreignKeys := "OFF"
+	if p.ForeignKeys {
+		foreignKeys = "ON"
+	}
+
+	return append(statements,
+		fmt.Sprintf("PRAGMA busy_timeout = %d", p.BusyTimeout),
+		fmt.Sprintf("PRAGMA foreign_keys = %s", foreignKeys),
+		fmt.Sprintf("PRAGMA synchronous = %s", strings.ToLower(p.Synchronous)),
+		fmt.Sprintf("PRAGMA cache_size = %d", p.CacheSize),
+		fmt.Sprintf("PRAGMA mmap_size = %d", p.MmapSize),
+		fmt.Sprintf("PRAGMA temp_store = %s", strings.ToLower(p.TempStore)),
+	)
+}
+
+// connectHook returns a driver hook applying the pragmas to each new connection
+func (p Pragmas) connectHook(readOnly bool) func(*sqlite3.SQLiteConn) error {
+	statements := p.statements(readOnly)
+	return func(conn *sqlite3.SQLiteConn) error {
+		for _, statement := range statements {
+			if _, err := conn.Exec(statement, nil); err != nil {
+				return fmt.Errorf("%s: %s", statement, err.Error())
+			}
+		}
+		return nil
+	}
+}
+
+// EffectivePragmas reads back the pragma values in use on a reader connection
+func (p *Pool) EffectivePragmas() (map[string]interface{}, error) {
+	names := []string{"journal_mode", "busy_timeout", "foreign_keys", "synchronous", "cache_size", "mmap_size", "temp_store"}
+
+	// Pin one connection so every value comes from the same place
+	conn, err := p.Reader.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	effective := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		var value interface{}
+		err := conn.QueryRowContext(context.Background(), "PRAGMA "+name).Scan(&value)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %s", name, err.Error())
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		effective[name] = value
+	}
+
+	// Report keyword pragmas by name rather than by their numeric code
+	effective["foreign_keys"] = effective["foreign_keys"] == int64(1)
+	if code, ok := effective["synchronous"].(int64); ok && code >= 0 && int(code) < len(pragmaChoices["synchronous"]) {
+		effective["synchronous"] = pragmaChoices["synchronous"][code]
+	}
+	if code, ok := effective["temp_store"].(int64); ok && code >= 0 && int(code) < len(pragmaChoices["temp_store"]) {
+		effective["temp_store"] = pragmaChoices["temp_store"][code]
+	}
+
+	return effective, nil
+}
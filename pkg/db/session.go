@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned for unknown, ended or expired sessions
+	ErrSessionNotFound = errors.New("transaction not found or expired")
+	// ErrSessionLimit is returned when the maximum number of transactions
+	// are already open
+	ErrSessionLimit = errors.New("too many open transactions")
+)
+
+// Session is an interactive transaction spanning several requests. It holds
+// a dedicated connection until it is committed, rolled back or expires
+// after being idle for the pool's idle timeout.
+type Session struct {
+	ID   string
+	Tx   *sql.Tx
+	Conn *sql.Conn
+
+	// mu is held by the request using the session, so requests on the same
+	// session run one after the other
+	mu       sync.Mutex
+	pool     *Pool
+	timer    *time.Timer
+	deadline atomic.Int64
+	ended    bool
+}
+
+// Deadline returns when the session expires unless it is used again
+func (s *Session) Deadline() time.Time {
+	return time.Unix(0, s.deadline.Load())
+}
+
+// sessions is the registry of the open sessions of a pool
+type sessions struct {
+	mu          sync.Mutex
+	open        map[string]*Session
+	max         int
+	idleTimeout time.Duration
+}
+
+// BeginSession opens an interactive transaction on a connection of the
+// Sessions pool. The transaction is deferred, so it only takes SQLite's
+// write lock with its first write and sessions that only read never keep
+// other writers waiting.
+func (p *Pool) BeginSession() (*Session, error) {
+	p.sessions.mu.Lock()
+	if len(p.sessions.open) >= p.sessions.max {
+		p.sessions.mu.Unlock()
+		return nil, ErrSessionLimit
+	}
+	id, err := sessionID()
+	if err != nil {
+		p.sessions.mu.Unlock()
+		return nil, err
+	}
+	// Reserve the slot while the transaction is opened
+	s := &Session{ID: id, pool: p}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.sessions.open[id] = s
+	p.sessions.mu.Unlock()
+
+	conn, err := p.Sessions.Conn(context.Background())
+	if err != nil {
+		s.ended = true
+		p.removeSession(id)
+		return nil, err
+	}
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		conn.Close()
+		s.ended = true
+		p.removeSession(id)
+		return nil, err
+	}
+	s.Tx = tx
+	s.Conn = conn
+	s.deadline.Store(time.Now().Add(p.sessions.idleTimeout).UnixNano())
+	s.timer = time.AfterFunc(p.sessions.idleTimeout, func() { s.expire() })
+	return s, nil
+}
+
+// AcquireSession returns the open session with the given ID for the
+// exclusive use of a request, waiting while another request uses it. The
+// session does not expire until it is released.
+func (p *Pool) AcquireSession(id string) (*Session, error) {
+	p.sessions.mu.Lock()
+	s, ok := p.sessions.open[id]
+	p.sessions.mu.Unlock()
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return nil, ErrSessionNotFound
+	}
+	s.timer.Stop()
+	return s, nil
+}
+
+// Release ends the use of an acquired session and restarts its idle timeout
+func (s *Session) Release() {
+	if !s.ended {
+		s.deadline.Store(time.Now().Add(s.pool.sessions.idleTimeout).UnixNano())
+		s.timer.Reset(s.pool.sessions.idleTimeout)
+	}
+	s.mu.Unlock()
+}
+
+// Commit commits an acquired session and ends it
+func (s *Session) Commit() error {
+	defer s.end()
+	return s.Tx.Commit()
+}
+
+// Rollback rolls an acquired session back and ends it
+func (s *Session) Rollback() error {
+	defer s.end()
+	return s.Tx.Rollback()
+}
+
+// end returns the connection of a finished session to the pool and removes
+// the session from the registry
+func (s *Session) end() {
+	s.ended = true
+	s.timer.Stop()
+	s.Conn.Close()
+	s.pool.removeSession(s.ID)
+}
+
+// expire rolls back a session that was not used within the idle timeout.
+// The timer can fire while a request holds the session, in which case the
+// release has moved the deadline and restarted the timer.
+func (s *Session) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended || time.Now().UnixNano() < s.deadline.Load() {
+		return
+	}
+	s.Tx.Rollback()
+	s.end()
+}
+
+// abort rolls back a session unless it already ended
+func (s *Session) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.Tx.Rollback()
+	s.end()
+}
+
+// SessionCount returns the number of open sessions
+func (p *Pool) SessionCount() int {
+	p.sessions.mu.Lock()
+	defer p.sessions.mu.Unlock()
+	return len(p.sessions.open)
+}
+
+func (p *Pool) removeSession(id string) {
+	p.sessions.mu.Lock()
+	delete(p.sessions.open, id)
+	p.sessions.mu.Unlock()
+}
+
+// closeSessions rolls back every open session
+func (p *Pool) closeSessions() {
+	p.sessions.mu.Lock()
+	open := make([]*Session, 0, len(p.sessions.open))
+	for _, s := range p.sessions.open {
+		open = append(open, s)
+	}
+	p.sessions.mu.Unlock()
+
+	for _, s := range open {
+		s.abort()
+	}
+}
+
+// sessionID returns a random session identifier
+func sessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	config := DefaultPoolConfig()
+	config.MaxTransactions = 2
+	config.TxIdleTimeout = 100 * time.Millisecond
+
+	pool, err := NewPool(filepath.Join(t.TempDir(), "test.sqlite"), config)
+	if err != nil {
+		t.Fatalf("Failed to open pool: %v", err)
+	}
+	defer pool.Close()
+
+	if _, err := pool.Writer.Exec("CREATE TABLE cats (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	first, err := pool.BeginSession()
+	if err != nil {
+		t.Fatalf("Failed to begin session: %v", err)
+	}
+	if _, err := pool.BeginSession(); err != nil {
+		t.Fatalf("Failed to begin session: %v", err)
+	}
+
+	// The number of open sessions is limited
+	if _, err := pool.BeginSession(); !errors.Is(err, ErrSessionLimit) {
+		t.Errorf("Expected ErrSessionLimit, got %v", err)
+	}
+
+	// Writes are only visible outside the session once committed
+	s, err := pool.AcquireSession(first.ID)
+	if err != nil {
+		t.Fatalf("Failed to acquire session: %v", err)
+	}
+	if _, err := s.Tx.Exec("INSERT INTO cats (id) VALUES (1)"); err != nil {
+		t.Fatalf("Failed to insert in session: %v", err)
+	}
+	var n int
+	pool.Reader.QueryRow("SELECT COUNT(*) FROM cats").Scan(&n)
+	if n != 0 {
+		t.Errorf("Expected uncommitted insert to be invisible, got %d rows", n)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Failed to commit session: %v", err)
+	}
+	s.Release()
+	pool.Reader.QueryRow("SELECT COUNT(*) FROM cats").Scan(&n)
+	if n != 1 {
+		t.Errorf("Expected committed insert to be visible, got %d rows", n)
+	}
+	if _, err := pool.AcquireSession(first.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected committed session to be gone, got %v", err)
+	}
+
+	// A timer firing while a request uses the session does not expire it
+	busy, err := pool.BeginSession()
+	if err != nil {
+		t.Fatalf("Failed to begin session: %v", err)
+	}
+	s, _ = pool.AcquireSession(busy.ID)
+	expired := make(chan struct{})
+	go func() {
+		s.expire()
+		close(expired)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Release()
+	<-expired
+	if s, err := pool.AcquireSession(busy.ID); err != nil {
+		t.Errorf("Expected the session used before its deadline to stay open, got %v", err)
+	} else {
+		s.Release()
+	}
+
+	// Idle sessions are rolled back
+	time.Sleep(400 * time.Millisecond)
+	if count := pool.SessionCount(); count != 0 {
+		t.Errorf("Expected idle sessions to expire, got %d open", count)
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// StatementInfo describes a statement as prepared by SQLite. Columns is the
+// number of columns of the rows it returns, zero for statements returning
+// no rows, and Readonly whether it leaves the database unchanged.
+type StatementInfo struct {
+	Columns  int
+	Readonly bool
+}
+
+// Classify prepares the first statement of query on conn, without running
+// it, and reports its column count and whether it is read-only
+func Classify(conn *sql.Conn, query string) (StatementInfo, error) {
+	var info StatementInfo
+	err := conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		stmt, err := c.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		s := stmt.(*sqlite3.SQLiteStmt)
+		info.Readonly = s.Readonly()
+
+		// Querying binds no parameters and does not step the statement, it
+		// only reads the column count
+		rows, err := s.Query(nil)
+		if err != nil {
+			return err
+		}
+		info.Columns = len(rows.Columns())
+		return rows.Close()
+	})
+	return info, err
+}
@@ -0,0 +1,192 @@
+package types
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Affinity is the type affinity SQLite gives a column from its declared
+// type, see https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+type Affinity int
+
+const (
+	AffinityBlob Affinity = iota
+	AffinityText
+	AffinityNumeric
+	AffinityInteger
+	AffinityReal
+)
+
+// String returns the SQL name of the affinity
+func (a Affinity) String() string {
+	switch a {
+	case AffinityText:
+		return "TEXT"
+	case AffinityNumeric:
+		return "NUMERIC"
+	case AffinityInteger:
+		return "INTEGER"
+	case AffinityReal:
+		return "REAL"
+	}
+	return "BLOB"
+}
+
+// AffinityOf applies SQLite's rules to a declared type, in order: INT gives
+// INTEGER, CHAR, CLOB or TEXT give TEXT, BLOB or no type give BLOB, REAL,
+// FLOA or DOUB give REAL and anything else NUMERIC
+func AffinityOf(declared string) Affinity {
+	declared = strings.ToUpper(declared)
+	switch {
+	case strings.Contains(declared, "INT"):
+		return AffinityInteger
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"), strings.Contains(declared, "TEXT"):
+		return AffinityText
+	case strings.Contains(declared, "BLOB"), strings.TrimSpace(declared) == "":
+		return AffinityBlob
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// Bind converts a value given as text, such as a filter value from a URL,
+// into the value compared with a column of this affinity. INTEGER, REAL and
+// NUMERIC columns convert text to numbers themselves and TEXT columns
+// compare text, so they get the text as is. BLOB columns and expressions
+// have no affinity to convert it, so text reading as a number is bound as
+// one, the way SQLite stores it in NUMERIC columns.
+func (a Affinity) Bind(text string) interface{} {
+	if a != AffinityBlob {
+		return text
+	}
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	return text
+}
+
+// kind is the JSON representation of a column, for the declared types that
+// SQLite has no storage class for
+type kind int
+
+const (
+	kindStored kind = iota
+	kindBoolean
+	kindDate
+	kindDateTime
+	kindJSON
+)
+
+// kindOf reads the representation from the first word of a declared type
+func kindOf(declared string) kind {
+	name := strings.ToUpper(strings.TrimSpace(declared))
+	if end := strings.IndexAny(name, " ("); end >= 0 {
+		name = name[:end]
+	}
+	switch name {
+	case "BOOLEAN", "BOOL":
+		return kindBoolean
+	case "DATE":
+		return kindDate
+	case "DATETIME", "TIMESTAMP":
+		return kindDateTime
+	case "JSON", "JSONB":
+		return kindJSON
+	}
+	return kindStored
+}
+
+// Codec converts the values read from a column into JSON values. Values are
+// read with the storage class SQLite holds them in, so a value whose stored
+// type differs from the declared one, such as text in an INTEGER column, is
+// returned as stored:
+//   - INTEGER, REAL and NUMERIC values are numbers and TEXT values strings
+//   - BLOB values are bytes, encoded as base64 in JSON
+//   - BOOLEAN integers are booleans
+//   - DATE values are "2006-01-02" dates, or RFC 3339 timestamps when they
+//     have a time of day, and DATETIME and TIMESTAMP values RFC 3339
+//     timestamps
+//   - JSON text holding valid JSON is embedded as is
+type Codec struct {
+	Declared string
+	Affinity Affinity
+	kind     kind
+}
+
+// NewCodec returns the codec of a column declared with the given type, empty
+// for expressions
+func NewCodec(declared string) Codec {
+	return Codec{Declared: declared, Affinity: AffinityOf(declared), kind: kindOf(declared)}
+}
+
+// Codecs returns the codecs of the columns of a result set
+func Codecs(columnTypes []*sql.ColumnType) []Codec {
+	codecs := make([]Codec, len(columnTypes))
+	for i, columnType := range columnTypes {
+		codecs[i] = NewCodec(columnType.DatabaseTypeName())
+	}
+	return codecs
+}
+
+// Decode converts a value scanned into an interface{} from the column
+func (c Codec) Decode(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		if c.kind == kindBoolean {
+			return v != 0
+		}
+	case string:
+		if c.kind == kindJSON && json.Valid([]byte(v)) {
+			return json.RawMessage(v)
+		}
+	case time.Time:
+		// The driver parses the values of DATE, DATETIME and TIMESTAMP
+		// columns, whether stored as text or unix time
+		if c.kind == kindDate && v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+// DecodeStored converts a value read as SQLite stores it, without the
+// conversions the driver makes when scanning rows, such as the values given
+// by the preupdate hook. Text is then given as bytes: it is told from blobs
+// by the column's affinity, and in columns without a declared type by being
+// valid UTF-8. Dates are parsed as the driver parses them.
+func (c Codec) DecodeStored(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok && (c.Affinity != AffinityBlob || c.Declared == "" && utf8.Valid(b)) {
+		value = string(b)
+	}
+	if c.kind == kindDate || c.kind == kindDateTime {
+		switch v := value.(type) {
+		case int64:
+			// Unix times in milliseconds are too large to be in seconds
+			if v > 1e12 || v < -1e12 {
+				value = time.UnixMilli(v).UTC()
+			} else {
+				value = time.Unix(v, 0).UTC()
+			}
+		case string:
+			for _, format := range sqlite3.SQLiteTimestampFormats {
+				if t, err := time.ParseInLocation(format, strings.TrimSuffix(v, "Z"), time.UTC); err == nil {
+					value = t
+					break
+				}
+			}
+		}
+	}
+	return c.Decode(value)
+}
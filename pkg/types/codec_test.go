@@ -0,0 +1,112 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAffinityOf(t *testing.T) {
+	for declared, want := range map[string]Affinity{
+		"INTEGER":          AffinityInteger,
+		"UNSIGNED INTEGER": AffinityInteger,
+		"BIGINT":           AffinityInteger,
+		"POINT":            AffinityInteger,
+		"VARCHAR(255)":     AffinityText,
+		"NCHAR(55)":        AffinityText,
+		"CLOB":             AffinityText,
+		"BLOB":             AffinityBlob,
+		"":                 AffinityBlob,
+		"DOUBLE PRECISION": AffinityReal,
+		"FLOATING POINT":   AffinityInteger,
+		"DECIMAL(10,2)":    AffinityNumeric,
+		"BOOLEAN":          AffinityNumeric,
+		"DATETIME":         AffinityNumeric,
+		"STRING":           AffinityNumeric,
+	} {
+		if got := AffinityOf(declared); got != want {
+			t.Errorf("Expected %s affinity for %q, got %s", want, declared, got)
+		}
+	}
+}
+
+func TestAffinityBind(t *testing.T) {
+	for _, tc := range []struct {
+		affinity Affinity
+		text     string
+		want     interface{}
+	}{
+		{AffinityBlob, "5", int64(5)},
+		{AffinityBlob, "-2.5", -2.5},
+		{AffinityBlob, "five", "five"},
+		{AffinityBlob, "Inf", "Inf"},
+		{AffinityInteger, "5", "5"},
+		{AffinityText, "5", "5"},
+	} {
+		if got := tc.affinity.Bind(tc.text); got != tc.want {
+			t.Errorf("Expected %#v for %q with %s affinity, got %#v", tc.want, tc.text, tc.affinity, got)
+		}
+	}
+}
+
+func TestCodecDecode(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	moment := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		declared string
+		value    interface{}
+		want     interface{}
+	}{
+		{"INTEGER", int64(42), int64(42)},
+		{"INTEGER", "forty-two", "forty-two"},
+		{"DECIMAL(10,2)", 10.5, 10.5},
+		{"DECIMAL(10,2)", int64(10), int64(10)},
+		{"VARCHAR(255)", "cat", "cat"},
+		{"BLOB", []byte{0, 1}, []byte{0, 1}},
+		{"TEXT", []byte("raw"), []byte("raw")},
+		{"", int64(3), int64(3)},
+		{"BOOLEAN", int64(1), true},
+		{"BOOL", int64(0), false},
+		{"BOOLEAN", true, true},
+		{"BOOLEAN", "yes", "yes"},
+		{"DATE", date, "2024-03-01"},
+		{"DATE", moment, "2024-03-01T12:30:00Z"},
+		{"DATETIME", date, "2024-03-01T00:00:00Z"},
+		{"TIMESTAMP", moment, "2024-03-01T12:30:00Z"},
+		{"JSON", `{"a": [1, 2]}`, json.RawMessage(`{"a": [1, 2]}`)},
+		{"JSON", "not json", "not json"},
+		{"INTEGER", nil, nil},
+	} {
+		if got := NewCodec(tc.declared).Decode(tc.value); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Expected %#v for %#v in a %q column, got %#v", tc.want, tc.value, tc.declared, got)
+		}
+	}
+}
+
+func TestCodecDecodeStored(t *testing.T) {
+	for _, tc := range []struct {
+		declared string
+		value    interface{}
+		want     interface{}
+	}{
+		{"TEXT", []byte("cat"), "cat"},
+		{"INTEGER", []byte("forty-two"), "forty-two"},
+		{"BLOB", []byte("raw"), []byte("raw")},
+		{"", []byte("hi"), "hi"},
+		{"", []byte{0xff, 0xfe}, []byte{0xff, 0xfe}},
+		{"", int64(3), int64(3)},
+		{"BOOLEAN", int64(1), true},
+		{"DATE", []byte("2024-03-01"), "2024-03-01"},
+		{"DATETIME", []byte("2024-03-01 12:30:00"), "2024-03-01T12:30:00Z"},
+		{"TIMESTAMP", int64(1709296200), "2024-03-01T12:30:00Z"},
+		{"TIMESTAMP", int64(1709296200000), "2024-03-01T12:30:00Z"},
+		{"DATE", []byte("soon"), "soon"},
+		{"JSON", []byte(`[1]`), json.RawMessage(`[1]`)},
+	} {
+		if got := NewCodec(tc.declared).DecodeStored(tc.value); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Expected %#v for %#v in a %q column, got %#v", tc.want, tc.value, tc.declared, got)
+		}
+	}
+}
@@ -26,7 +26,7 @@ PLATFORMS=(
 )
 
 # Build flags for optimization and smaller binaries
-BUILD_FLAGS="-trimpath -tags netgo"
+BUILD_FLAGS="-trimpath -tags netgo,sqlite_fts5,sqlite_preupdate_hook"
 # Define LDFLAGS separately
 LDFLAGS="-s -w -X main.VERSION=${VERSION}"
 